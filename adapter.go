@@ -15,213 +15,2555 @@
 package adapter
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
 	"github.com/casbin/casbin/model"
 	"github.com/casbin/casbin/persist"
-	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+// SaveMode selects how SavePolicy clears the table before writing the model.
+type SaveMode int
+
+const (
+	// SaveModeTruncate empties the table with TRUNCATE, leaving its indexes in place.
+	SaveModeTruncate SaveMode = iota
+	// SaveModeDrop drops and recreates the table. The adapter's managed
+	// indexes are recreated along with it; any other indexes are lost.
+	SaveModeDrop
 )
 
-// Adapter represents the MySQL adapter for policy storage.
+// Adapter represents the PostgreSQL adapter for policy storage.
 type Adapter struct {
-	user     string
-	password string
-	database string
-	db       *pg.DB
+	options            pg.Options
+	sslRootCert        pemSource
+	sslCert            pemSource
+	sslKey             pemSource
+	saveMode           SaveMode
+	maxRetries         int
+	createRetries      int
+	retryable          func(error) bool
+	isolation          IsolationLevel
+	softDelete         bool
+	timestamps         bool
+	incrementalOverlap time.Duration
+	clock              func() time.Time
+	nullUnused         bool
+	readOnly           bool
+	noCreate           bool
+	autoMigrate        bool
+	normalize          func(value string) string
+	progress           func(written, total int)
+	progressEvery      int
+	lockTimeout        time.Duration
+	arraySep           string
+	arrayCols          []int
+	packed             []string
+	keepalive          time.Duration
+	ageJitter          float64
+	stopKeepalive      func()
+	poolStatsEvery     time.Duration
+	stopPoolStats      func()
+	tagFunc            func(ctx context.Context) string
+	auditActor         func(ctx context.Context) string
+	auditTable         string
+	snapshotOnSave     bool
+	historyTable       string
+	unknownPTypes      UnknownPTypeMode
+	tenantFunc         func(ctx context.Context) (string, error)
+	rlsSetting         string
+	ctx                context.Context
+	ctxTimeout         time.Duration
+	notifyChannel      string
+	filtered           bool
+	strictSchema       bool
+	minWidth           int
+	placeholder        string
+	hasPlaceholder     bool
+	noPrepare          bool
+	ptypes             []string
+	indexes            []Index
+	storageParams      map[string]string
+	columnNamer        func(field string) string
+	tablePrefix        string
+	table              string
+	readFrom           string
+	pageSize           int
+	missingAsEmpty     bool
+	loadFilter         *loadFilter
+	sectionTables      map[string]string
+	secColumn          bool
+	idColumn           bool
+	idSequence         string
+	ruleHash           bool
+	conflictTarget     *ConflictTarget
+	upsert             bool
+	upsertCols         []string
+	cols               []string
+	logger             Logger
+	metrics            Metrics
+	beforeInsert       RuleHook
+	beforeUpdate       RuleHook
+	writes             *writeBuffer
+	queryHooks         []pg.QueryHook
+	onConnect          []string
+	db                 *pg.DB
+	server             ServerInfo
+	tx                 *pg.Tx
+	stmts              *stmtCache
+}
+
+// ErrReadOnly is returned by the methods that change the policy of an
+// adapter created WithReadOnly.
+var ErrReadOnly = errors.New("adapter: read-only adapter")
+
+// WithReadOnly makes every method that would change the table return
+// ErrReadOnly without touching the database, for instances that must only
+// ever read the policy. Loads work as usual. Open does not create the table.
+func WithReadOnly() Option {
+	return func(a *Adapter) {
+		a.readOnly = true
+	}
 }
 
-// NewAdapter is the constructor for Adapter.
-func NewAdapter(user string, password string, database string, addr string) *Adapter {
+// Option configures an Adapter.
+type Option func(*Adapter)
+
+// WithSaveMode sets how SavePolicy clears the table. The default is SaveModeTruncate.
+func WithSaveMode(mode SaveMode) Option {
+	return func(a *Adapter) {
+		a.saveMode = mode
+	}
+}
+
+// NewAdapter is the constructor for Adapter. addr is host:port, where host
+// may be a name, an IPv4 address or an IPv6 address in brackets; the port
+// defaults to 5432.
+func NewAdapter(user string, password string, database string, addr string, opts ...Option) *Adapter {
+	return newAdapter(pg.Options{
+		User:     user,
+		Password: password,
+		Database: database,
+		Addr:     normalizeAddr(addr),
+	}, opts...)
+}
+
+func newAdapter(options pg.Options, opts ...Option) *Adapter {
 	a := Adapter{}
-	a.user = user
-	a.password = password
-	a.database = database
-	a.addr = addr
+	a.options = options
+	a.stmts = &stmtCache{}
+	a.incrementalOverlap = defaultIncrementalOverlap
+
+	for _, opt := range opts {
+		opt(&a)
+	}
+
+	namer := a.columnNamer
+	if namer == nil {
+		namer = DefaultColumnNamer
+	}
+	for _, field := range ruleFields {
+		a.cols = append(a.cols, namer(field))
+	}
+	a.table = a.tablePrefix + "x_policy"
+	a.auditTable = a.tablePrefix + "x_policy_audit"
+	a.historyTable = a.tablePrefix + "x_policy_history"
+	for sec, table := range a.sectionTables {
+		a.sectionTables[sec] = a.tablePrefix + table
+	}
 
 	return &a
 }
 
-func (a *Adapter) open() {
+// WithTablePrefix stores the policy in <prefix>x_policy instead of x_policy,
+// for databases whose tables follow a naming convention. The indexes and
+// constraint the adapter manages are named after the table. Open fails if
+// the result is not a plain SQL identifier.
+func WithTablePrefix(prefix string) Option {
+	return func(a *Adapter) {
+		a.tablePrefix = prefix
+	}
+}
 
-	db := pg.Connect(&pg.Options{
-		User:     a.user,
-		Password: a.password,
-		Database: a.database,
-		Addr:     a.addr,
-	})
-	a.db = db
+// ruleFields are the CasbinRule fields stored in the table, in column order.
+var ruleFields = []string{"PType", "V0", "V1", "V2", "V3", "V4", "V5"}
 
-	a.createTable()
+// DefaultColumnNamer is the column naming used unless WithColumnNamer says
+// otherwise: p_type for PType and v0 to v5 for V0 to V5.
+func DefaultColumnNamer(field string) string {
+	if field == "PType" {
+		return "p_type"
+	}
+	return strings.ToLower(field)
 }
 
-func (a *Adapter) close() {
-	a.db.Close()
+// WithColumnNamer maps each CasbinRule field (PType, V0, ..., V5) to a
+// column name with namer, for tables from other frameworks whose columns
+// follow their own convention. The names are used in the table definition
+// and in every query; Open fails if they are not plain, distinct SQL
+// identifiers.
+func WithColumnNamer(namer func(field string) string) Option {
+	return func(a *Adapter) {
+		a.columnNamer = namer
+	}
+}
+
+// checkColumns validates the names the column namer produced.
+func (a *Adapter) checkColumns() error {
+	seen := make(map[string]bool)
+	for i, col := range a.cols {
+		if !identifierRe.MatchString(col) {
+			return fmt.Errorf("adapter: invalid column name %q for %s", col, ruleFields[i])
+		}
+		if seen[col] {
+			return fmt.Errorf("adapter: column name %q used twice", col)
+		}
+		seen[col] = true
+	}
+	return nil
+}
+
+// ptypeCol returns the name of the p_type column.
+func (a *Adapter) ptypeCol() string {
+	return a.cols[0]
+}
+
+// valueCol returns the name of the column holding value i of a rule.
+func (a *Adapter) valueCol(i int) string {
+	return a.cols[i+1]
+}
+
+// WithPoolSize sets the maximum number of connections in the pool. The
+// go-pg default is ten per CPU.
+func WithPoolSize(n int) Option {
+	return func(a *Adapter) {
+		a.options.PoolSize = n
+	}
+}
+
+// WithPoolTimeout bounds how long an operation waits for a free connection
+// when all of them are busy, after which it fails with go-pg's "connection
+// pool timeout" error. This is separate from the dial timeout. The go-pg
+// default is 30 seconds.
+func WithPoolTimeout(d time.Duration) Option {
+	return func(a *Adapter) {
+		a.options.PoolTimeout = d
+	}
+}
+
+// WithWarmup makes Open establish n connections up front, and keeps at
+// least n idle connections in the pool afterwards, so the first requests
+// after a deploy do not pay for dialing.
+func WithWarmup(n int) Option {
+	return func(a *Adapter) {
+		a.options.MinIdleConns = n
+	}
+}
+
+// WithMaxConnAge makes the pool close a connection once it is d old, when
+// next it is idle, so that connections move over to replicas added behind a
+// load balancer or pick up changed server settings. The go-pg default of 0
+// keeps connections for good.
+func WithMaxConnAge(d time.Duration) Option {
+	return func(a *Adapter) {
+		a.options.MaxConnAge = d
+	}
+}
+
+// WithMaxConnLifetimeJitter varies the age of WithMaxConnAge by up to
+// fraction of it either way, so that connections opened together, by the
+// replicas of a deploy say, do not all expire and reconnect at once: with
+// an age of an hour and a fraction of 0.1, the age is between 54 and 66
+// minutes. go-pg applies one age to the whole pool, so the age is drawn
+// anew each time the adapter opens, spreading the reconnects of separate
+// adapters and processes rather than of the connections of one pool. The
+// fraction is clamped to between 0 and 1.
+func WithMaxConnLifetimeJitter(fraction float64) Option {
+	return func(a *Adapter) {
+		a.ageJitter = fraction
+	}
+}
+
+// jitterAge returns age varied by fraction of it either way, r in [0, 1)
+// choosing where in that range.
+func jitterAge(age time.Duration, fraction, r float64) time.Duration {
+	if fraction < 0 {
+		fraction = 0
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	return age + time.Duration(float64(age)*fraction*(2*r-1))
+}
+
+// WithQueryHook registers go-pg query hooks on the adapter's connection
+// pool, for tagging queries, setting per-tenant session state and the like.
+// This is an escape hatch: hooks see every query the adapter runs, and the
+// adapter makes no promise about which queries those are.
+func WithQueryHook(hooks ...pg.QueryHook) Option {
+	return func(a *Adapter) {
+		a.queryHooks = append(a.queryHooks, hooks...)
+	}
 }
 
-func (a *Adapter) createTable() {
+// connect creates the connection pool of Open. Tests replace it to count
+// pools.
+var connect = pg.Connect
 
-	_, err := a.db.Exec("CREATE table IF NOT EXISTS x_policy (p_type VARCHAR(10), v0 VARCHAR(256), v1 VARCHAR(256), v2 VARCHAR(256), v3 VARCHAR(256), v4 VARCHAR(256), v5 VARCHAR(256))")
+// Open connects to the database, creates the policy table if needed and warms
+// up the pool, giving up when ctx is done. It fails for a server older than
+// the adapter or one of its options supports, as TestConnection does. The
+// other methods open the adapter on first use, so Open is only needed to
+// choose when that happens and to get an error instead of a panic. Calling
+// Open on an open adapter does nothing.
+func (a *Adapter) Open(ctx context.Context) error {
+	if a.db != nil {
+		return nil
+	}
+	for _, b := range a.sections() {
+		if !identifierRe.MatchString(b.table) {
+			return fmt.Errorf("adapter: invalid table name %q", b.table)
+		}
+	}
+	if a.auditActor != nil && !identifierRe.MatchString(a.auditTable) {
+		return fmt.Errorf("adapter: invalid table name %q", a.auditTable)
+	}
+	if a.snapshotOnSave && !identifierRe.MatchString(a.historySeq()) {
+		return fmt.Errorf("adapter: invalid table name %q", a.historyTable)
+	}
+	if err := checkAddr(a.options.Addr); err != nil {
+		return err
+	}
+	if a.readFrom != "" {
+		if a.sectionTables != nil {
+			return errSectionTables("WithReadFrom")
+		}
+		if !identifierRe.MatchString(a.readFrom) {
+			return fmt.Errorf("adapter: invalid table name %q", a.readFrom)
+		}
+	}
+	if a.idSequence != "" && !sequenceRe.MatchString(a.idSequence) {
+		return fmt.Errorf("adapter: invalid sequence name %q", a.idSequence)
+	}
+	if a.autoMigrate && (a.readOnly || a.noCreate) {
+		return fmt.Errorf("adapter: WithAutoMigrateOnVersionMismatch cannot be combined with WithReadOnly or WithoutCreateTable")
+	}
+	if a.hasPlaceholder && a.placeholder == "" {
+		return fmt.Errorf("adapter: empty WithValuePlaceholder")
+	}
+	if a.rlsSetting != "" && a.tenantFunc == nil {
+		return fmt.Errorf("adapter: WithRowLevelSecurity requires WithTenantFromContext")
+	}
+	if err := a.checkColumns(); err != nil {
+		return err
+	}
+	if err := a.checkIndexes(); err != nil {
+		return err
+	}
+	if err := a.checkArrayColumns(); err != nil {
+		return err
+	}
+	if err := a.checkLoadFilter(); err != nil {
+		return err
+	}
+	if err := a.checkConflictTarget(); err != nil {
+		return err
+	}
+	if err := a.checkUpsert(); err != nil {
+		return err
+	}
+	if err := a.checkPacked(); err != nil {
+		return err
+	}
+	if err := a.checkOnConnect(); err != nil {
+		return err
+	}
+	if err := a.checkStorageParams(); err != nil {
+		return err
+	}
+	options, err := a.connectOptions()
 	if err != nil {
-		panic(err)
+		return err
+	}
+	options.MaxConnAge = jitterAge(options.MaxConnAge, a.ageJitter, rand.Float64())
+	db := connect(&options)
+	if a.logger != nil {
+		db.AddQueryHook(queryLogger{a.logger})
+	}
+	for _, hook := range a.queryHooks {
+		db.AddQueryHook(hook)
 	}
+
+	if err := a.openTables(ctx, db); err != nil {
+		db.Close()
+		return err
+	}
+	if a.autoMigrate {
+		if err := a.migrate(ctx, db); err != nil {
+			db.Close()
+			return err
+		}
+	}
+	if err := warmup(ctx, db, a.options.MinIdleConns); err != nil {
+		db.Close()
+		return err
+	}
+
+	a.db = db
+	a.startKeepalive(db)
+	a.startPoolStats(db)
+	return nil
 }
 
-func (a *Adapter) dropTable() {
-	_, err := a.db.Exec("DROP table x_policy")
+// connectOptions returns the options the adapter connects with: those of
+// NewAdapter with the statements of WithOnConnect and the TLS configuration
+// of WithSSLRootCert and WithSSLClientCert applied.
+func (a *Adapter) connectOptions() (pg.Options, error) {
+	tlsConfig, err := a.tlsConfig()
 	if err != nil {
-		panic(err)
+		return pg.Options{}, err
 	}
+	options := a.withOnConnect(a.options)
+	options.TLSConfig = tlsConfig
+	return options, nil
 }
 
-func loadPolicyLine(line CasbinRule, model model.Model) {
+// warmup initializes n connections by holding n transactions open at once,
+// then rolls them back so the connections return to the pool idle.
+func warmup(ctx context.Context, db *pg.DB, n int) error {
+	txs := make([]*pg.Tx, 0, n)
+	defer func() {
+		for _, tx := range txs {
+			tx.Rollback()
+		}
+	}()
 
-	lineText := line.PType
-	if line.V0 != "" {
-		lineText += ", " + line.V0
+	for i := 0; i < n; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		tx, err := db.BeginContext(ctx)
+		if err != nil {
+			return err
+		}
+		txs = append(txs, tx)
 	}
-	if line.V1 != "" {
-		lineText += ", " + line.V1
+	return nil
+}
+
+func (a *Adapter) open() {
+	if err := a.Open(context.Background()); err != nil {
+		panic(err)
 	}
-	if line.V2 != "" {
-		lineText += ", " + line.V2
+}
+
+func (a *Adapter) close() {
+	a.Close()
+}
+
+// Close writes the rules WithWriteBuffer has queued, stops the keepalive
+// and pool statistics loops, if any, and closes the adapter's connections.
+// If the rules cannot be written, the adapter is closed all the same, the
+// rules are lost and Close returns the error. An adapter that is not open is
+// left alone. The adapter opens again on next use.
+func (a *Adapter) Close() error {
+	if a.db == nil {
+		return nil
 	}
-	if line.V3 != "" {
-		lineText += ", " + line.V3
+	ctx, cancel := a.context()
+	defer cancel()
+	flushErr := a.Flush(ctx)
+	if a.stopKeepalive != nil {
+		a.stopKeepalive()
+		a.stopKeepalive = nil
 	}
-	if line.V4 != "" {
-		lineText += ", " + line.V4
+	if a.stopPoolStats != nil {
+		a.stopPoolStats()
+		a.stopPoolStats = nil
 	}
-	if line.V5 != "" {
-		lineText += ", " + line.V5
+	a.closeStmts()
+	err := a.db.Close()
+	a.db = nil
+	if flushErr != nil {
+		return flushErr
 	}
+	return err
+}
 
-	persist.LoadPolicyLine(lineText, model)
+// EnsureTable creates the policy table and the columns, constraint and
+// indexes the options call for, where they are missing. Open does this
+// already; EnsureTable is for running it again, after the table was dropped
+// by hand, say. Concurrent calls, from replicas starting together, all
+// succeed.
+func (a *Adapter) EnsureTable(ctx context.Context) error {
+	if a.readOnly {
+		return ErrReadOnly
+	}
+	if a.db == nil {
+		if err := a.Open(ctx); err != nil || !a.noCreate {
+			return err
+		}
+	}
+	return a.createTables(ctx, a.conn(ctx))
 }
 
-func savePolicyLine(ptype string, rule []string) CasbinRule {
-	line := CasbinRule{}
+func (a *Adapter) createTable(db orm.DB) error {
+	var existed bool
+	if _, err := db.QueryOne(pg.Scan(&existed), "SELECT to_regclass(?) IS NOT NULL", a.table); err != nil {
+		return err
+	}
+	err := ddl(db, "CREATE table IF NOT EXISTS "+a.table+" ("+strings.Join(a.columnDefs(), ", ")+")"+a.storageClause())
+	if err != nil {
+		return err
+	}
 
-	line.PType = ptype
-	if len(rule) > 0 {
-		line.V0 = rule[0]
+	if a.softDelete {
+		err = ddl(db, "ALTER TABLE "+a.table+" ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ")
+		if err != nil {
+			return err
+		}
 	}
-	if len(rule) > 1 {
-		line.V1 = rule[1]
+
+	if a.tenantFunc != nil {
+		err = ddl(db, "ALTER TABLE "+a.table+" ADD COLUMN IF NOT EXISTS tenant VARCHAR(256) NOT NULL DEFAULT ''")
+		if err != nil {
+			return err
+		}
 	}
-	if len(rule) > 2 {
-		line.V2 = rule[2]
+
+	if a.secColumn {
+		if err := a.createSectionColumn(db); err != nil {
+			return err
+		}
 	}
-	if len(rule) > 3 {
-		line.V3 = rule[3]
+
+	if a.timestamps {
+		err = ddl(db, "ALTER TABLE "+a.table+" ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now(), "+
+			"ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now()")
+		if err != nil {
+			return err
+		}
 	}
-	if len(rule) > 4 {
-		line.V4 = rule[4]
+
+	if a.idColumn {
+		if err := a.createIDColumn(db); err != nil {
+			return err
+		}
 	}
-	if len(rule) > 5 {
-		line.V5 = rule[5]
+
+	if a.ruleHash {
+		if err := a.createRuleHash(db); err != nil {
+			return err
+		}
 	}
 
-	return line
-}
+	if len(a.ptypes) > 0 {
+		if err := a.createPTypeConstraint(db); err != nil {
+			return err
+		}
+	}
 
-type CasbinRule struct {
-	TableName struct{} `sql:"x_policy" pg:",discard_unknown_columns" `
-	PType     string   `sql:",pType" db:"p_type" `
-	V0        string   `sql:",v0" db:"v0" `
-	V1        string   `sql:",v1" db:"v1" `
-	V2        string   `sql:",v2" db:"v2" `
-	V3        string   `sql:",v3" db:"v3" `
-	V4        string   `sql:",v4" db:"v4" `
-	V5        string   `sql:",v5" db:"v5" `
+	if err := a.createIndexes(db); err != nil {
+		return err
+	}
+
+	// A table that predates the marker is on the first schema version.
+	version := CurrentSchemaVersion
+	if existed {
+		version = 1
+	}
+	return a.createVersionTable(db, version)
 }
 
-// LoadPolicy loads policy from database.
-func (a *Adapter) LoadPolicy(model model.Model) error {
+// columnDefs returns the definitions of the ptype and value columns.
+func (a *Adapter) columnDefs() []string {
+	defs := []string{a.ptypeCol() + " VARCHAR(32)"}
+	for i := 0; i < 6; i++ {
+		if a.isArray(i) {
+			defs = append(defs, a.valueCol(i)+" TEXT[]")
+		} else {
+			defs = append(defs, a.valueCol(i)+" VARCHAR(256)")
+		}
+	}
+	return defs
+}
 
-	a.open()
-	// defer a.close()
+// WithPTypeConstraint adds a CHECK constraint named <table>_p_type_check
+// limiting p_type to ptypes, so a typo such as "pp" is rejected by the
+// database instead of being stored. PTypes lists the ptypes of a model. The
+// constraint is added on open if the table lacks it; an existing constraint
+// is left alone, so drop it to change the allowed set.
+func WithPTypeConstraint(ptypes ...string) Option {
+	return func(a *Adapter) {
+		a.ptypes = ptypes
+	}
+}
 
-	var lines []CasbinRule
-	sqlstr := "select * from policy"
+// PTypes returns the ptypes defined in the policy and role sections of m,
+// sorted.
+func PTypes(m model.Model) []string {
+	var ptypes []string
+	for _, sec := range []string{"p", "g"} {
+		for ptype := range m[sec] {
+			ptypes = append(ptypes, ptype)
+		}
+	}
+	sort.Strings(ptypes)
+	return ptypes
+}
 
-	_, err := a.db.Query(&lines, sqlstr)
-	if err != nil {
+func (a *Adapter) createPTypeConstraint(db orm.DB) error {
+	var exists bool
+	_, err := db.QueryOne(pg.Scan(&exists),
+		"SELECT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = ?::regclass AND conname = ?)", a.table, a.table+"_p_type_check")
+	if err != nil || exists {
 		return err
 	}
 
-	for _, line := range lines {
-		loadPolicyLine(line, model)
+	err = ddl(db, "ALTER TABLE "+a.table+" ADD CONSTRAINT "+a.table+"_p_type_check CHECK ("+a.ptypeCol()+" IN (?))", pg.In(a.ptypes))
+	return err
+}
+
+// managedIndexes are the indexes the adapter owns on the policy table, named
+// after the table plus suffix. They are created with the table, so a
+// drop-mode SavePolicy restores them too. Columns are given by their
+// position in ruleFields.
+var managedIndexes = []struct {
+	suffix  string
+	columns []int
+}{
+	{"_p_type_idx", []int{0}},
+	{"_p_type_v0_idx", []int{0, 1}},
+}
+
+func (a *Adapter) createIndexes(db orm.DB) error {
+	for _, idx := range managedIndexes {
+		var cols []string
+		for _, i := range idx.columns {
+			cols = append(cols, a.cols[i])
+		}
+		err := ddl(db, "CREATE INDEX "+a.ifNotExists()+a.table+idx.suffix+" ON "+a.table+" ("+strings.Join(cols, ", ")+")")
+		if err != nil {
+			return err
+		}
+	}
+	for _, idx := range a.indexes {
+		query := "CREATE INDEX " + a.ifNotExists() + idx.Name + " ON " + a.table + " (" + strings.Join(idx.Columns, ", ") + ")"
+		if idx.Where != "" {
+			query += " WHERE " + idx.Where
+		}
+		if err := ddl(db, query); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-// SavePolicy saves policy to database.
-func (a *Adapter) SavePolicy(model model.Model) error {
-	a.open()
-	// defer a.close()
+// ddl runs a CREATE or ALTER statement of createTable. When several
+// processes create the same object at once, IF NOT EXISTS does not stop the
+// losers from failing with duplicate_table (42P07), duplicate_object (42710)
+// or a unique_violation (23505) in the catalog; the object exists either way,
+// so these count as success. Inside a transaction they still abort it.
+func ddl(db orm.DB, query string, params ...interface{}) error {
+	_, err := db.Exec(query, params...)
+	if pgErr, ok := err.(pg.Error); ok {
+		switch pgErr.Field('C') {
+		case "42P07", "42710", "23505":
+			return nil
+		}
+	}
+	return err
+}
+
+// WithStorageParams sets storage parameters of the policy table, such as
+// {"fillfactor": "70"} for a table updated often under WithSoftDelete or
+// WithTimestamps, or autovacuum settings. They go in the WITH clause of
+// CREATE TABLE, so a table that exists already keeps its own; change those
+// with ALTER TABLE ... SET. Open fails if a name or value is not a plain
+// word or number.
+func WithStorageParams(params map[string]string) Option {
+	return func(a *Adapter) {
+		a.storageParams = params
+	}
+}
 
-	a.dropTable()
-	a.createTable()
+var storageParamRe = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
 
-	for ptype, ast := range model["p"] {
-		for _, rule := range ast.Policy {
-			line := savePolicyLine(ptype, rule)
-			err := a.db.Insert(&line)
-			if err != nil {
-				return err
-			}
+// checkStorageParams validates the parameters given to WithStorageParams.
+func (a *Adapter) checkStorageParams() error {
+	for name, value := range a.storageParams {
+		if !storageParamRe.MatchString(name) {
+			return fmt.Errorf("adapter: invalid storage parameter %q", name)
+		}
+		if !storageParamRe.MatchString(value) {
+			return fmt.Errorf("adapter: invalid value %q for storage parameter %s", value, name)
 		}
 	}
+	return nil
+}
 
-	for ptype, ast := range model["g"] {
-		for _, rule := range ast.Policy {
-			line := savePolicyLine(ptype, rule)
-			err := a.db.Insert(&line)
-			if err != nil {
-				return err
+// storageClause returns the WITH clause of CREATE TABLE for the storage
+// parameters, sorted by name, or "" if there are none.
+func (a *Adapter) storageClause() string {
+	if len(a.storageParams) == 0 {
+		return ""
+	}
+	var params []string
+	for name, value := range a.storageParams {
+		params = append(params, name+" = "+value)
+	}
+	sort.Strings(params)
+	return " WITH (" + strings.Join(params, ", ") + ")"
+}
+
+// Index is an index on the policy table declared with WithIndexes.
+type Index struct {
+	// Name is the name of the index.
+	Name string
+	// Columns are the database names of the indexed columns, in order.
+	Columns []string
+	// Where, if set, makes the index partial: it is the SQL predicate
+	// selecting the rows indexed, such as "v1 IN ('tenant1', 'tenant2')". It
+	// is used verbatim and must come from trusted configuration.
+	Where string
+}
+
+// WithIndexes declares indexes the adapter creates along with its own when
+// they do not exist, so operators can index hot rows without DDL of their
+// own that a drop-mode SavePolicy would lose. An existing index of the same
+// name is left alone, so drop it to change its definition.
+func WithIndexes(indexes ...Index) Option {
+	return func(a *Adapter) {
+		a.indexes = append(a.indexes, indexes...)
+	}
+}
+
+// checkIndexes validates the names in the indexes declared with WithIndexes.
+func (a *Adapter) checkIndexes() error {
+	for _, idx := range a.indexes {
+		if !identifierRe.MatchString(idx.Name) {
+			return fmt.Errorf("adapter: invalid index name %q", idx.Name)
+		}
+		if len(idx.Columns) == 0 {
+			return fmt.Errorf("adapter: index %s has no columns", idx.Name)
+		}
+		for _, col := range idx.Columns {
+			if !identifierRe.MatchString(col) {
+				return fmt.Errorf("adapter: invalid column name %q in index %s", col, idx.Name)
 			}
 		}
 	}
-
 	return nil
 }
 
-func (a *Adapter) AddPolicy(sec string, ptype string, rule []string) error {
-
-	line := savePolicyLine(ptype, rule)
-	err := a.db.Insert(&line)
-	if err != nil {
-		return err
+// clearTable removes the rules SavePolicy replaces: those of the tenant, or
+// with soft delete the live ones, or else all of them, by the save mode.
+func (a *Adapter) clearTable(ctx context.Context, tx *pg.Tx) error {
+	if a.softDelete || a.tenantFunc != nil {
+		where, params, err := a.scope(ctx, "TRUE")
+		if err != nil {
+			return err
+		}
+		return a.deleteWhere(ctx, tx, where, params...)
+	}
+	if a.saveMode == SaveModeDrop {
+		if err := a.dropTable(tx); err != nil {
+			return err
+		}
+		return a.createTable(tx)
 	}
+	return a.truncateTable(tx)
+}
+
+func (a *Adapter) dropTable(db orm.DB) error {
+	_, err := db.Exec("DROP table " + a.table)
 	return err
 }
 
-func (a *Adapter) RemovePolicy(sec string, ptype string, rule []string) error {
-	line := savePolicyLine(ptype, rule)
-	err := a.db.Delete(&line) //can't use db.Delete as we're not using primary key http://jinzhu.me/gorm/crud.html#delete
+func (a *Adapter) truncateTable(db orm.DB) error {
+	_, err := db.Exec("TRUNCATE TABLE " + a.table)
 	return err
 }
 
-func (a *Adapter) RemoveFilteredPolicy(sec string, ptype string, fieldIndex int, fieldValues ...string) error {
-	line := CasbinRule{}
+var identifierRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,62}$`)
 
-	line.PType = ptype
-	if fieldIndex <= 0 && 0 < fieldIndex+len(fieldValues) {
-		line.V0 = fieldValues[0-fieldIndex]
+// ArchiveTable renames the policy table to newName and creates a fresh,
+// empty one in its place, in one transaction. The managed indexes move with
+// the archived table under names prefixed by newName, so the new table gets
+// its own.
+func (a *Adapter) ArchiveTable(ctx context.Context, newName string) error {
+	if a.readOnly {
+		return ErrReadOnly
 	}
-	if fieldIndex <= 1 && 1 < fieldIndex+len(fieldValues) {
-		line.V1 = fieldValues[1-fieldIndex]
+	if !identifierRe.MatchString(newName) {
+		return fmt.Errorf("adapter: invalid table name %q", newName)
 	}
-	if fieldIndex <= 2 && 2 < fieldIndex+len(fieldValues) {
-		line.V2 = fieldValues[2-fieldIndex]
+	if a.sectionTables != nil {
+		return errSectionTables("ArchiveTable")
 	}
-	if fieldIndex <= 3 && 3 < fieldIndex+len(fieldValues) {
-		line.V3 = fieldValues[3-fieldIndex]
+	if len(a.packed) > 0 {
+		return errPacked("ArchiveTable")
+	}
+	a.open()
+	if newName == a.table {
+		return fmt.Errorf("adapter: cannot archive %s onto itself", a.table)
 	}
-	if fieldIndex <= 4 && 4 < fieldIndex+len(fieldValues) {
-		line.V4 = fieldValues[4-fieldIndex]
+
+	return a.runInTx(ctx, func(tx *pg.Tx) error {
+		if _, err := tx.Exec("ALTER TABLE " + a.table + " RENAME TO " + newName); err != nil {
+			return err
+		}
+		for _, idx := range managedIndexes {
+			if _, err := tx.Exec("ALTER INDEX IF EXISTS " + a.table + idx.suffix + " RENAME TO " + newName + idx.suffix); err != nil {
+				return err
+			}
+		}
+		return a.createTable(tx)
+	})
+}
+
+// WithTx returns an adapter whose reads and writes run in tx, so that policy
+// changes commit or roll back together with the caller's other work. The
+// returned adapter shares a's configuration and connection pool; it neither
+// commits nor retries tx, and it ignores the isolation level, which is the
+// caller's to set when beginning tx. It must not be used after tx ends.
+func (a *Adapter) WithTx(tx *pg.Tx) *Adapter {
+	a.open()
+
+	b := *a
+	b.tx = tx
+	return &b
+}
+
+// conn returns what the adapter's queries run on: its transaction if it has
+// one, the pool otherwise.
+func (a *Adapter) conn(ctx context.Context) orm.DB {
+	if a.tx != nil {
+		return a.tx
 	}
-	if fieldIndex <= 5 && 5 < fieldIndex+len(fieldValues) {
-		line.V5 = fieldValues[5-fieldIndex]
+	return a.db.WithContext(ctx)
+}
+
+// IsolationLevel is a Postgres transaction isolation level.
+type IsolationLevel string
+
+const (
+	// IsolationDefault leaves the level to the server's default_transaction_isolation.
+	IsolationDefault IsolationLevel = ""
+	// ReadCommitted is READ COMMITTED.
+	ReadCommitted IsolationLevel = "READ COMMITTED"
+	// RepeatableRead is REPEATABLE READ.
+	RepeatableRead IsolationLevel = "REPEATABLE READ"
+	// Serializable is SERIALIZABLE.
+	Serializable IsolationLevel = "SERIALIZABLE"
+)
+
+// WithIsolationLevel sets the isolation level of the adapter's transactions.
+// go-pg always issues a plain BEGIN, so the level is applied with SET
+// TRANSACTION as the first statement, which Postgres treats the same as
+// BEGIN ISOLATION LEVEL.
+func WithIsolationLevel(level IsolationLevel) Option {
+	return func(a *Adapter) {
+		a.isolation = level
 	}
-	err := a.db.Delete(&line)
-	return err
+}
+
+// runInTx runs fn in a transaction at the configured isolation level,
+// retrying the whole transaction on retryable errors. An adapter bound to a
+// transaction by WithTx runs fn in that transaction, once, and leaves
+// committing it to the caller. Under WithRowLevelSecurity the tenant setting
+// is set before fn runs.
+func (a *Adapter) runInTx(ctx context.Context, fn func(tx *pg.Tx) error) error {
+	txFn := fn
+	if a.rlsSetting != "" {
+		txFn = func(tx *pg.Tx) error {
+			if err := a.setTenant(ctx, tx); err != nil {
+				return err
+			}
+			return fn(tx)
+		}
+	}
+	if a.tx != nil {
+		return txFn(a.tx)
+	}
+
+	if a.isolation != IsolationDefault {
+		scoped := txFn
+		txFn = func(tx *pg.Tx) error {
+			if _, err := tx.Exec("SET TRANSACTION ISOLATION LEVEL " + string(a.isolation)); err != nil {
+				return err
+			}
+			return scoped(tx)
+		}
+	}
+
+	return a.retry(func() error {
+		return a.db.RunInTransaction(ctx, txFn)
+	})
+}
+
+func (a *Adapter) loadPolicyLine(line CasbinRule, model model.Model) {
+	persist.LoadPolicyLine(a.lineText(line), model)
+}
+
+// WithValueNormalizer applies normalize to every rule value the adapter
+// writes and to every value it matches rows against, so that values which
+// differ only in, say, trailing spaces or case are stored and found as one:
+// with strings.TrimSpace, adding "alice " and removing "alice" leaves no
+// rule behind. Rules load as stored, that is normalized. ptypes are left
+// alone.
+func WithValueNormalizer(normalize func(value string) string) Option {
+	return func(a *Adapter) {
+		a.normalize = normalize
+	}
+}
+
+func (a *Adapter) normalizeValue(value string) string {
+	if a.normalize == nil {
+		return value
+	}
+	return a.normalize(value)
+}
+
+// normalizeRule returns a copy of rule with each value normalized, leaving
+// rule itself, which may belong to the caller's model, alone.
+func (a *Adapter) normalizeRule(rule []string) []string {
+	if a.normalize == nil || rule == nil {
+		return rule
+	}
+	normalized := make([]string, len(rule))
+	for i, v := range rule {
+		normalized[i] = a.normalize(v)
+	}
+	return normalized
+}
+
+// UnknownPTypeMode says what loads do with a rule whose ptype the model does
+// not define, as happens while a model migration is under way.
+type UnknownPTypeMode int
+
+const (
+	// UnknownPTypeError fails the load, leaving the model untouched. It is
+	// the default.
+	UnknownPTypeError UnknownPTypeMode = iota
+	// UnknownPTypeSkip leaves such rules out, logging each with the logger
+	// set by WithLogger, if any.
+	UnknownPTypeSkip
+)
+
+// WithUnknownPTypes sets what loads do with rules of ptypes the model does
+// not define. The default is UnknownPTypeError.
+func WithUnknownPTypes(mode UnknownPTypeMode) Option {
+	return func(a *Adapter) {
+		a.unknownPTypes = mode
+	}
+}
+
+// knownPType reports whether m defines the ptype of line.
+func knownPType(m model.Model, line CasbinRule) bool {
+	return line.PType != "" && m[line.PType[:1]][line.PType] != nil
+}
+
+// checkPTypes returns an error for the first of lines m does not define the
+// ptype of, unless such rules are to be skipped.
+func (a *Adapter) checkPTypes(m model.Model, lines []CasbinRule) error {
+	if a.unknownPTypes != UnknownPTypeError {
+		return nil
+	}
+	for _, line := range lines {
+		if !knownPType(m, line) {
+			return fmt.Errorf("adapter: ptype %q of rule %q is not defined in the model", line.PType, policyLineText(line))
+		}
+	}
+	return nil
+}
+
+// skipPType reports whether line is to be skipped for its unknown ptype,
+// logging it if so.
+func (a *Adapter) skipPType(m model.Model, line CasbinRule) bool {
+	if knownPType(m, line) {
+		return false
+	}
+	if a.logger != nil {
+		a.logger.Printf("adapter: skipping rule of unknown ptype: %s", policyLineText(line))
+	}
+	return true
+}
+
+// loadLines loads lines into m, handling unknown ptypes as configured.
+func (a *Adapter) loadLines(lines []CasbinRule, m model.Model) error {
+	if err := a.checkPTypes(m, lines); err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if !a.skipPType(m, line) {
+			a.loadPolicyLine(line, m)
+		}
+	}
+	return nil
+}
+
+// policyLineText renders line in the "p, alice, data1, read" form used by
+// casbin policy files.
+func policyLineText(line CasbinRule) string {
+	return strings.Join(append([]string{line.PType}, lineRule(line)...), ", ")
+}
+
+// lineRule returns the non-empty values of line.
+func lineRule(line CasbinRule) []string {
+	var rule []string
+	for _, v := range []string{line.V0, line.V1, line.V2, line.V3, line.V4, line.V5} {
+		if v != "" {
+			rule = append(rule, v)
+		}
+	}
+	return rule
+}
+
+func savePolicyLine(ptype string, rule []string) CasbinRule {
+	line := CasbinRule{}
+
+	line.PType = ptype
+	if len(rule) > 0 {
+		line.V0 = rule[0]
+	}
+	if len(rule) > 1 {
+		line.V1 = rule[1]
+	}
+	if len(rule) > 2 {
+		line.V2 = rule[2]
+	}
+	if len(rule) > 3 {
+		line.V3 = rule[3]
+	}
+	if len(rule) > 4 {
+		line.V4 = rule[4]
+	}
+	if len(rule) > 5 {
+		line.V5 = rule[5]
+	}
+
+	return line
+}
+
+type CasbinRule struct {
+	tableName struct{} `pg:"x_policy,discard_unknown_columns"`
+	PType     string   `pg:"p_type,use_zero"`
+	V0        string   `pg:"v0,use_zero"`
+	V1        string   `pg:"v1,use_zero"`
+	V2        string   `pg:"v2,use_zero"`
+	V3        string   `pg:"v3,use_zero"`
+	V4        string   `pg:"v4,use_zero"`
+	V5        string   `pg:"v5,use_zero"`
+	// Sec is the section of WithSectionColumn, "" without it.
+	Sec string `pg:"sec"`
+}
+
+// ToSlice returns the ptype of r and its rule as the adapter hands rules to
+// casbin: the non-empty values among V0 to V5, in order.
+func (r CasbinRule) ToSlice() (ptype string, rule []string) {
+	return r.PType, lineRule(r)
+}
+
+// RuleToCasbinRule returns the row the adapter stores for rule of ptype,
+// with the values in V0 to V5 and the columns rule does not reach left
+// empty. Values past the sixth are dropped, since the table has no column
+// for them.
+func RuleToCasbinRule(ptype string, rule []string) CasbinRule {
+	return savePolicyLine(ptype, rule)
+}
+
+// LoadPolicy loads policy from database.
+func (a *Adapter) LoadPolicy(model model.Model) error {
+
+	a.open()
+	// defer a.close()
+
+	ctx, cancel := a.context()
+	defer cancel()
+	lines, err := a.loadRules(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	if err := a.loadLines(lines, model); err != nil {
+		return err
+	}
+	a.filtered = false
+	a.observeRows(OpLoad, len(lines))
+	return nil
+}
+
+// LoadPolicyWithContext replaces the policy in m with the rules in the
+// table, read with ctx. Unlike LoadPolicy, which appends to m as casbin
+// expects after clearing it itself, it clears the p and g sections of m
+// first, as casbin's ClearPolicy does, and loads each distinct rule once
+// however many rows hold it, so that m ends up a fresh view of the table
+// whatever casbin version, if any, calls it. m is left alone if the read
+// fails.
+func (a *Adapter) LoadPolicyWithContext(ctx context.Context, m model.Model) error {
+	a.open()
+
+	lines, err := a.loadRules(ctx, "")
+	if err != nil {
+		return err
+	}
+	if err := a.checkPTypes(m, lines); err != nil {
+		return err
+	}
+
+	m.ClearPolicy()
+	seen := make(map[string]bool, len(lines))
+	for _, line := range lines {
+		text := policyLineText(line)
+		if seen[text] || a.skipPType(m, line) {
+			continue
+		}
+		seen[text] = true
+		a.loadPolicyLine(line, m)
+	}
+	a.filtered = false
+	a.observeRows(OpLoad, len(lines))
+	return nil
+}
+
+// Filter selects the rules LoadFilteredPolicy loads. Each non-empty field
+// restricts the matching column to the listed values; empty fields match
+// anything.
+type Filter struct {
+	PType []string
+	V0    []string
+	V1    []string
+	V2    []string
+	V3    []string
+	V4    []string
+	V5    []string
+}
+
+// where returns the WHERE condition for f over the given columns, in
+// ruleFields order, or "" if f matches everything. With nulls set, "" in a
+// value list also matches NULL.
+func (f Filter) where(cols []string, nulls bool) (string, []interface{}) {
+	var conds []string
+	var params []interface{}
+	for i, values := range [][]string{f.PType, f.V0, f.V1, f.V2, f.V3, f.V4, f.V5} {
+		if len(values) == 0 {
+			continue
+		}
+		cond := cols[i] + " IN (?)"
+		if nulls && i > 0 && containsString(values, "") {
+			cond = "(" + cond + " OR " + cols[i] + " IS NULL)"
+		}
+		conds = append(conds, cond)
+		params = append(params, pg.In(values))
+	}
+	return strings.Join(conds, " AND "), params
+}
+
+// LoadFilteredPolicy loads the rules matching filter, which must be a Filter
+// or *Filter.
+func (a *Adapter) LoadFilteredPolicy(model model.Model, filter interface{}) error {
+	var f Filter
+	switch filter := filter.(type) {
+	case Filter:
+		f = filter
+	case *Filter:
+		if filter == nil {
+			return fmt.Errorf("adapter: nil *Filter")
+		}
+		f = *filter
+	default:
+		return fmt.Errorf("adapter: invalid filter type %T, want adapter.Filter", filter)
+	}
+
+	a.open()
+
+	for _, values := range []*[]string{&f.V0, &f.V1, &f.V2, &f.V3, &f.V4, &f.V5} {
+		*values = a.normalizeRule(*values)
+	}
+	for i, values := range []*[]string{&f.V0, &f.V1, &f.V2, &f.V3, &f.V4, &f.V5} {
+		matched := make([]string, len(*values))
+		for j, v := range *values {
+			matched[j] = a.matchValue(i, v)
+		}
+		*values = matched
+	}
+	where, params := f.where(a.matchExprs(), a.nullUnused)
+	ctx, cancel := a.context()
+	defer cancel()
+	lines, err := a.loadRules(ctx, where, params...)
+	if err != nil {
+		return err
+	}
+
+	if err := a.loadLines(lines, model); err != nil {
+		return err
+	}
+	a.filtered = true
+	a.observeRows(OpLoad, len(lines))
+	return nil
+}
+
+// IsFiltered returns true if the loaded policy has been filtered.
+func (a *Adapter) IsFiltered() bool {
+	return a.filtered
+}
+
+// WithStrictSchema makes every read fail if the policy table has columns the adapter
+// does not expect, instead of ignoring them as CasbinRule's
+// discard_unknown_columns tag otherwise does. This catches schema drift;
+// columns added by the adapter's own options are expected when enabled.
+func WithStrictSchema() Option {
+	return func(a *Adapter) {
+		a.strictSchema = true
+	}
+}
+
+// expectedColumns returns the columns the policy table has under the current options.
+func (a *Adapter) expectedColumns() []string {
+	columns := append([]string(nil), a.cols...)
+	if a.softDelete {
+		columns = append(columns, "deleted_at")
+	}
+	if a.timestamps {
+		columns = append(columns, "created_at", "updated_at")
+	}
+	if a.tenantFunc != nil {
+		columns = append(columns, "tenant")
+	}
+	if a.secColumn {
+		columns = append(columns, "sec")
+	}
+	if a.idColumn {
+		columns = append(columns, "id")
+	}
+	if a.ruleHash {
+		columns = append(columns, "rule_hash")
+	}
+	return columns
+}
+
+// checkUnknownColumns returns an error naming the columns of the table that
+// expectedColumns does not list.
+func (a *Adapter) checkUnknownColumns(ctx context.Context) error {
+	var unknown []string
+	_, err := a.conn(ctx).Query(&unknown, a.tag(ctx,
+		"SELECT column_name FROM information_schema.columns "+
+			"WHERE table_schema = current_schema() AND table_name = ? AND column_name NOT IN (?) "+
+			"ORDER BY ordinal_position"), a.table, pg.In(a.expectedColumns()))
+	if err != nil {
+		return err
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("adapter: unexpected columns in %s: %s", a.table, strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// selectRules returns the live rows of the tenant matching where, or all of
+// them if where is empty.
+func (a *Adapter) selectRules(ctx context.Context, where string, params ...interface{}) ([]CasbinRule, error) {
+	if a.sectionTables != nil {
+		var lines []CasbinRule
+		for _, b := range a.sections() {
+			section, err := b.selectRules(ctx, where, params...)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, section...)
+		}
+		return lines, nil
+	}
+	if a.strictSchema {
+		if err := a.checkUnknownColumns(ctx); err != nil {
+			return nil, err
+		}
+	}
+	where, params, err := a.scope(ctx, where, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	query := a.selectQuery(where)
+	var lines []CasbinRule
+	if where == "" && a.rlsSetting == "" {
+		_, err = a.stmtQuery(ctx, &lines, query)
+		return lines, err
+	}
+	err = a.withTenantSetting(ctx, func(db orm.DB) error {
+		lines = nil
+		_, err := db.Query(&lines, a.tag(ctx, query), params...)
+		return err
+	})
+	return lines, err
+}
+
+// selectQuery returns the query for the live rows matching where. Columns are
+// renamed to the CasbinRule defaults for scanning, followed by the extra
+// expressions if any, and rows are sorted by all of them so that two reads of
+// the same table produce identical output.
+func (a *Adapter) selectQuery(where string, extra ...string) string {
+	return a.unorderedQuery(where, extra...) + " ORDER BY " + strings.Join(a.cols, ", ")
+}
+
+// unorderedQuery is selectQuery without the ORDER BY.
+func (a *Adapter) unorderedQuery(where string, extra ...string) string {
+	list := append(a.selectList(), extra...)
+
+	var conds []string
+	if where != "" {
+		conds = append(conds, where)
+	}
+	if a.softDelete {
+		conds = append(conds, "deleted_at IS NULL")
+	}
+
+	query := "SELECT " + strings.Join(list, ", ") + " FROM " + a.source()
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	return query
+}
+
+// selectList returns the columns of a rule renamed to the CasbinRule
+// defaults, array columns as strings, followed by sec under
+// WithSectionColumn.
+func (a *Adapter) selectList() []string {
+	var list []string
+	for i, expr := range a.matchExprs() {
+		if def := DefaultColumnNamer(ruleFields[i]); expr != def {
+			expr += " AS " + def
+		}
+		list = append(list, expr)
+	}
+	if a.secColumn {
+		list = append(list, "sec")
+	}
+	return list
+}
+
+// matchExprs returns, in column order, the SQL expressions a rule's ptype
+// and values are read and matched through.
+func (a *Adapter) matchExprs() []string {
+	exprs := []string{a.ptypeCol()}
+	for i := 0; i < 6; i++ {
+		exprs = append(exprs, a.valueExpr(i))
+	}
+	return exprs
+}
+
+// EachPolicy calls fn with every rule in the table, in the order of
+// GetAllPolicies, as the rows arrive from the database rather than after
+// collecting them all. If fn returns an error, EachPolicy stops calling it
+// and returns that error once the remaining rows have been drained. If ctx
+// is done midway, EachPolicy stops calling fn, cancels the query on the
+// server instead of draining it and returns ctx.Err() with the connection
+// back in the pool; under WithTx the transaction's own context governs the
+// query, so only the calls to fn stop.
+func (a *Adapter) EachPolicy(ctx context.Context, fn func(ptype string, rule []string) error) error {
+	return a.eachRule(ctx, func(line CasbinRule) error {
+		return fn(line.PType, a.lineRule(line))
+	})
+}
+
+// LoadRawPolicies returns every rule in the table as the row holding it, in
+// the order of EachPolicy, for services that read the policy without a
+// casbin model. The rows stream in as EachPolicy's do; only the slice
+// returned grows with the table.
+func (a *Adapter) LoadRawPolicies(ctx context.Context) ([]CasbinRule, error) {
+	var lines []CasbinRule
+	err := a.eachRule(ctx, func(line CasbinRule) error {
+		lines = append(lines, line)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// eachRule is EachPolicy handing fn each row.
+func (a *Adapter) eachRule(ctx context.Context, fn func(line CasbinRule) error) error {
+	a.open()
+	if a.sectionTables != nil {
+		for _, b := range a.sections() {
+			if err := b.eachRule(ctx, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if a.strictSchema {
+		if err := a.checkUnknownColumns(ctx); err != nil {
+			return err
+		}
+	}
+
+	where, params, err := a.scope(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	rows := newRuleStream(ctx, fn)
+	err = a.withTenantSetting(ctx, func(db orm.DB) error {
+		_, err := db.Query(rows, a.tag(ctx, a.selectQuery(where)), params...)
+		return err
+	})
+	if rows.err != nil {
+		return rows.err
+	}
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// ruleStream is a go-pg model that hands each scanned row to a callback
+// instead of appending it to a slice, so memory use does not grow with the
+// table. It stops calling back once ctx is done.
+type ruleStream struct {
+	ctx     context.Context
+	line    CasbinRule
+	scanner orm.ColumnScanner
+	fn      func(line CasbinRule) error
+	err     error
+}
+
+func newRuleStream(ctx context.Context, fn func(line CasbinRule) error) *ruleStream {
+	s := &ruleStream{ctx: ctx, fn: fn}
+	m, _ := orm.NewModel(&s.line)
+	s.scanner = m.(orm.ColumnScanner)
+	return s
+}
+
+func (s *ruleStream) Init() error {
+	return nil
+}
+
+func (s *ruleStream) NextColumnScanner() orm.ColumnScanner {
+	s.line = CasbinRule{}
+	return s.scanner
+}
+
+func (s *ruleStream) AddColumnScanner(orm.ColumnScanner) error {
+	if s.err != nil {
+		return nil
+	}
+	if s.err = s.ctx.Err(); s.err != nil {
+		return s.err
+	}
+	s.err = s.fn(s.line)
+	return s.err
+}
+
+// GetAllPolicies returns every rule in the table grouped by ptype. Within a
+// ptype, rules are sorted by their values.
+func (a *Adapter) GetAllPolicies(ctx context.Context) (map[string][][]string, error) {
+	a.open()
+
+	lines, err := a.selectRules(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return a.groupByPType(lines), nil
+}
+
+// GetPoliciesBySubject returns the rules whose first value is subject, grouped
+// by ptype: the policy rules granted to it directly and the grouping rules
+// assigning it roles.
+func (a *Adapter) GetPoliciesBySubject(ctx context.Context, subject string) (map[string][][]string, error) {
+	a.open()
+
+	lines, err := a.selectRules(ctx, a.valueExpr(0)+" = ?", a.matchValue(0, a.normalizeValue(subject)))
+	if err != nil {
+		return nil, err
+	}
+
+	return a.groupByPType(lines), nil
+}
+
+func (a *Adapter) groupByPType(lines []CasbinRule) map[string][][]string {
+	policies := make(map[string][][]string)
+	for _, line := range lines {
+		policies[line.PType] = append(policies[line.PType], a.lineRule(line))
+	}
+	return policies
+}
+
+// Validate scans the policy table and returns the rows that would not load
+// cleanly into m: rows whose ptype has no definition in m, rows with a gap in
+// their values (v2 set while v1 is empty, say) and rows whose number of
+// values differs from the definition of their ptype.
+func (a *Adapter) Validate(ctx context.Context, m model.Model) ([]CasbinRule, error) {
+	a.open()
+
+	lines, err := a.selectRules(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var invalid []CasbinRule
+	for _, line := range lines {
+		if !validRule(line, m) {
+			invalid = append(invalid, line)
+		}
+	}
+	return invalid, nil
+}
+
+func validRule(line CasbinRule, m model.Model) bool {
+	if line.PType == "" {
+		return false
+	}
+	ast, ok := m[line.PType[:1]][line.PType]
+	if !ok {
+		return false
+	}
+
+	values := []string{line.V0, line.V1, line.V2, line.V3, line.V4, line.V5}
+	arity := 0
+	for i, v := range values {
+		if v != "" {
+			if i > arity {
+				return false
+			}
+			arity = i + 1
+		}
+	}
+
+	want := len(ast.Tokens)
+	if line.PType[:1] == "g" {
+		want = strings.Count(ast.Value, "_")
+	}
+	return arity == want
+}
+
+// WithProgress has SavePolicy call fn after every every rows it writes, and
+// once more after the last, with the number of rows written so far and the
+// total it is saving. fn runs inside the save's transaction, so rows it
+// reports are not visible to others until SavePolicy returns. ImportCSV
+// reports likewise, with a total of -1 since it reads its input only once,
+// and from a goroutine of its own. An every below 1 is taken as 1.
+func WithProgress(every int, fn func(written, total int)) Option {
+	return func(a *Adapter) {
+		if every < 1 {
+			every = 1
+		}
+		a.progress = fn
+		a.progressEvery = every
+	}
+}
+
+func (a *Adapter) reportProgress(written, total int) {
+	if a.progress != nil && written%a.progressEvery == 0 {
+		a.progress(written, total)
+	}
+}
+
+// SavePolicy saves policy to database.
+func (a *Adapter) SavePolicy(model model.Model) error {
+	if a.readOnly {
+		return ErrReadOnly
+	}
+	a.open()
+	// defer a.close()
+
+	ctx, cancel := a.context()
+	defer cancel()
+
+	var rules [][]string
+	for _, sec := range []string{"p", "g"} {
+		for ptype, ast := range model[sec] {
+			for _, rule := range ast.Policy {
+				rules = append(rules, append([]string{ptype}, a.normalizeRule(rule)...))
+			}
+		}
+	}
+	return a.save(ctx, rules)
+}
+
+// save replaces the rules of the table, or of the tenant of ctx, with rules,
+// each the ptype followed by the values, in one transaction.
+func (a *Adapter) save(ctx context.Context, rules [][]string) error {
+	tenant, err := a.tenant(ctx)
+	if err != nil {
+		return err
+	}
+	if err := a.flushWrites(ctx); err != nil {
+		return err
+	}
+	lines := make([]CasbinRule, len(rules))
+	if a.beforeInsert != nil {
+		rules = append([][]string(nil), rules...)
+	}
+	for i, rule := range rules {
+		line, hooked, err := a.hookedLine(ctx, false, rule[0], rule[1:])
+		if err != nil {
+			return err
+		}
+		lines[i] = line
+		if a.beforeInsert != nil {
+			rules[i] = append([]string{rule[0]}, hooked...)
+		}
+	}
+
+	err = a.runInTx(ctx, func(tx *pg.Tx) error {
+		if err := a.setLockTimeout(tx); err != nil {
+			return err
+		}
+		if a.snapshotOnSave {
+			if err := a.snapshot(ctx, tx); err != nil {
+				return err
+			}
+		}
+		for _, b := range a.sections() {
+			if err := b.clearTable(ctx, tx); err != nil {
+				return err
+			}
+		}
+
+		var isNew []bool
+		if a.checkedInserts() {
+			var err error
+			if isNew, err = a.newLines(ctx, tx, tenant, lines); err != nil {
+				return err
+			}
+		}
+		var packed []CasbinRule
+		queries := make(map[string]string)
+		for i, rule := range rules {
+			if a.packable(lines[i]) {
+				packed = append(packed, lines[i])
+				a.reportProgress(i+1, len(rules))
+				continue
+			}
+			if isNew != nil && !isNew[i] {
+				a.reportProgress(i+1, len(rules))
+				continue
+			}
+			sec := ptypeSection(rule[0])
+			query, ok := queries[sec]
+			if !ok {
+				query = a.tag(ctx, a.forSection(sec).insertQuery())
+				queries[sec] = query
+			}
+			if _, err := tx.Exec(query, a.insertParams(lines[i], tenant)...); err != nil {
+				return err
+			}
+			a.reportProgress(i+1, len(rules))
+		}
+
+		if len(a.packed) > 0 {
+			if err := a.savePacked(ctx, tx, packed); err != nil {
+				return err
+			}
+		}
+
+		if a.progress != nil && len(rules)%a.progressEvery != 0 {
+			a.progress(len(rules), len(rules))
+		}
+		return a.record(ctx, tx, Change{Op: OpSave, Rules: rules})
+	})
+	if err != nil {
+		return valueTooLong(tableBusy(err))
+	}
+	a.observeRows(OpSave, len(rules))
+	return nil
+}
+
+func (a *Adapter) AddPolicy(sec string, ptype string, rule []string) error {
+	if a.readOnly {
+		return ErrReadOnly
+	}
+	if err := checkSection(sec, ptype); err != nil {
+		return err
+	}
+	rule = a.normalizeRule(rule)
+	a.open()
+	a = a.forSection(sec)
+
+	ctx, cancel := a.context()
+	defer cancel()
+	tenant, err := a.tenant(ctx)
+	if err != nil {
+		return err
+	}
+
+	line, rule, err := a.hookedLine(ctx, false, ptype, rule)
+	if err != nil {
+		return err
+	}
+	c := Change{Op: OpAdd, Rules: [][]string{append([]string{ptype}, rule...)}}
+	if a.packable(line) {
+		return a.changePacked(ctx, c, func(tx *pg.Tx) (int, error) {
+			return a.addPacked(ctx, tx, line)
+		})
+	}
+	if a.writes != nil && a.tx == nil {
+		return a.writes.add(ctx, a, c.Rules[0], line, tenant)
+	}
+	if a.checkedInserts() {
+		if err := a.flushWrites(ctx); err != nil {
+			return err
+		}
+		var n int
+		err := a.runInTx(ctx, func(tx *pg.Tx) error {
+			var err error
+			if n, err = a.insertNew(ctx, tx, tenant, []CasbinRule{line}); err != nil {
+				return err
+			}
+			return a.record(ctx, tx, c)
+		})
+		if err != nil {
+			return err
+		}
+		a.observeRows(OpAdd, n)
+		return nil
+	}
+	scratch := a.pooledInsertParams(line, tenant)
+	defer releaseInsertParams(scratch)
+	_, err = a.execChange(ctx, c, true, a.addQuery(), scratch.params...)
+	return err
+}
+
+// PolicyRule is a rule of AddPoliciesMixed: its section, ptype and values.
+type PolicyRule struct {
+	Sec   string
+	PType string
+	Rule  []string
+}
+
+// AddPoliciesMixed adds rules of any sections and ptypes in one
+// transaction, one INSERT per table, so that a batch of changes computed
+// across p and g rules is applied whole or not at all. It fails before
+// writing anything if a rule's ptype does not belong to its section.
+func (a *Adapter) AddPoliciesMixed(ctx context.Context, rules []PolicyRule) error {
+	if a.readOnly {
+		return ErrReadOnly
+	}
+	for _, r := range rules {
+		if err := checkSection(r.Sec, r.PType); err != nil {
+			return err
+		}
+		if a.isPacked(r.PType) {
+			return errPacked("AddPoliciesMixed")
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	a.open()
+
+	tenant, err := a.tenant(ctx)
+	if err != nil {
+		return err
+	}
+	if err := a.flushWrites(ctx); err != nil {
+		return err
+	}
+
+	var tables []*Adapter
+	params := make(map[string][]interface{})
+	lines := make(map[string][]CasbinRule)
+	counts := make(map[string]int)
+	changed := make([][]string, len(rules))
+	for i, r := range rules {
+		line, rule, err := a.hookedLine(ctx, false, r.PType, a.normalizeRule(r.Rule))
+		if err != nil {
+			return err
+		}
+		b := a.forSection(r.Sec)
+		if counts[b.table] == 0 {
+			tables = append(tables, b)
+		}
+		params[b.table] = append(params[b.table], b.insertParams(line, tenant)...)
+		lines[b.table] = append(lines[b.table], line)
+		counts[b.table]++
+		changed[i] = append([]string{r.PType}, rule...)
+	}
+
+	err = a.runInTx(ctx, func(tx *pg.Tx) error {
+		for _, b := range tables {
+			if b.checkedInserts() {
+				if _, err := b.insertNew(ctx, tx, tenant, lines[b.table]); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, err := tx.Exec(b.tag(ctx, b.addRowsQuery(counts[b.table])), params[b.table]...); err != nil {
+				return err
+			}
+		}
+		return a.record(ctx, tx, Change{Op: OpAdd, Rules: changed})
+	})
+	if err != nil {
+		return err
+	}
+	a.observeRows(OpAdd, len(rules))
+	return nil
+}
+
+// AddPolicyIfNotExists adds rule under ptype, in the section its first
+// letter names, unless the table holds it already. It reports whether it
+// did, along with the rule as the table holds it, which reflects
+// WithValueNormalizer. The insert carries the ON CONFLICT clause of
+// WithConflictTarget or WithRuleHash, if any and the server supports it;
+// without one, the check alone keeps the rule from being stored twice. The
+// check and the insert share a transaction that first takes a
+// transaction-scoped advisory lock keyed by the table, tenant and rule, so
+// concurrent adds of the same rule through AddPolicyIfNotExists run one
+// after the other and only the first inserts; adds of other rules do not
+// wait, save for the rare hash collision. Except on a server before 9.5,
+// whose inserts take it too, the lock does not cover AddPolicy and the
+// other writes, which can still add the rule in between. Under
+// IsolationRepeatableRead the transaction's snapshot is taken before the
+// lock is granted, so a rule added by the add it waited on goes unseen: a
+// unique index, from WithRuleHash say, then reports the rule as existing,
+// while without one the rule is stored twice. Under WithTx the lock is held
+// until the caller's transaction ends.
+func (a *Adapter) AddPolicyIfNotExists(ctx context.Context, ptype string, rule []string) (existed bool, stored []string, err error) {
+	if a.readOnly {
+		return false, nil, ErrReadOnly
+	}
+	sec := ptypeSection(ptype)
+	if err := checkSection(sec, ptype); err != nil {
+		return false, nil, err
+	}
+	if a.isPacked(ptype) {
+		return false, nil, errPacked("AddPolicyIfNotExists")
+	}
+	rule = a.normalizeRule(rule)
+	a.open()
+	a = a.forSection(sec)
+
+	tenant, err := a.tenant(ctx)
+	if err != nil {
+		return false, nil, err
+	}
+	if err := a.flushWrites(ctx); err != nil {
+		return false, nil, err
+	}
+
+	line, rule, err := a.hookedLine(ctx, false, ptype, rule)
+	if err != nil {
+		return false, nil, err
+	}
+	var lines []CasbinRule
+	err = a.runInTx(ctx, func(tx *pg.Tx) error {
+		where, params := a.ruleWhere(line, true)
+		where, params, err := a.scope(ctx, where, params...)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(a.tag(ctx, "SELECT pg_advisory_xact_lock(?)"), ruleLockKey(a.table, tenant, line)); err != nil {
+			return err
+		}
+		lines = nil
+		if _, err := tx.Query(&lines, a.tag(ctx, a.selectQuery(where)+" LIMIT 1"), params...); err != nil {
+			return err
+		}
+		if existed = len(lines) > 0; existed {
+			return nil
+		}
+
+		query := a.insertQuery() + " RETURNING " + strings.Join(a.selectList(), ", ")
+		if _, err := tx.Query(&lines, a.tag(ctx, query), a.insertParams(line, tenant)...); err != nil {
+			return err
+		}
+		if len(lines) == 0 && a.conflictClause() != "" {
+			// The conflict clause skipped a rule added since the snapshot.
+			existed = true
+			return nil
+		}
+		return a.record(ctx, tx, Change{Op: OpAdd, Rules: [][]string{append([]string{ptype}, rule...)}})
+	})
+	if err != nil {
+		return false, nil, err
+	}
+	if existed && len(lines) == 0 {
+		return true, rule, nil
+	}
+	if len(lines) == 0 {
+		return false, nil, fmt.Errorf("adapter: insert of %q returned no row", rule)
+	}
+	if !existed {
+		a.observeRows(OpAdd, 1)
+	}
+	return existed, a.lineRule(lines[0]), nil
+}
+
+// AddPolicyReturningID adds rule under ptype, in the section its first
+// letter names, and returns the id the table generated for it. The table
+// must have an id column, from WithIDColumn or one such as id BIGSERIAL
+// PRIMARY KEY added by hand, or AddPolicyReturningID fails.
+func (a *Adapter) AddPolicyReturningID(ctx context.Context, ptype string, rule []string) (int64, error) {
+	if a.readOnly {
+		return 0, ErrReadOnly
+	}
+	sec := ptypeSection(ptype)
+	if err := checkSection(sec, ptype); err != nil {
+		return 0, err
+	}
+	if a.isPacked(ptype) {
+		return 0, errPacked("AddPolicyReturningID")
+	}
+	rule = a.normalizeRule(rule)
+	a.open()
+	a = a.forSection(sec)
+
+	tenant, err := a.tenant(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := a.flushWrites(ctx); err != nil {
+		return 0, err
+	}
+
+	line, rule, err := a.hookedLine(ctx, false, ptype, rule)
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = a.runInTx(ctx, func(tx *pg.Tx) error {
+		if a.checkedInserts() {
+			isNew, err := a.newLines(ctx, tx, tenant, []CasbinRule{line})
+			if err != nil {
+				return err
+			}
+			if !isNew[0] {
+				// As the conflict clause would have skipped it.
+				return pg.ErrNoRows
+			}
+		}
+		_, err := tx.QueryOne(pg.Scan(&id), a.tag(ctx, a.addQuery()+" RETURNING id"), a.insertParams(line, tenant)...)
+		if err != nil {
+			return err
+		}
+		return a.record(ctx, tx, Change{Op: OpAdd, Rules: [][]string{append([]string{ptype}, rule...)}})
+	})
+	if pgErr, ok := err.(pg.Error); ok && pgErr.Field('C') == "42703" {
+		return 0, fmt.Errorf("adapter: AddPolicyReturningID requires an id column in %s", a.table)
+	}
+	if err != nil {
+		return 0, err
+	}
+	a.observeRows(OpAdd, 1)
+	return id, nil
+}
+
+// ptypeSection returns the section ptype belongs to, named by its first
+// letter.
+func ptypeSection(ptype string) string {
+	if ptype == "" {
+		return ""
+	}
+	return ptype[:1]
+}
+
+// insertQuery returns the statement inserting one rule, taking insertParams
+// as its parameters.
+func (a *Adapter) insertQuery() string {
+	return a.insertRowsQuery(1)
+}
+
+// insertRowsQuery returns the statement inserting n rules, taking their
+// insertParams one after the other as its parameters.
+func (a *Adapter) insertRowsQuery(n int) string {
+	return a.valuesQuery(n) + a.conflictClause()
+}
+
+// addQuery is insertQuery for the adds, with the conflict clause of
+// WithUpsertOnAdd.
+func (a *Adapter) addQuery() string {
+	return a.addRowsQuery(1)
+}
+
+// addRowsQuery is insertRowsQuery for the adds, with the conflict clause of
+// WithUpsertOnAdd.
+func (a *Adapter) addRowsQuery(n int) string {
+	return a.valuesQuery(n) + a.addConflictClause()
+}
+
+// valuesQuery returns the INSERT of n rules, without its conflict clause.
+func (a *Adapter) valuesQuery(n int) string {
+	cols := a.insertColumns()
+	row := "(?" + strings.Repeat(", ?", len(cols)-1) + ")"
+	return "INSERT INTO " + a.table + " (" + strings.Join(cols, ", ") + ") VALUES " + row + strings.Repeat(", "+row, n-1)
+}
+
+// insertColumns returns the columns insertParams gives values for, in
+// order.
+func (a *Adapter) insertColumns() []string {
+	cols := a.cols
+	if a.tenantFunc != nil {
+		cols = append(append([]string(nil), cols...), "tenant")
+	}
+	if a.secColumn {
+		cols = append(append([]string(nil), cols...), "sec")
+	}
+	if a.timestamps && a.clock != nil {
+		cols = append(append([]string(nil), cols...), "created_at", "updated_at")
+	}
+	return cols
+}
+
+// insertParams returns the parameters of insertQuery for line, owned by
+// tenant.
+func (a *Adapter) insertParams(line CasbinRule, tenant string) []interface{} {
+	params := a.rowValues(line)
+	if a.tenantFunc != nil {
+		params = append(params, tenant)
+	}
+	if a.secColumn {
+		params = append(params, ptypeSection(line.PType))
+	}
+	if a.timestamps && a.clock != nil {
+		now := a.clock()
+		params = append(params, now, now)
+	}
+	return params
+}
+
+// lineValues returns the ptype and values of line in column order.
+func lineValues(line CasbinRule) []interface{} {
+	return []interface{}{line.PType, line.V0, line.V1, line.V2, line.V3, line.V4, line.V5}
+}
+
+// rowValues is lineValues as written to the table: with WithNullUnusedColumns,
+// the empty values after the last value of the rule are NULL.
+func (a *Adapter) rowValues(line CasbinRule) []interface{} {
+	values := lineValues(line)
+	if a.nullUnused {
+		for i := len(values) - 1; i > 0 && values[i] == ""; i-- {
+			values[i] = nil
+		}
+	}
+	for i := 1; i < len(values); i++ {
+		values[i] = a.storedValue(i-1, values[i])
+	}
+	return values
+}
+
+// WithNullUnusedColumns stores NULL rather than "" in the value columns a
+// rule does not use, for consumers of the table that tell the two apart.
+// Reads and matches treat NULL and "" alike, so the option can be turned on
+// for a table already holding rules.
+func WithNullUnusedColumns() Option {
+	return func(a *Adapter) {
+		a.nullUnused = true
+	}
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdatePolicy replaces oldRule with newRule in place. oldRule is matched as
+// RemovePolicy matches its rule.
+func (a *Adapter) UpdatePolicy(sec string, ptype string, oldRule []string, newRule []string) error {
+	if a.readOnly {
+		return ErrReadOnly
+	}
+	if err := checkSection(sec, ptype); err != nil {
+		return err
+	}
+	if a.isPacked(ptype) {
+		return errPacked("UpdatePolicy")
+	}
+	oldRule, newRule = a.normalizeRule(oldRule), a.normalizeRule(newRule)
+	a.open()
+	a = a.forSection(sec)
+
+	ctx, cancel := a.context()
+	defer cancel()
+	line, newRule, err := a.hookedLine(ctx, true, ptype, newRule)
+	if err != nil {
+		return err
+	}
+	where, params := a.exactWhere(ptype, oldRule)
+	where, params, err = a.scope(ctx, where, params...)
+	if err != nil {
+		return err
+	}
+	if a.softDelete {
+		where += " AND deleted_at IS NULL"
+	}
+
+	var set []string
+	for i := 0; i < 6; i++ {
+		set = append(set, a.valueCol(i)+" = ?")
+	}
+	setParams := a.rowValues(line)[1:]
+	if a.timestamps {
+		at, atParams := a.stamp()
+		set = append(set, "updated_at = "+at)
+		setParams = append(setParams, atParams...)
+	}
+	params = append(setParams, params...)
+
+	c := Change{Op: OpUpdate, Rules: [][]string{
+		append([]string{ptype}, oldRule...),
+		append([]string{ptype}, newRule...),
+	}}
+	_, err = a.execChange(ctx, c, false, "UPDATE "+a.table+" SET "+strings.Join(set, ", ")+" WHERE "+where, params...)
+	return err
+}
+
+// RemovePolicy removes the rows holding exactly rule under ptype: each value
+// of rule must match its column and the columns past the last be empty, so a
+// rule of two values never removes a stored rule of three, nor a rule of
+// three a stored rule of two. A rule with more values than the table has
+// columns matches nothing. Removing a rule the table does not hold is not
+// an error, but it is logged as a warning, rule included, with the logger of
+// WithLogger, if any, since it means the model and the table have drifted
+// apart.
+func (a *Adapter) RemovePolicy(sec string, ptype string, rule []string) error {
+	if a.readOnly {
+		return ErrReadOnly
+	}
+	if err := checkSection(sec, ptype); err != nil {
+		return err
+	}
+	rule = a.normalizeRule(rule)
+	a.open()
+	a = a.forSection(sec)
+
+	ctx, cancel := a.context()
+	defer cancel()
+	c := Change{Op: OpRemove, Rules: [][]string{append([]string{ptype}, rule...)}}
+	if line := savePolicyLine(ptype, rule); len(rule) == 2 && a.packable(line) {
+		removed := 0
+		err := a.changePacked(ctx, c, func(tx *pg.Tx) (int, error) {
+			var err error
+			removed, err = a.removePacked(ctx, tx, ptype, line.V0, line.V1)
+			return removed, err
+		})
+		if err == nil && removed == 0 {
+			a.warnNoMatch(ptype, rule)
+		}
+		return err
+	}
+	where, params := a.exactWhere(ptype, rule)
+	where, params, err := a.scope(ctx, where, params...)
+	if err != nil {
+		return err
+	}
+	query, params := a.deleteQuery(where, params...)
+	res, err := a.execChange(ctx, c, true, query, params...)
+	if err == nil && res.RowsAffected() == 0 {
+		a.warnNoMatch(ptype, rule)
+	}
+	return err
+}
+
+// warnNoMatch logs that RemovePolicy found no row holding rule.
+func (a *Adapter) warnNoMatch(ptype string, rule []string) {
+	if a.logger != nil {
+		a.logger.Printf("adapter: warning: RemovePolicy matched no row in %s: %s", a.table, strings.Join(append([]string{ptype}, rule...), ", "))
+	}
+}
+
+// ErrRuleNotFound reports a rule RemovePoliciesBestEffort found no row for.
+var ErrRuleNotFound = errors.New("adapter: rule not found")
+
+// RuleError is the failure of one rule of a batch.
+type RuleError struct {
+	Rule []string
+	Err  error
+}
+
+func (e RuleError) Error() string {
+	return fmt.Sprintf("adapter: rule %q: %v", e.Rule, e.Err)
+}
+
+// RemovePoliciesBestEffort removes each of rules on its own, outside a
+// transaction, so that a rule which cannot be removed does not keep the
+// others from going. It returns a RuleError for each such rule, with
+// ErrRuleNotFound for a rule that matched no row. The error is for failures
+// of the call as a whole, such as a read-only adapter, in which case nothing
+// is removed.
+func (a *Adapter) RemovePoliciesBestEffort(sec string, ptype string, rules [][]string) ([]RuleError, error) {
+	if a.readOnly {
+		return nil, ErrReadOnly
+	}
+	if err := checkSection(sec, ptype); err != nil {
+		return nil, err
+	}
+	if a.isPacked(ptype) {
+		return nil, errPacked("RemovePoliciesBestEffort")
+	}
+	a.open()
+	a = a.forSection(sec)
+
+	ctx, cancel := a.context()
+	defer cancel()
+	if err := a.flushWrites(ctx); err != nil {
+		return nil, err
+	}
+
+	var failed []RuleError
+	var removed [][]string
+	for _, rule := range rules {
+		rule = a.normalizeRule(rule)
+		where, params := a.exactWhere(ptype, rule)
+		where, params, err := a.scope(ctx, where, params...)
+		if err != nil {
+			return failed, err
+		}
+		c := Change{Op: OpRemove, Rules: [][]string{append([]string{ptype}, rule...)}}
+		query, params := a.deleteQuery(where, params...)
+		if a.auditActor != nil || a.rlsSetting != "" {
+			err = a.runInTx(ctx, func(tx *pg.Tx) error {
+				res, err := tx.Exec(a.tag(ctx, query), params...)
+				if err == nil && res.RowsAffected() == 0 {
+					err = ErrRuleNotFound
+				}
+				if err != nil {
+					return err
+				}
+				return a.audit(ctx, tx, c)
+			})
+		} else {
+			var res pg.Result
+			res, err = a.stmtExec(ctx, query, params...)
+			if err == nil && res.RowsAffected() == 0 {
+				err = ErrRuleNotFound
+			}
+		}
+		if err != nil {
+			failed = append(failed, RuleError{Rule: rule, Err: err})
+			continue
+		}
+		removed = append(removed, c.Rules[0])
+	}
+
+	if len(removed) > 0 {
+		if err := a.notify(a.conn(ctx), Change{Op: OpRemove, Rules: removed}); err != nil {
+			return failed, err
+		}
+	}
+	a.observeRows(OpRemove, len(removed))
+	return failed, nil
+}
+
+func (a *Adapter) RemoveFilteredPolicy(sec string, ptype string, fieldIndex int, fieldValues ...string) error {
+	if a.readOnly {
+		return ErrReadOnly
+	}
+	if err := checkSection(sec, ptype); err != nil {
+		return err
+	}
+	fieldValues = a.normalizeRule(fieldValues)
+	line, err := filteredLine(ptype, fieldIndex, fieldValues)
+	if err != nil {
+		return err
+	}
+	a.open()
+	a = a.forSection(sec)
+
+	ctx, cancel := a.context()
+	defer cancel()
+	where, params := a.ruleWhere(line, false)
+	where, params, err = a.scope(ctx, where, params...)
+	if err != nil {
+		return err
+	}
+	c := Change{
+		Op:         OpRemoveFiltered,
+		Rules:      [][]string{append([]string{ptype}, fieldValues...)},
+		FieldIndex: fieldIndex,
+	}
+	query, params := a.deleteQuery(where, params...)
+	if a.isPacked(ptype) {
+		return a.changePacked(ctx, c, func(tx *pg.Tx) (int, error) {
+			res, err := tx.Exec(a.tag(ctx, query), params...)
+			if err != nil {
+				return 0, err
+			}
+			n := res.RowsAffected()
+			if line.V2 == "" && line.V3 == "" && line.V4 == "" && line.V5 == "" {
+				packed, err := a.removePacked(ctx, tx, ptype, line.V0, line.V1)
+				n += packed
+				return n, err
+			}
+			return n, nil
+		})
+	}
+	_, err = a.execChange(ctx, c, false, query, params...)
+	return err
+}
+
+// RemoveFilteredPolicyReturning is RemoveFilteredPolicy for ptype, in the
+// section its first letter names, returning the rules it removed, as the
+// table held them, so that they can be added back to undo the removal. The
+// rows come back from the DELETE itself, with RETURNING, in no particular
+// order.
+func (a *Adapter) RemoveFilteredPolicyReturning(ctx context.Context, ptype string, fieldIndex int, fieldValues ...string) ([][]string, error) {
+	if a.readOnly {
+		return nil, ErrReadOnly
+	}
+	sec := ptypeSection(ptype)
+	if err := checkSection(sec, ptype); err != nil {
+		return nil, err
+	}
+	if a.isPacked(ptype) {
+		return nil, errPacked("RemoveFilteredPolicyReturning")
+	}
+	fieldValues = a.normalizeRule(fieldValues)
+	line, err := filteredLine(ptype, fieldIndex, fieldValues)
+	if err != nil {
+		return nil, err
+	}
+	a.open()
+	a = a.forSection(sec)
+
+	where, params := a.ruleWhere(line, false)
+	where, params, err = a.scope(ctx, where, params...)
+	if err != nil {
+		return nil, err
+	}
+	c := Change{
+		Op:         OpRemoveFiltered,
+		Rules:      [][]string{append([]string{ptype}, fieldValues...)},
+		FieldIndex: fieldIndex,
+	}
+	query, params := a.deleteQuery(where, params...)
+	var lines []CasbinRule
+	if _, err := a.queryChange(ctx, c, false, &lines, query+" RETURNING "+strings.Join(a.selectList(), ", "), params...); err != nil {
+		return nil, err
+	}
+	rules := make([][]string, len(lines))
+	for i, line := range lines {
+		rules[i] = a.lineRule(line)
+	}
+	return rules, nil
+}
+
+// RemoveFilteredPolicyIn removes, in one statement, the rules of ptype that
+// any of valueSets matches, each set being a RemoveFilteredPolicy filter at
+// fieldIndex, and returns the number of rules removed. Offboarding fifty
+// users is then one DELETE instead of fifty. The sets are joined as a VALUES
+// list and may differ in length; "" matches anything, as in
+// RemoveFilteredPolicy.
+func (a *Adapter) RemoveFilteredPolicyIn(ctx context.Context, ptype string, fieldIndex int, valueSets [][]string) (int64, error) {
+	if a.readOnly {
+		return 0, ErrReadOnly
+	}
+	sec := ptypeSection(ptype)
+	if err := checkSection(sec, ptype); err != nil {
+		return 0, err
+	}
+	if a.isPacked(ptype) {
+		return 0, errPacked("RemoveFilteredPolicyIn")
+	}
+	if len(valueSets) == 0 {
+		return 0, nil
+	}
+	sets := make([][]string, len(valueSets))
+	for i, values := range valueSets {
+		sets[i] = a.normalizeRule(values)
+		if _, err := filteredLine(ptype, fieldIndex, sets[i]); err != nil {
+			return 0, err
+		}
+	}
+	a.open()
+	a = a.forSection(sec)
+
+	where, params := a.filterSetsWhere(ptype, fieldIndex, sets)
+	where, params, err := a.scope(ctx, where, params...)
+	if err != nil {
+		return 0, err
+	}
+	c := Change{Op: OpRemoveFiltered, FieldIndex: fieldIndex}
+	for _, values := range sets {
+		c.Rules = append(c.Rules, append([]string{ptype}, values...))
+	}
+	query, params := a.deleteQuery(where, params...)
+	res, err := a.execChange(ctx, c, false, query, params...)
+	if err != nil {
+		return 0, err
+	}
+	return int64(res.RowsAffected()), nil
+}
+
+// filterSetsWhere builds a WHERE condition matching the rows of ptype that
+// any of sets, each fitting in the columns from fieldIndex on, matches. The
+// sets are padded with "" to a common width for the VALUES list.
+func (a *Adapter) filterSetsWhere(ptype string, fieldIndex int, sets [][]string) (string, []interface{}) {
+	where := a.ptypeCol() + " = ?"
+	params := []interface{}{ptype}
+
+	width := 0
+	for _, values := range sets {
+		if len(values) > width {
+			width = len(values)
+		}
+	}
+	if width == 0 {
+		return where, params
+	}
+
+	placeholders := "(?" + strings.Repeat(", ?", width-1) + ")"
+	var rows, names, conds []string
+	for _, values := range sets {
+		rows = append(rows, placeholders)
+		for j := 0; j < width; j++ {
+			v := ""
+			if j < len(values) {
+				v = values[j]
+			}
+			params = append(params, a.matchValue(fieldIndex+j, v))
+		}
+	}
+	for j := 0; j < width; j++ {
+		name := fmt.Sprintf("filter_%d", j)
+		names = append(names, name)
+		conds = append(conds, "(f."+name+" = '' OR "+a.valueExpr(fieldIndex+j)+" = f."+name+")")
+	}
+	where += " AND EXISTS (SELECT 1 FROM (VALUES " + strings.Join(rows, ", ") + ") AS f (" + strings.Join(names, ", ") + ")" +
+		" WHERE " + strings.Join(conds, " AND ") + ")"
+	return where, params
+}
+
+// filteredLine returns the rule matched by a RemoveFilteredPolicy filter:
+// fieldValues placed from column fieldIndex on, with "" matching anything.
+// A filter reaching past v5 could never match a stored rule, and dropping the
+// columns it names would widen it instead, so it is an error.
+func filteredLine(ptype string, fieldIndex int, fieldValues []string) (CasbinRule, error) {
+	if fieldIndex < 0 || fieldIndex+len(fieldValues) > 6 {
+		return CasbinRule{}, fmt.Errorf("adapter: filter of %d values at index %d does not fit in v0 to v5", len(fieldValues), fieldIndex)
+	}
+	rule := make([]string, 6)
+	copy(rule[fieldIndex:], fieldValues)
+	return savePolicyLine(ptype, rule), nil
+}
+
+// checkSection returns an error unless sec is "p" or "g" and ptype belongs
+// to it, that is p, p2, ... for policy rules and g, g2, ... for grouping
+// rules. A mismatch is a bug in the caller.
+func checkSection(sec string, ptype string) error {
+	if (sec != "p" && sec != "g") || !strings.HasPrefix(ptype, sec) {
+		return fmt.Errorf("adapter: ptype %q does not belong to section %q", ptype, sec)
+	}
+	return nil
+}
+
+// ruleWhere builds a WHERE condition matching line. With exact set, every
+// value column must equal line's, empty ones included, which under
+// WithNullUnusedColumns match NULL too, and under WithRuleHash the match is
+// on rule_hash; otherwise empty values in line match anything, as in
+// casbin's filtered removal.
+func (a *Adapter) ruleWhere(line CasbinRule, exact bool) (string, []interface{}) {
+	if exact && a.ruleHash {
+		return "rule_hash = ?", []interface{}{ruleHashOf(line)}
+	}
+	conds := []string{a.ptypeCol() + " = ?"}
+	params := []interface{}{line.PType}
+	for i, v := range []string{line.V0, line.V1, line.V2, line.V3, line.V4, line.V5} {
+		if exact && v == "" && a.nullUnused {
+			conds = append(conds, "("+a.valueExpr(i)+" = '' OR "+a.valueCol(i)+" IS NULL)")
+		} else if exact || v != "" {
+			conds = append(conds, a.valueExpr(i)+" = ?")
+			params = append(params, a.matchValue(i, v))
+		}
+	}
+	return strings.Join(conds, " AND "), params
+}
+
+// exactWhere is ruleWhere matching exactly rule under ptype, or nothing if
+// rule has more values than the table has columns, since savePolicyLine
+// would drop those past the sixth.
+func (a *Adapter) exactWhere(ptype string, rule []string) (string, []interface{}) {
+	if len(rule) > len(a.cols)-1 {
+		return "false", nil
+	}
+	return a.ruleWhere(savePolicyLine(ptype, a.placeValues(rule)), true)
+}
+
+// deleteWhere removes the live rows matching where. In soft-delete mode the
+// rows are stamped with deleted_at instead of being deleted.
+func (a *Adapter) deleteWhere(ctx context.Context, db orm.DB, where string, params ...interface{}) error {
+	query, params := a.deleteQuery(where, params...)
+	_, err := db.Exec(a.tag(ctx, query), params...)
+	return err
+}
+
+// deleteQuery returns the statement removing the rows matching where, with
+// its parameters, params after any of its own.
+func (a *Adapter) deleteQuery(where string, params ...interface{}) (string, []interface{}) {
+	if a.softDelete {
+		at, atParams := a.stamp()
+		return "UPDATE " + a.table + " SET deleted_at = " + at + " WHERE " + where + " AND deleted_at IS NULL", append(atParams, params...)
+	}
+	return "DELETE FROM " + a.table + " WHERE " + where, params
+}
+
+// WithSoftDelete makes removals stamp a deleted_at timestamp on the matching
+// rows rather than deleting them, and makes SavePolicy tombstone the previous
+// rules rather than clearing the table, whatever the save mode. Reads skip
+// tombstoned rows. The deleted_at column is added to the table on open, and
+// tombstones stay until removed with Purge.
+func WithSoftDelete() Option {
+	return func(a *Adapter) {
+		a.softDelete = true
+	}
+}
+
+// WithTimestamps adds created_at and updated_at columns to the table, both
+// defaulting to now(), or set from the clock of WithClock. created_at
+// records when a rule was added and updated_at is bumped by UpdatePolicy.
+// The columns are for auditing only; loads ignore them. Existing tables are
+// migrated on open.
+func WithTimestamps() Option {
+	return func(a *Adapter) {
+		a.timestamps = true
+	}
+}
+
+// Purge permanently deletes rows that were soft-deleted before the given time.
+func (a *Adapter) Purge(ctx context.Context, before time.Time) (int, error) {
+	if a.readOnly {
+		return 0, ErrReadOnly
+	}
+	a.open()
+	if a.sectionTables != nil {
+		purged := 0
+		for _, b := range a.sections() {
+			n, err := b.Purge(ctx, before)
+			if err != nil {
+				return purged, err
+			}
+			purged += n
+		}
+		return purged, nil
+	}
+
+	where, params, err := a.scope(ctx, "deleted_at < ?", before)
+	if err != nil {
+		return 0, err
+	}
+	var purged int
+	err = a.withTenantSetting(ctx, func(db orm.DB) error {
+		res, err := db.Exec(a.tag(ctx, "DELETE FROM "+a.table+" WHERE "+where), params...)
+		if err == nil {
+			purged = res.RowsAffected()
+		}
+		return err
+	})
+	return purged, err
 }
@@ -13,3 +13,1635 @@
 // limitations under the License.
 
 package adapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/casbin/casbin/model"
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+const testModelText = `
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+`
+
+func getenv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// newTestAdapter returns an adapter pointed at the database described by the
+// PG_USER, PG_PASSWORD, PG_DATABASE and PG_ADDR environment variables, with
+// x_policy dropped beforehand. The test is skipped if the database is unreachable.
+func newTestAdapter(t testing.TB, opts ...Option) *Adapter {
+	user := getenv("PG_USER", "postgres")
+	password := getenv("PG_PASSWORD", "")
+	database := getenv("PG_DATABASE", "casbin")
+	addr := getenv("PG_ADDR", "localhost:5432")
+
+	db := pg.Connect(&pg.Options{User: user, Password: password, Database: database, Addr: addr})
+	defer db.Close()
+	if _, err := db.Exec("DROP TABLE IF EXISTS x_policy, x_policy_schema_version, x_policy_packed"); err != nil {
+		t.Skipf("postgres not available: %v", err)
+	}
+
+	return NewAdapter(user, password, database, addr, opts...)
+}
+
+// newTestModel returns the RBAC test model populated with a few rules.
+func newTestModel() model.Model {
+	m := make(model.Model)
+	m.LoadModelFromText(testModelText)
+	m.AddPolicy("p", "p", []string{"alice", "data1", "read"})
+	m.AddPolicy("p", "p", []string{"bob", "data2", "write"})
+	m.AddPolicy("p", "p", []string{"data2_admin", "data2", "read"})
+	m.AddPolicy("g", "g", []string{"alice", "data2_admin"})
+	return m
+}
+
+func TestSavePolicyDropModeRestoresIndexes(t *testing.T) {
+	a := newTestAdapter(t, WithSaveMode(SaveModeDrop))
+
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	for _, idx := range managedIndexes {
+		var n int
+		_, err := a.db.QueryOne(pg.Scan(&n), "SELECT count(*) FROM pg_indexes WHERE tablename = 'x_policy' AND indexname = ?", "x_policy"+idx.suffix)
+		if err != nil {
+			t.Fatalf("query pg_indexes: %v", err)
+		}
+		if n != 1 {
+			t.Errorf("index x_policy%s missing after drop-mode SavePolicy", idx.suffix)
+		}
+	}
+}
+
+func TestCasbinRuleConversion(t *testing.T) {
+	values := []string{"alice", "data1", "read", "allow", "tenant1", "east"}
+	for n := 0; n <= len(values); n++ {
+		rule := values[:n]
+		line := RuleToCasbinRule("p", rule)
+		fields := []string{line.V0, line.V1, line.V2, line.V3, line.V4, line.V5}
+		for i, v := range fields {
+			want := ""
+			if i < n {
+				want = values[i]
+			}
+			if v != want {
+				t.Errorf("RuleToCasbinRule(%q).V%d = %q, want %q", rule, i, v, want)
+			}
+		}
+
+		ptype, got := line.ToSlice()
+		if ptype != "p" || !reflect.DeepEqual(got, append([]string(nil), rule...)) {
+			t.Errorf("ToSlice of %q = %q, %q, want p, %q", rule, ptype, got, rule)
+		}
+	}
+
+	line := RuleToCasbinRule("p", append(values, "extra"))
+	if _, got := line.ToSlice(); !reflect.DeepEqual(got, values) {
+		t.Errorf("ToSlice of a 7-value rule = %q, want the first 6 values %q", got, values)
+	}
+	if _, got := (CasbinRule{PType: "g", V0: "alice", V2: "admin"}).ToSlice(); !reflect.DeepEqual(got, []string{"alice", "admin"}) {
+		t.Errorf("ToSlice skipping V1 = %q, want the non-empty values", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	a := newTestAdapter(t)
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	// Listed in the order Validate returns them.
+	bad := []CasbinRule{
+		{PType: "g", V0: "alice", V1: "admin", V2: "domain1"},
+		{PType: "p", V0: "alice", V2: "read"},
+		{PType: "p", V0: "alice", V1: "data1"},
+		{PType: "p9", V0: "alice", V1: "data1", V2: "read"},
+	}
+	for i := range bad {
+		if _, err := a.db.Model(&bad[i]).Insert(); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+
+	invalid, err := a.Validate(context.Background(), newTestModel())
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if len(invalid) != len(bad) {
+		t.Fatalf("Validate reported %d rows, want %d: %+v", len(invalid), len(bad), invalid)
+	}
+	for i, line := range invalid {
+		if line != bad[i] {
+			t.Errorf("invalid[%d] = %+v, want %+v", i, line, bad[i])
+		}
+	}
+}
+
+func TestSerializationFailureRetried(t *testing.T) {
+	a := newTestAdapter(t, WithMaxRetries(5))
+	a.open()
+	defer a.close()
+
+	if _, err := a.db.Exec("DROP TABLE IF EXISTS x_skew; CREATE TABLE x_skew (n int)"); err != nil {
+		t.Fatalf("create x_skew: %v", err)
+	}
+	defer a.db.Exec("DROP TABLE x_skew")
+
+	// Both transactions read the table and then write to it, which is a
+	// write skew that SERIALIZABLE aborts on the first attempt of one side.
+	var attempts int32
+	var ready sync.WaitGroup
+	ready.Add(2)
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = a.runInTx(context.Background(), func(tx *pg.Tx) error {
+				first := atomic.AddInt32(&attempts, 1) <= 2
+				if _, err := tx.Exec("SET TRANSACTION ISOLATION LEVEL SERIALIZABLE"); err != nil {
+					return err
+				}
+				var sum int
+				if _, err := tx.QueryOne(pg.Scan(&sum), "SELECT coalesce(sum(n), 0) FROM x_skew"); err != nil {
+					return err
+				}
+				if first {
+					ready.Done()
+					ready.Wait()
+				}
+				_, err := tx.Exec("INSERT INTO x_skew VALUES (?)", sum+1)
+				return err
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("transaction %d: %v", i, err)
+		}
+	}
+	if attempts <= 2 {
+		t.Errorf("attempts = %d, want a retry after a serialization failure", attempts)
+	}
+	var n int
+	if _, err := a.db.QueryOne(pg.Scan(&n), "SELECT count(*) FROM x_skew"); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("x_skew has %d rows, want 2", n)
+	}
+}
+
+func TestIsolationLevel(t *testing.T) {
+	a := newTestAdapter(t, WithIsolationLevel(Serializable))
+	a.open()
+	defer a.close()
+
+	var level string
+	err := a.runInTx(context.Background(), func(tx *pg.Tx) error {
+		_, err := tx.QueryOne(pg.Scan(&level), "SELECT current_setting('transaction_isolation')")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("runInTx: %v", err)
+	}
+	if level != "serializable" {
+		t.Errorf("transaction_isolation = %q, want serializable", level)
+	}
+}
+
+func TestArchiveTable(t *testing.T) {
+	a := newTestAdapter(t)
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	a.db.Exec("DROP TABLE IF EXISTS x_policy_backup")
+	defer a.db.Exec("DROP TABLE IF EXISTS x_policy_backup")
+
+	if err := a.ArchiveTable(context.Background(), "x_policy; DROP TABLE x_policy"); err == nil {
+		t.Errorf("ArchiveTable accepted an invalid name")
+	}
+	if err := a.ArchiveTable(context.Background(), "x_policy_backup"); err != nil {
+		t.Fatalf("ArchiveTable: %v", err)
+	}
+
+	var live, archived int
+	if _, err := a.db.QueryOne(pg.Scan(&live), "SELECT count(*) FROM x_policy"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.db.QueryOne(pg.Scan(&archived), "SELECT count(*) FROM x_policy_backup"); err != nil {
+		t.Fatal(err)
+	}
+	if live != 0 || archived != 4 {
+		t.Errorf("live = %d, archived = %d, want 0 and 4", live, archived)
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	a := newTestAdapter(t, WithSoftDelete())
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	if err := a.RemovePolicy("p", "p", []string{"alice", "data1", "read"}); err != nil {
+		t.Fatalf("RemovePolicy: %v", err)
+	}
+	if err := a.RemoveFilteredPolicy("g", "g", 0, "alice"); err != nil {
+		t.Fatalf("RemoveFilteredPolicy: %v", err)
+	}
+
+	m := newTestModel()
+	m.ClearPolicy()
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if m.HasPolicy("p", "p", []string{"alice", "data1", "read"}) || m.HasPolicy("g", "g", []string{"alice", "data2_admin"}) {
+		t.Errorf("soft-deleted rules were loaded")
+	}
+	if !m.HasPolicy("p", "p", []string{"bob", "data2", "write"}) {
+		t.Errorf("live rule not loaded")
+	}
+
+	var tombstones int
+	if _, err := a.db.QueryOne(pg.Scan(&tombstones), "SELECT count(*) FROM x_policy WHERE deleted_at IS NOT NULL"); err != nil {
+		t.Fatal(err)
+	}
+	if tombstones != 2 {
+		t.Errorf("tombstones = %d, want 2", tombstones)
+	}
+
+	purged, err := a.Purge(context.Background(), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	if purged != 2 {
+		t.Errorf("Purge removed %d rows, want 2", purged)
+	}
+}
+
+func TestTimestamps(t *testing.T) {
+	a := newTestAdapter(t, WithTimestamps())
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	if err := a.AddPolicy("p", "p", []string{"carol", "data3", "read"}); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+
+	var created, updated time.Time
+	_, err := a.db.QueryOne(pg.Scan(&created, &updated), "SELECT created_at, updated_at FROM x_policy WHERE v0 = 'carol'")
+	if err != nil {
+		t.Fatalf("select timestamps: %v", err)
+	}
+	if created.IsZero() || !created.Equal(updated) {
+		t.Errorf("created_at = %v, updated_at = %v after insert", created, updated)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := a.UpdatePolicy("p", "p", []string{"carol", "data3", "read"}, []string{"carol", "data3", "write"}); err != nil {
+		t.Fatalf("UpdatePolicy: %v", err)
+	}
+
+	var created2, updated2 time.Time
+	_, err = a.db.QueryOne(pg.Scan(&created2, &updated2), "SELECT created_at, updated_at FROM x_policy WHERE v0 = 'carol' AND v2 = 'write'")
+	if err != nil {
+		t.Fatalf("select timestamps: %v", err)
+	}
+	if !created2.Equal(created) {
+		t.Errorf("created_at changed on update: %v -> %v", created, created2)
+	}
+	if !updated2.After(updated) {
+		t.Errorf("updated_at not bumped: %v -> %v", updated, updated2)
+	}
+}
+
+func TestGetPoliciesBySubject(t *testing.T) {
+	a := newTestAdapter(t)
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	got, err := a.GetPoliciesBySubject(context.Background(), "alice")
+	if err != nil {
+		t.Fatalf("GetPoliciesBySubject: %v", err)
+	}
+
+	want := map[string][][]string{
+		"p": {{"alice", "data1", "read"}},
+		"g": {{"alice", "data2_admin"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetPoliciesBySubject = %v, want %v", got, want)
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	a := newTestAdapter(t, WithWarmup(3))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := a.Open(ctx); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer a.close()
+
+	if stats := a.db.PoolStats(); stats.IdleConns < 3 {
+		t.Errorf("IdleConns = %d, want at least 3", stats.IdleConns)
+	}
+}
+
+func TestLoadFilteredPolicy(t *testing.T) {
+	a := newTestAdapter(t)
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	m := newTestModel()
+	m.ClearPolicy()
+	if err := a.LoadFilteredPolicy(m, "p"); err == nil {
+		t.Errorf("LoadFilteredPolicy accepted a string filter")
+	}
+	if err := a.LoadFilteredPolicy(m, map[string]string{"v0": "alice"}); err == nil {
+		t.Errorf("LoadFilteredPolicy accepted a map filter")
+	}
+
+	err := a.LoadFilteredPolicy(m, &Filter{PType: []string{"p"}, V0: []string{"alice", "bob"}})
+	if err != nil {
+		t.Fatalf("LoadFilteredPolicy: %v", err)
+	}
+	want := [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}}
+	if got := m.GetPolicy("p", "p"); !reflect.DeepEqual(got, want) {
+		t.Errorf("p rules = %v, want %v", got, want)
+	}
+	if got := m.GetPolicy("g", "g"); len(got) != 0 {
+		t.Errorf("g rules = %v, want none", got)
+	}
+	if !a.IsFiltered() {
+		t.Errorf("IsFiltered = false after LoadFilteredPolicy")
+	}
+}
+
+func TestStrictSchema(t *testing.T) {
+	lenient := newTestAdapter(t)
+	if err := lenient.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	if _, err := lenient.db.Exec("ALTER TABLE x_policy ADD COLUMN extra TEXT"); err != nil {
+		t.Fatalf("add column: %v", err)
+	}
+
+	m := newTestModel()
+	m.ClearPolicy()
+	if err := lenient.LoadPolicy(m); err != nil {
+		t.Errorf("lenient LoadPolicy: %v", err)
+	}
+
+	strict := NewAdapter(lenient.options.User, lenient.options.Password, lenient.options.Database, lenient.options.Addr, WithStrictSchema())
+	m.ClearPolicy()
+	err := strict.LoadPolicy(m)
+	if err == nil || !strings.Contains(err.Error(), "extra") {
+		t.Errorf("strict LoadPolicy err = %v, want unexpected column error", err)
+	}
+}
+
+func TestPTypeConstraint(t *testing.T) {
+	a := newTestAdapter(t, WithPTypeConstraint(PTypes(newTestModel())...))
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	err := a.AddPolicy("p", "pp", []string{"alice", "data1", "read"})
+	pgErr, ok := err.(pg.Error)
+	if !ok || pgErr.Field('C') != "23514" {
+		t.Errorf("AddPolicy with invalid ptype: err = %v, want check_violation", err)
+	}
+	if err := a.AddPolicy("p", "p", []string{"carol", "data1", "read"}); err != nil {
+		t.Errorf("AddPolicy with valid ptype: %v", err)
+	}
+}
+
+type recordingHook struct {
+	mu      sync.Mutex
+	queries []string
+}
+
+func (h *recordingHook) BeforeQuery(ctx context.Context, _ *pg.QueryEvent) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h *recordingHook) AfterQuery(_ context.Context, ev *pg.QueryEvent) error {
+	query, err := ev.UnformattedQuery()
+	if err != nil {
+		return nil
+	}
+	h.mu.Lock()
+	h.queries = append(h.queries, string(query))
+	h.mu.Unlock()
+	return nil
+}
+
+func (h *recordingHook) saw(substr string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, q := range h.queries {
+		if strings.Contains(q, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestQueryHook(t *testing.T) {
+	hook := &recordingHook{}
+	a := newTestAdapter(t, WithQueryHook(hook), WithPreparedStatements(false))
+
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	if !hook.saw("INSERT") {
+		t.Errorf("hook did not see the save: %q", hook.queries)
+	}
+
+	m := newTestModel()
+	m.ClearPolicy()
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if !hook.saw("FROM x_policy") {
+		t.Errorf("hook did not see the load: %q", hook.queries)
+	}
+}
+
+func TestSectionMismatch(t *testing.T) {
+	a := newTestAdapter(t)
+
+	if err := a.AddPolicy("g", "p", []string{"alice", "data1", "read"}); err == nil {
+		t.Errorf("AddPolicy accepted ptype p in section g")
+	}
+	if err := a.RemovePolicy("p", "g", []string{"alice", "admin"}); err == nil {
+		t.Errorf("RemovePolicy accepted ptype g in section p")
+	}
+	if err := a.RemoveFilteredPolicy("x", "x", 0, "alice"); err == nil {
+		t.Errorf("RemoveFilteredPolicy accepted section x")
+	}
+	if err := a.AddPolicy("g", "g2", []string{"alice", "admin"}); err != nil {
+		t.Errorf("AddPolicy rejected ptype g2 in section g: %v", err)
+	}
+}
+
+func TestAddPoliciesMixed(t *testing.T) {
+	a := newTestAdapter(t)
+	err := a.AddPoliciesMixed(context.Background(), []PolicyRule{
+		{Sec: "p", PType: "p", Rule: []string{"alice", "data1", "read"}},
+		{Sec: "g", PType: "g", Rule: []string{"alice", "admin"}},
+		{Sec: "p", PType: "p2", Rule: []string{"admin", "data2", "write"}},
+		{Sec: "p", PType: "p", Rule: []string{"bob", "data2", "read"}},
+	})
+	if err != nil {
+		t.Fatalf("AddPoliciesMixed: %v", err)
+	}
+	policies, err := a.GetAllPolicies(context.Background())
+	if err != nil {
+		t.Fatalf("GetAllPolicies: %v", err)
+	}
+	want := map[string][][]string{
+		"p":  {{"alice", "data1", "read"}, {"bob", "data2", "read"}},
+		"p2": {{"admin", "data2", "write"}},
+		"g":  {{"alice", "admin"}},
+	}
+	if !reflect.DeepEqual(policies, want) {
+		t.Errorf("table holds %q, want %q", policies, want)
+	}
+
+	err = a.AddPoliciesMixed(context.Background(), []PolicyRule{
+		{Sec: "p", PType: "p", Rule: []string{"carol", "data3", "read"}},
+		{Sec: "p", PType: "g", Rule: []string{"carol", "admin"}},
+	})
+	if err == nil {
+		t.Errorf("AddPoliciesMixed accepted ptype g in section p")
+	}
+	if policies, _ := a.GetAllPolicies(context.Background()); len(policies["p"]) != 2 {
+		t.Errorf("table holds %q after the rejected batch, want no rule of it added", policies["p"])
+	}
+}
+
+func TestRemovePolicyArity(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithNullUnusedColumns()}} {
+		a := newTestAdapter(t, opts...)
+		m := newTestModel()
+		m.ClearPolicy()
+		m.AddPolicy("g", "g", []string{"alice", "admin", "domain1"})
+		m.AddPolicy("g", "g", []string{"bob", "admin"})
+		if err := a.SavePolicy(m); err != nil {
+			t.Fatalf("SavePolicy: %v", err)
+		}
+
+		for _, rule := range [][]string{
+			{"alice", "admin"},
+			{"bob", "admin", "domain1"},
+			{"bob", "admin", "", "", "", "", "extra"},
+		} {
+			if err := a.RemovePolicy("g", "g", rule); err != nil {
+				t.Fatalf("RemovePolicy(%q): %v", rule, err)
+			}
+		}
+		failed, err := a.RemovePoliciesBestEffort("g", "g", [][]string{{"alice", "admin"}, {"bob"}})
+		if err != nil {
+			t.Fatalf("RemovePoliciesBestEffort: %v", err)
+		}
+		if len(failed) != 2 || failed[0].Err != ErrRuleNotFound || failed[1].Err != ErrRuleNotFound {
+			t.Errorf("RemovePoliciesBestEffort of too few values failed %v, want both rules not found", failed)
+		}
+		policies, err := a.GetAllPolicies(context.Background())
+		if err != nil {
+			t.Fatalf("GetAllPolicies: %v", err)
+		}
+		if len(policies["g"]) != 2 {
+			t.Errorf("table holds %q after removes of other arities, want both rules kept", policies["g"])
+		}
+
+		if err := a.RemovePolicy("g", "g", []string{"alice", "admin", "domain1"}); err != nil {
+			t.Fatalf("RemovePolicy: %v", err)
+		}
+		if err := a.RemovePolicy("g", "g", []string{"bob", "admin", ""}); err != nil {
+			t.Fatalf("RemovePolicy: %v", err)
+		}
+		if policies, _ := a.GetAllPolicies(context.Background()); len(policies["g"]) != 0 {
+			t.Errorf("table holds %q, want the exact removes to match", policies["g"])
+		}
+	}
+}
+
+func TestEachPolicy(t *testing.T) {
+	a := newTestAdapter(t)
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	count := 0
+	err := a.EachPolicy(context.Background(), func(ptype string, rule []string) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("EachPolicy: %v", err)
+	}
+	if count != 4 {
+		t.Errorf("EachPolicy visited %d rules, want 4", count)
+	}
+
+	stop := errors.New("stop")
+	count = 0
+	err = a.EachPolicy(context.Background(), func(ptype string, rule []string) error {
+		count++
+		if count == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("EachPolicy err = %v, want %v", err, stop)
+	}
+	if count != 2 {
+		t.Errorf("callback ran %d times after aborting, want 2", count)
+	}
+}
+
+func TestLoadRawPolicies(t *testing.T) {
+	a := newTestAdapter(t, WithSectionColumn())
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	lines, err := a.LoadRawPolicies(context.Background())
+	if err != nil {
+		t.Fatalf("LoadRawPolicies: %v", err)
+	}
+	want := []CasbinRule{
+		{PType: "g", V0: "alice", V1: "data2_admin", Sec: "g"},
+		{PType: "p", V0: "alice", V1: "data1", V2: "read", Sec: "p"},
+		{PType: "p", V0: "bob", V1: "data2", V2: "write", Sec: "p"},
+		{PType: "p", V0: "data2_admin", V1: "data2", V2: "read", Sec: "p"},
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("LoadRawPolicies = %+v, want %+v", lines, want)
+	}
+
+	if _, err := a.db.Exec("TRUNCATE x_policy"); err != nil {
+		t.Fatalf("TRUNCATE: %v", err)
+	}
+	if lines, err := a.LoadRawPolicies(context.Background()); err != nil || len(lines) != 0 {
+		t.Errorf("LoadRawPolicies of an empty table = %+v, %v, want no rows", lines, err)
+	}
+}
+
+func TestEachPolicyCanceled(t *testing.T) {
+	a := newTestAdapter(t)
+	a.open()
+	defer a.close()
+	if _, err := a.db.Exec("INSERT INTO x_policy (p_type, v0, v1, v2) SELECT 'p', 'user' || g, 'data', 'read' FROM generate_series(1, 200000) AS g"); err != nil {
+		t.Fatalf("INSERT: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	count := 0
+	start := time.Now()
+	err := a.EachPolicy(ctx, func(ptype string, rule []string) error {
+		count++
+		cancel()
+		return nil
+	})
+	if err != context.Canceled {
+		t.Errorf("EachPolicy err = %v, want %v", err, context.Canceled)
+	}
+	if count != 1 {
+		t.Errorf("callback ran %d times after the cancel, want 1", count)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("EachPolicy took %v to return after the cancel", d)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats := a.db.PoolStats()
+		if stats.IdleConns == stats.TotalConns {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pool has %d connections, %d idle after the cancel, want none in use", stats.TotalConns, stats.IdleConns)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := a.EachPolicy(context.Background(), func(string, []string) error { return nil }); err != nil {
+		t.Errorf("EachPolicy after the cancel: %v", err)
+	}
+}
+
+func TestJitterAge(t *testing.T) {
+	age := time.Hour
+	if got := jitterAge(age, 0, 0.9); got != age {
+		t.Errorf("jitterAge without jitter = %v, want %v", got, age)
+	}
+	if got := jitterAge(0, 0.1, 0.9); got != 0 {
+		t.Errorf("jitterAge of no max age = %v, want 0", got)
+	}
+	if got := jitterAge(age, 2, 0); got != 0 {
+		t.Errorf("jitterAge with fraction 2 = %v, want the fraction clamped to 1", got)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		got := jitterAge(age, 0.1, r.Float64())
+		if got < 54*time.Minute || got > 66*time.Minute {
+			t.Fatalf("jitterAge(%v, 0.1) = %v, want within 6 minutes of it", age, got)
+		}
+		seen[got] = true
+	}
+	if len(seen) < 90 {
+		t.Errorf("100 jittered ages took %d distinct values, want them spread", len(seen))
+	}
+}
+
+func TestPoolTimeout(t *testing.T) {
+	a := newTestAdapter(t, WithPoolSize(1), WithPoolTimeout(100*time.Millisecond))
+	a.open()
+	defer a.close()
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	defer tx.Rollback()
+
+	start := time.Now()
+	err = a.AddPolicy("p", "p", []string{"alice", "data1", "read"})
+	if err == nil {
+		t.Fatalf("AddPolicy succeeded with the only connection held")
+	}
+	if !strings.Contains(err.Error(), "pool timeout") {
+		t.Errorf("err = %v, want a pool timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("AddPolicy took %v to fail", elapsed)
+	}
+}
+
+func TestColumnNamer(t *testing.T) {
+	namer := func(field string) string {
+		if field == "PType" {
+			return "policy_type"
+		}
+		return "arg" + strings.TrimPrefix(field, "V")
+	}
+	a := newTestAdapter(t, WithColumnNamer(namer))
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	if err := a.AddPolicy("p", "p", []string{"bob", "data1", "read"}); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+	if err := a.RemovePolicy("p", "p", []string{"alice", "data1", "read"}); err != nil {
+		t.Fatalf("RemovePolicy: %v", err)
+	}
+
+	var cols []string
+	_, err := a.db.Query(&cols, "SELECT column_name FROM information_schema.columns WHERE table_name = 'x_policy' ORDER BY ordinal_position")
+	if err != nil {
+		t.Fatalf("reading columns: %v", err)
+	}
+	want := []string{"policy_type", "arg0", "arg1", "arg2", "arg3", "arg4", "arg5"}
+	if !reflect.DeepEqual(cols, want) {
+		t.Errorf("columns = %q, want %q", cols, want)
+	}
+
+	m := newTestModel()
+	m.ClearPolicy()
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if !m.HasPolicy("p", "p", []string{"bob", "data1", "read"}) || m.HasPolicy("p", "p", []string{"alice", "data1", "read"}) {
+		t.Errorf("loaded policy = %q", m.GetPolicy("p", "p"))
+	}
+}
+
+func TestColumnNamerInvalid(t *testing.T) {
+	a := NewAdapter("", "", "", "", WithColumnNamer(func(string) string { return "same" }))
+	if err := a.Open(context.Background()); err == nil {
+		t.Errorf("Open accepted duplicate column names")
+	}
+}
+
+func TestWithTx(t *testing.T) {
+	a := newTestAdapter(t)
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	txa := a.WithTx(tx)
+	if err := txa.AddPolicy("p", "p", []string{"carol", "data3", "read"}); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+	if err := txa.RemovePolicy("p", "p", []string{"alice", "data1", "read"}); err != nil {
+		t.Fatalf("RemovePolicy: %v", err)
+	}
+	lines, err := txa.GetAllPolicies(context.Background())
+	if err != nil {
+		t.Fatalf("GetAllPolicies in tx: %v", err)
+	}
+	if got := len(lines["p"]); got != 3 {
+		t.Errorf("transaction sees %d p rules, want 3", got)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+
+	m := newTestModel()
+	m.ClearPolicy()
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if m.HasPolicy("p", "p", []string{"carol", "data3", "read"}) {
+		t.Errorf("rule added in a rolled back transaction persisted")
+	}
+	if !m.HasPolicy("p", "p", []string{"alice", "data1", "read"}) {
+		t.Errorf("rule removed in a rolled back transaction is gone")
+	}
+}
+
+// filterCase is a random RemoveFilteredPolicy call against a random set of
+// rules, drawn from a small alphabet so that filters often match.
+type filterCase struct {
+	FieldIndex  int
+	FieldValues []string
+	Rules       [][]string
+}
+
+func (filterCase) Generate(r *rand.Rand, size int) reflect.Value {
+	pick := func(alphabet ...string) string { return alphabet[r.Intn(len(alphabet))] }
+
+	c := filterCase{FieldIndex: r.Intn(7)}
+	for i := r.Intn(7 - c.FieldIndex); i > 0; i-- {
+		c.FieldValues = append(c.FieldValues, pick("", "a", "b"))
+	}
+	for i := 0; i < 8; i++ {
+		rule := make([]string, 1+r.Intn(6))
+		for j := range rule {
+			rule[j] = pick("a", "b")
+		}
+		c.Rules = append(c.Rules, rule)
+	}
+	return reflect.ValueOf(c)
+}
+
+// matches is the definition of a filter: every non-empty value equals the
+// rule's value at its position, and a rule shorter than that has "" there.
+func (c filterCase) matches(rule []string) bool {
+	for i, v := range c.FieldValues {
+		var got string
+		if j := c.FieldIndex + i; j < len(rule) {
+			got = rule[j]
+		}
+		if v != "" && v != got {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFilteredLineProperty(t *testing.T) {
+	property := func(c filterCase) bool {
+		line, err := filteredLine("p", c.FieldIndex, c.FieldValues)
+		if err != nil {
+			return false
+		}
+		filter := lineValues(line)[1:]
+		for _, rule := range c.Rules {
+			row := lineValues(savePolicyLine("p", rule))[1:]
+			sqlMatch := true
+			for i, v := range filter {
+				if v != "" && v != row[i] {
+					sqlMatch = false
+				}
+			}
+			if sqlMatch != c.matches(rule) {
+				t.Logf("index %d values %q rule %q: WHERE gives %v", c.FieldIndex, c.FieldValues, rule, sqlMatch)
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFilteredLineOutOfRange(t *testing.T) {
+	for _, c := range []struct {
+		fieldIndex  int
+		fieldValues []string
+	}{
+		{-1, []string{"a"}},
+		{5, []string{"a", "b"}},
+		{6, []string{"a"}},
+		{7, nil},
+	} {
+		if _, err := filteredLine("p", c.fieldIndex, c.fieldValues); err == nil {
+			t.Errorf("filteredLine(%d, %q) accepted a filter past v5", c.fieldIndex, c.fieldValues)
+		}
+	}
+}
+
+func TestRemoveFilteredPolicyProperty(t *testing.T) {
+	a := newTestAdapter(t)
+
+	property := func(c filterCase) bool {
+		m := newTestModel()
+		m.ClearPolicy()
+		for _, rule := range c.Rules {
+			m.AddPolicy("p", "p", rule)
+		}
+		if err := a.SavePolicy(m); err != nil {
+			t.Fatalf("SavePolicy: %v", err)
+		}
+		if err := a.RemoveFilteredPolicy("p", "p", c.FieldIndex, c.FieldValues...); err != nil {
+			t.Fatalf("RemoveFilteredPolicy: %v", err)
+		}
+
+		var want [][]string
+		for _, rule := range m.GetPolicy("p", "p") {
+			if !c.matches(rule) {
+				want = append(want, rule)
+			}
+		}
+		m.ClearPolicy()
+		if err := a.LoadPolicy(m); err != nil {
+			t.Fatalf("LoadPolicy: %v", err)
+		}
+		got := m.GetPolicy("p", "p")
+		if len(got) != len(want) {
+			t.Logf("index %d values %q: kept %q, want %q", c.FieldIndex, c.FieldValues, got, want)
+			return false
+		}
+		for _, rule := range want {
+			if !m.HasPolicy("p", "p", rule) {
+				t.Logf("index %d values %q: removed %q", c.FieldIndex, c.FieldValues, rule)
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 25}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTablePrefix(t *testing.T) {
+	a := newTestAdapter(t, WithTablePrefix("app_"))
+	a.open()
+	if _, err := a.db.Exec("DROP TABLE IF EXISTS app_x_policy"); err != nil {
+		t.Fatalf("dropping app_x_policy: %v", err)
+	}
+	if err := a.createTable(a.db); err != nil {
+		t.Fatalf("createTable: %v", err)
+	}
+	defer a.db.Exec("DROP TABLE IF EXISTS app_x_policy")
+
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	if err := a.AddPolicy("p", "p", []string{"carol", "data3", "read"}); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+	if err := a.RemovePolicy("p", "p", []string{"alice", "data1", "read"}); err != nil {
+		t.Fatalf("RemovePolicy: %v", err)
+	}
+	m := newTestModel()
+	m.ClearPolicy()
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if !m.HasPolicy("p", "p", []string{"carol", "data3", "read"}) || m.HasPolicy("p", "p", []string{"alice", "data1", "read"}) {
+		t.Errorf("loaded policy = %q", m.GetPolicy("p", "p"))
+	}
+
+	var unprefixed bool
+	if _, err := a.db.QueryOne(pg.Scan(&unprefixed), "SELECT to_regclass('x_policy') IS NOT NULL"); err != nil {
+		t.Fatalf("looking up x_policy: %v", err)
+	}
+	if unprefixed {
+		t.Errorf("x_policy was created alongside app_x_policy")
+	}
+	for _, idx := range managedIndexes {
+		var n int
+		_, err := a.db.QueryOne(pg.Scan(&n), "SELECT count(*) FROM pg_indexes WHERE tablename = 'app_x_policy' AND indexname = ?", "app_x_policy"+idx.suffix)
+		if err != nil {
+			t.Fatalf("querying pg_indexes: %v", err)
+		}
+		if n != 1 {
+			t.Errorf("index app_x_policy%s missing", idx.suffix)
+		}
+	}
+}
+
+func TestTablePrefixInvalid(t *testing.T) {
+	a := NewAdapter("", "", "", "", WithTablePrefix("app-"))
+	if err := a.Open(context.Background()); err == nil {
+		t.Errorf("Open accepted table name app-x_policy")
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	a := newTestAdapter(t, WithIndexes(Index{
+		Name:    "x_policy_hot_tenants_idx",
+		Columns: []string{"v1", "v0"},
+		Where:   "v1 IN ('tenant1', 'tenant2')",
+	}))
+	a.open()
+
+	var def string
+	_, err := a.db.QueryOne(pg.Scan(&def), "SELECT indexdef FROM pg_indexes WHERE tablename = 'x_policy' AND indexname = 'x_policy_hot_tenants_idx'")
+	if err != nil {
+		t.Fatalf("looking up the index: %v", err)
+	}
+	if !strings.Contains(def, "(v1, v0)") || !strings.Contains(def, "WHERE") || !strings.Contains(def, "tenant2") {
+		t.Errorf("indexdef = %s, want a partial index on (v1, v0)", def)
+	}
+
+	a.close()
+	if err := a.Open(context.Background()); err != nil {
+		t.Errorf("reopening with the index in place: %v", err)
+	}
+}
+
+func TestIndexesInvalid(t *testing.T) {
+	a := NewAdapter("", "", "", "", WithIndexes(Index{Name: "idx", Columns: []string{"v0); DROP TABLE x_policy; --"}}))
+	if err := a.Open(context.Background()); err == nil {
+		t.Errorf("Open accepted an index on an invalid column")
+	}
+}
+
+func TestEnsureTableConcurrent(t *testing.T) {
+	const n = 10
+	adapters := make([]*Adapter, n)
+	for i := range adapters {
+		adapters[i] = newTestAdapter(t, WithPTypeConstraint("p", "g"), WithSoftDelete(), WithTimestamps())
+	}
+
+	start := make(chan struct{})
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for _, a := range adapters {
+		wg.Add(1)
+		go func(a *Adapter) {
+			defer wg.Done()
+			<-start
+			errs <- a.EnsureTable(context.Background())
+		}(a)
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("EnsureTable: %v", err)
+		}
+	}
+	for _, a := range adapters {
+		if a.db != nil {
+			a.close()
+		}
+	}
+}
+
+func TestNullUnusedColumns(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		opts  []Option
+		nulls int
+	}{
+		{"empty strings", nil, 0},
+		{"nulls", []Option{WithNullUnusedColumns()}, 4},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			a := newTestAdapter(t, c.opts...)
+			if err := a.SavePolicy(newTestModel()); err != nil {
+				t.Fatalf("SavePolicy: %v", err)
+			}
+			if err := a.AddPolicy("p", "p", []string{"carol", "data3", "read"}); err != nil {
+				t.Fatalf("AddPolicy: %v", err)
+			}
+			if err := a.RemovePolicy("p", "p", []string{"bob", "data2", "write"}); err != nil {
+				t.Fatalf("RemovePolicy: %v", err)
+			}
+			if err := a.UpdatePolicy("p", "p", []string{"carol", "data3", "read"}, []string{"carol", "data3", "write"}); err != nil {
+				t.Fatalf("UpdatePolicy: %v", err)
+			}
+
+			var nulls int
+			if _, err := a.db.QueryOne(pg.Scan(&nulls), "SELECT count(*) FROM x_policy WHERE v3 IS NULL"); err != nil {
+				t.Fatalf("counting NULLs: %v", err)
+			}
+			if nulls != c.nulls {
+				t.Errorf("%d rows with NULL v3, want %d", nulls, c.nulls)
+			}
+
+			m := newTestModel()
+			m.ClearPolicy()
+			if err := a.LoadFilteredPolicy(m, Filter{PType: []string{"p", "g"}, V3: []string{""}}); err != nil {
+				t.Fatalf("LoadFilteredPolicy: %v", err)
+			}
+			want := [][]string{{"alice", "data1", "read"}, {"carol", "data3", "write"}, {"data2_admin", "data2", "read"}}
+			if got := m.GetPolicy("p", "p"); !reflect.DeepEqual(got, want) {
+				t.Errorf("loaded p = %q, want %q", got, want)
+			}
+			if !m.HasPolicy("g", "g", []string{"alice", "data2_admin"}) {
+				t.Errorf("grouping rule did not round-trip")
+			}
+		})
+	}
+}
+
+// The go-pg interfaces the adapter implements, checked at compile time.
+var (
+	_ orm.HooklessModel = (*ruleStream)(nil)
+	_ pg.QueryHook      = queryLogger{}
+)
+
+func TestCasbinRuleInsertKeepsEmptyValues(t *testing.T) {
+	a := newTestAdapter(t)
+	a.open()
+
+	line := CasbinRule{PType: "p", V0: "alice", V2: "read"}
+	if _, err := a.db.Model(&line).Insert(); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+
+	var nulls int
+	if _, err := a.db.QueryOne(pg.Scan(&nulls), "SELECT count(*) FROM x_policy WHERE v1 IS NULL OR v5 IS NULL"); err != nil {
+		t.Fatalf("counting NULLs: %v", err)
+	}
+	if nulls != 0 {
+		t.Errorf("empty values were inserted as NULL")
+	}
+}
+
+func TestUnknownPTypes(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		mode    UnknownPTypeMode
+		wantErr bool
+	}{
+		{"error", UnknownPTypeError, true},
+		{"skip", UnknownPTypeSkip, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var logged strings.Builder
+			a := newTestAdapter(t, WithUnknownPTypes(c.mode), WithLogger(log.New(&logged, "", 0)))
+			if err := a.SavePolicy(newTestModel()); err != nil {
+				t.Fatalf("SavePolicy: %v", err)
+			}
+			if err := a.AddPolicy("p", "p2", []string{"alice", "data1", "read"}); err != nil {
+				t.Fatalf("AddPolicy: %v", err)
+			}
+
+			m := newTestModel()
+			m.ClearPolicy()
+			err := a.LoadPolicy(m)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("LoadPolicy loaded a rule of undefined ptype p2")
+				}
+				if len(m.GetPolicy("p", "p")) != 0 {
+					t.Errorf("failed LoadPolicy left rules in the model")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadPolicy: %v", err)
+			}
+			if len(m.GetPolicy("p", "p")) != 3 {
+				t.Errorf("loaded p = %q, want the 3 rules of ptype p", m.GetPolicy("p", "p"))
+			}
+			if !strings.Contains(logged.String(), "p2, alice, data1, read") {
+				t.Errorf("skipped rule not logged: %q", logged.String())
+			}
+		})
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	rw := newTestAdapter(t)
+	if err := rw.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	a := NewAdapter(rw.options.User, rw.options.Password, rw.options.Database, rw.options.Addr, WithReadOnly())
+
+	rule := []string{"alice", "data1", "read"}
+	for name, err := range map[string]error{
+		"SavePolicy":           a.SavePolicy(newTestModel()),
+		"AddPolicy":            a.AddPolicy("p", "p", rule),
+		"UpdatePolicy":         a.UpdatePolicy("p", "p", rule, []string{"alice", "data1", "write"}),
+		"RemovePolicy":         a.RemovePolicy("p", "p", rule),
+		"RemoveFilteredPolicy": a.RemoveFilteredPolicy("p", "p", 0, "alice"),
+	} {
+		if err != ErrReadOnly {
+			t.Errorf("%s: err = %v, want ErrReadOnly", name, err)
+		}
+	}
+
+	m := newTestModel()
+	m.ClearPolicy()
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if !m.HasPolicy("p", "p", rule) || len(m.GetPolicy("p", "p")) != 3 {
+		t.Errorf("loaded p = %q", m.GetPolicy("p", "p"))
+	}
+}
+
+func TestValueNormalizer(t *testing.T) {
+	a := newTestAdapter(t, WithValueNormalizer(strings.TrimSpace))
+	m := newTestModel()
+	m.ClearPolicy()
+	if err := a.SavePolicy(m); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	if err := a.AddPolicy("p", "p", []string{"alice ", "data1", " read"}); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if got := m.GetPolicy("p", "p"); len(got) != 1 || !m.HasPolicy("p", "p", []string{"alice", "data1", "read"}) {
+		t.Fatalf("loaded p = %q, want the trimmed rule", got)
+	}
+
+	if err := a.RemovePolicy("p", "p", []string{"alice", "data1", "read"}); err != nil {
+		t.Fatalf("RemovePolicy: %v", err)
+	}
+	m.ClearPolicy()
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if got := m.GetPolicy("p", "p"); len(got) != 0 {
+		t.Errorf("p after RemovePolicy = %q, want none", got)
+	}
+}
+
+func TestSavePolicyProgress(t *testing.T) {
+	var written, totals []int
+	a := newTestAdapter(t, WithProgress(1000, func(n, total int) {
+		written = append(written, n)
+		totals = append(totals, total)
+	}))
+
+	if err := a.SavePolicy(newBenchModel(2500)); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	if want := []int{1000, 2000, 2500}; !reflect.DeepEqual(written, want) {
+		t.Errorf("progress written = %v, want %v", written, want)
+	}
+	for i, total := range totals {
+		if total != 2500 {
+			t.Errorf("progress call %d total = %d, want 2500", i, total)
+		}
+	}
+}
+
+func TestLoadPolicyWithContext(t *testing.T) {
+	a := newTestAdapter(t)
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	if _, err := a.db.Exec("INSERT INTO x_policy (p_type, v0, v1, v2) VALUES ('p', 'alice', 'data1', 'read')"); err != nil {
+		t.Fatalf("inserting a duplicate row: %v", err)
+	}
+
+	m := newTestModel()
+	m.AddPolicy("p", "p", []string{"stale", "data0", "read"})
+	m.AddPolicy("g", "g", []string{"stale", "data2_admin"})
+	if err := a.LoadPolicyWithContext(context.Background(), m); err != nil {
+		t.Fatalf("LoadPolicyWithContext: %v", err)
+	}
+
+	want := newTestModel()
+	for _, sec := range []string{"p", "g"} {
+		if got, want := m.GetPolicy(sec, sec), want.GetPolicy(sec, sec); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %q, want %q", sec, got, want)
+		}
+	}
+}
+
+func TestLoadPolicyReusesPool(t *testing.T) {
+	a := newTestAdapter(t)
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	a.close()
+
+	pools := 0
+	defer func(orig func(*pg.Options) *pg.DB) { connect = orig }(connect)
+	connect = func(opt *pg.Options) *pg.DB {
+		pools++
+		return pg.Connect(opt)
+	}
+
+	m := newTestModel()
+	for i := 0; i < 50; i++ {
+		m.ClearPolicy()
+		if err := a.LoadPolicy(m); err != nil {
+			t.Fatalf("LoadPolicy %d: %v", i, err)
+		}
+	}
+	if pools != 1 {
+		t.Errorf("50 loads created %d pools, want 1", pools)
+	}
+}
+
+func TestRemovePoliciesBestEffort(t *testing.T) {
+	a := newTestAdapter(t)
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	failed, err := a.RemovePoliciesBestEffort("p", "p", [][]string{
+		{"alice", "data1", "read"},
+		{"carol", "data1", "read"},
+		{"bob", "data2", "write"},
+	})
+	if err != nil {
+		t.Fatalf("RemovePoliciesBestEffort: %v", err)
+	}
+	if len(failed) != 1 || !reflect.DeepEqual(failed[0].Rule, []string{"carol", "data1", "read"}) || failed[0].Err != ErrRuleNotFound {
+		t.Errorf("failed = %v, want only carol's rule, not found", failed)
+	}
+
+	m := newTestModel()
+	m.ClearPolicy()
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if got, want := m.GetPolicy("p", "p"), [][]string{{"data2_admin", "data2", "read"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("p after removal = %q, want %q", got, want)
+	}
+}
+
+func TestRemoveFilteredPolicyIn(t *testing.T) {
+	hook := &recordingHook{}
+	a := newTestAdapter(t, WithQueryHook(hook))
+	m := newTestModel()
+	var users [][]string
+	for i := 0; i < 50; i++ {
+		user := fmt.Sprintf("user%d", i)
+		m.AddPolicy("p", "p", []string{user, "data1", "read"})
+		m.AddPolicy("p", "p", []string{user, "data2", "write"})
+		users = append(users, []string{user})
+	}
+	if err := a.SavePolicy(m); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	hook.mu.Lock()
+	hook.queries = nil
+	hook.mu.Unlock()
+	n, err := a.RemoveFilteredPolicyIn(context.Background(), "p", 0, users)
+	if err != nil {
+		t.Fatalf("RemoveFilteredPolicyIn: %v", err)
+	}
+	if n != 100 {
+		t.Errorf("removed %d rules, want 100", n)
+	}
+	if len(hook.queries) != 1 {
+		t.Errorf("ran %d statements, want 1: %q", len(hook.queries), hook.queries)
+	}
+
+	n, err = a.RemoveFilteredPolicyIn(context.Background(), "p", 1, [][]string{{"", "write"}, {"data2"}})
+	if err != nil {
+		t.Fatalf("RemoveFilteredPolicyIn with a wildcard: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("removed %d rules, want bob's and data2_admin's", n)
+	}
+
+	m.ClearPolicy()
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if got, want := m.GetPolicy("p", "p"), [][]string{{"alice", "data1", "read"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("p after removal = %q, want %q", got, want)
+	}
+	if len(m.GetPolicy("g", "g")) != 1 {
+		t.Errorf("g after removal = %q, want it untouched", m.GetPolicy("g", "g"))
+	}
+}
+
+func TestRemoveFilteredPolicyReturning(t *testing.T) {
+	ctx := context.Background()
+	hook := &recordingHook{}
+	a := newTestAdapter(t, WithQueryHook(hook))
+	m := newTestModel()
+	m.AddPolicy("p", "p", []string{"carol", "data2", "write"})
+	if err := a.SavePolicy(m); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	hook.mu.Lock()
+	hook.queries = nil
+	hook.mu.Unlock()
+	removed, err := a.RemoveFilteredPolicyReturning(ctx, "p", 1, "data2")
+	if err != nil {
+		t.Fatalf("RemoveFilteredPolicyReturning: %v", err)
+	}
+	if len(hook.queries) != 1 {
+		t.Errorf("ran %d statements, want 1: %q", len(hook.queries), hook.queries)
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i][0] < removed[j][0] })
+	want := [][]string{{"bob", "data2", "write"}, {"carol", "data2", "write"}, {"data2_admin", "data2", "read"}}
+	if !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed %q, want %q", removed, want)
+	}
+
+	m.ClearPolicy()
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if got := m.GetPolicy("p", "p"); !reflect.DeepEqual(got, [][]string{{"alice", "data1", "read"}}) {
+		t.Errorf("p after removal = %q, want alice's rule only", got)
+	}
+
+	// The returned rules add back what was removed.
+	for _, rule := range removed {
+		if err := a.AddPolicy("p", "p", rule); err != nil {
+			t.Fatalf("AddPolicy: %v", err)
+		}
+	}
+	m.ClearPolicy()
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if got := m.GetPolicy("p", "p"); len(got) != 4 {
+		t.Errorf("p after undo = %q, want the 4 rules saved", got)
+	}
+
+	if removed, err := a.RemoveFilteredPolicyReturning(ctx, "p", 0, "nobody"); err != nil || len(removed) != 0 {
+		t.Errorf("removal matching nothing = %q, %v, want no rules", removed, err)
+	}
+}
+
+func TestPgBouncerCompatible(t *testing.T) {
+	hook := &recordingHook{}
+	a := newTestAdapter(t, WithPgBouncerCompatible(), WithQueryHook(hook))
+	if !a.noPrepare {
+		t.Fatal("WithPgBouncerCompatible left prepared statements on")
+	}
+
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	if err := a.AddPolicy("p", "p", []string{"carol", "data3", "read"}); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+	if err := a.RemovePolicy("p", "p", []string{"alice", "data1", "read"}); err != nil {
+		t.Fatalf("RemovePolicy: %v", err)
+	}
+	m := newTestModel()
+	m.ClearPolicy()
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if !m.HasPolicy("p", "p", []string{"carol", "data3", "read"}) || m.HasPolicy("p", "p", []string{"alice", "data1", "read"}) {
+		t.Errorf("loaded p = %q", m.GetPolicy("p", "p"))
+	}
+
+	if n := len(a.stmts.stmts); n != 0 {
+		t.Errorf("%d statements prepared, want none", n)
+	}
+	for _, q := range hook.queries {
+		if strings.HasPrefix(q, "SET ") && !strings.HasPrefix(q, "SET LOCAL ") && !strings.HasPrefix(q, "SET TRANSACTION ") {
+			t.Errorf("session-level SET issued: %q", q)
+		}
+	}
+}
+
+func TestAddPolicyIfNotExists(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAdapter(t, WithValueNormalizer(strings.TrimSpace))
+	m := newTestModel()
+	m.ClearPolicy()
+	if err := a.SavePolicy(m); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	want := []string{"alice", "data1", "read"}
+	existed, stored, err := a.AddPolicyIfNotExists(ctx, "p", []string{"alice ", "data1", "read"})
+	if err != nil {
+		t.Fatalf("AddPolicyIfNotExists: %v", err)
+	}
+	if existed || !reflect.DeepEqual(stored, want) {
+		t.Errorf("fresh add = %v, %q, want false, %q", existed, stored, want)
+	}
+
+	existed, stored, err = a.AddPolicyIfNotExists(ctx, "p", []string{" alice", "data1 ", "read"})
+	if err != nil {
+		t.Fatalf("AddPolicyIfNotExists: %v", err)
+	}
+	if !existed || !reflect.DeepEqual(stored, want) {
+		t.Errorf("conflicting add = %v, %q, want true, %q", existed, stored, want)
+	}
+
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if got := m.GetPolicy("p", "p"); !reflect.DeepEqual(got, [][]string{want}) {
+		t.Errorf("loaded p = %q, want the rule once", got)
+	}
+
+	if _, _, err := a.AddPolicyIfNotExists(ctx, "", want); err == nil {
+		t.Error("AddPolicyIfNotExists with an empty ptype: err = nil")
+	}
+}
+
+func TestAddPolicyIfNotExistsConcurrent(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAdapter(t, WithPoolSize(4))
+	a.open()
+	defer a.close()
+
+	for round := 0; round < 20; round++ {
+		rule := []string{"alice", fmt.Sprintf("data%d", round), "read"}
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		added := make([]bool, 2)
+		errs := make([]error, 2)
+		for i := range added {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				<-start
+				var existed bool
+				existed, _, errs[i] = a.AddPolicyIfNotExists(ctx, "p", rule)
+				added[i] = !existed
+			}(i)
+		}
+		close(start)
+		wg.Wait()
+
+		for _, err := range errs {
+			if err != nil {
+				t.Fatalf("AddPolicyIfNotExists: %v", err)
+			}
+		}
+		if added[0] == added[1] {
+			t.Errorf("round %d: concurrent adds reported added = %v, want exactly one", round, added)
+		}
+		var n int
+		if _, err := a.db.QueryOne(pg.Scan(&n), "SELECT count(*) FROM x_policy WHERE v1 = ?", rule[1]); err != nil {
+			t.Fatalf("count: %v", err)
+		}
+		if n != 1 {
+			t.Errorf("round %d: %d rows hold %q, want 1", round, n, rule)
+		}
+	}
+}
+
+func TestStorageParams(t *testing.T) {
+	params := map[string]string{"fillfactor": "70", "autovacuum_vacuum_scale_factor": "0.05"}
+	a := newTestAdapter(t, WithStorageParams(params))
+	if got, want := a.storageClause(), " WITH (autovacuum_vacuum_scale_factor = 0.05, fillfactor = 70)"; got != want {
+		t.Errorf("storageClause = %q, want %q", got, want)
+	}
+	a.open()
+	defer a.close()
+
+	var opts []string
+	_, err := a.db.QueryOne(pg.Scan(pg.Array(&opts)), "SELECT reloptions FROM pg_class WHERE relname = 'x_policy'")
+	if err != nil {
+		t.Fatalf("reading reloptions: %v", err)
+	}
+	sort.Strings(opts)
+	if want := []string{"autovacuum_vacuum_scale_factor=0.05", "fillfactor=70"}; !reflect.DeepEqual(opts, want) {
+		t.Errorf("reloptions = %q, want %q", opts, want)
+	}
+}
+
+func TestStorageParamsInvalid(t *testing.T) {
+	for _, params := range []map[string]string{
+		{"fillfactor": "70); DROP TABLE x_policy; --"},
+		{"fill factor": "70"},
+	} {
+		a := NewAdapter("", "", "", "", WithStorageParams(params))
+		if err := a.Open(context.Background()); err == nil {
+			a.close()
+			t.Errorf("Open with storage parameters %q: err = nil, want an error", params)
+		}
+	}
+}
+
+func TestAddPolicyReturningID(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAdapter(t)
+	if _, err := a.AddPolicyReturningID(ctx, "p", []string{"alice", "data1", "read"}); err == nil {
+		t.Error("AddPolicyReturningID without an id column: err = nil")
+	}
+
+	if _, err := a.db.Exec("ALTER TABLE x_policy ADD COLUMN id BIGSERIAL PRIMARY KEY"); err != nil {
+		t.Fatalf("adding id column: %v", err)
+	}
+	var last int64
+	for _, rule := range [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"carol", "data3", "read"}} {
+		id, err := a.AddPolicyReturningID(ctx, "p", rule)
+		if err != nil {
+			t.Fatalf("AddPolicyReturningID(%q): %v", rule, err)
+		}
+		if id <= last {
+			t.Errorf("AddPolicyReturningID(%q) = %d, want more than %d", rule, id, last)
+		}
+		last = id
+	}
+}
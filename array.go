@@ -0,0 +1,110 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// WithArrayColumns stores values i of rules (0 for v0, and so on) in TEXT[]
+// columns rather than VARCHAR ones, for set-valued fields such as a list of
+// allowed actions. A value is written as the array of its elements separated
+// by sep, with surrounding spaces trimmed, so with sep "," the value
+// "read, write" is stored as {read,write} and other consumers of the table
+// can query it with @> ARRAY['read']. It loads as the elements joined by sep,
+// "read,write", and rules are matched on that joined form too. The column
+// types are only used when the adapter creates the table; an existing table
+// keeps its columns. Open fails if an index is outside 0 to 5 or sep is
+// empty.
+func WithArrayColumns(sep string, values ...int) Option {
+	return func(a *Adapter) {
+		a.arraySep = sep
+		a.arrayCols = append(a.arrayCols, values...)
+	}
+}
+
+// checkArrayColumns validates the options given to WithArrayColumns.
+func (a *Adapter) checkArrayColumns() error {
+	if len(a.arrayCols) == 0 {
+		return nil
+	}
+	if a.arraySep == "" {
+		return fmt.Errorf("adapter: empty array separator")
+	}
+	for _, i := range a.arrayCols {
+		if i < 0 || i > 5 {
+			return fmt.Errorf("adapter: array column v%d out of range", i)
+		}
+	}
+	return nil
+}
+
+// isArray reports whether value i of a rule is stored in an array column.
+func (a *Adapter) isArray(i int) bool {
+	for _, j := range a.arrayCols {
+		if i == j {
+			return true
+		}
+	}
+	return false
+}
+
+// splitArray returns the elements of value v of an array column.
+func (a *Adapter) splitArray(v string) []string {
+	elems := []string{}
+	if strings.TrimSpace(v) == "" {
+		return elems
+	}
+	for _, e := range strings.Split(v, a.arraySep) {
+		elems = append(elems, strings.TrimSpace(e))
+	}
+	return elems
+}
+
+// valueExpr returns the SQL expression for value i of a rule as a string:
+// the column itself, or for an array column its elements joined by the
+// separator.
+func (a *Adapter) valueExpr(i int) string {
+	if !a.isArray(i) {
+		return a.valueCol(i)
+	}
+	return "array_to_string(" + a.valueCol(i) + ", " + quoteLiteral(a.arraySep) + ")"
+}
+
+// matchValue returns v in the form valueExpr(i) yields it, so that values
+// differing only in the spacing around separators match.
+func (a *Adapter) matchValue(i int, v string) string {
+	if !a.isArray(i) {
+		return v
+	}
+	return strings.Join(a.splitArray(v), a.arraySep)
+}
+
+// storedValue returns v as written to the column of value i.
+func (a *Adapter) storedValue(i int, v interface{}) interface{} {
+	s, ok := v.(string)
+	if !ok || !a.isArray(i) {
+		return v
+	}
+	return pg.Array(a.splitArray(s))
+}
+
+// quoteLiteral quotes s as an SQL string literal.
+func quoteLiteral(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
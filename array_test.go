@@ -0,0 +1,99 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+)
+
+func TestSplitArray(t *testing.T) {
+	a := NewAdapter("", "", "", "", WithArrayColumns(",", 2))
+	for v, want := range map[string][]string{
+		"":             {},
+		"read":         {"read"},
+		"read, write ": {"read", "write"},
+	} {
+		if got := a.splitArray(v); !reflect.DeepEqual(got, want) {
+			t.Errorf("splitArray(%q) = %q, want %q", v, got, want)
+		}
+	}
+	if got := a.matchValue(2, "read , write"); got != "read,write" {
+		t.Errorf("matchValue = %q, want %q", got, "read,write")
+	}
+	if got := a.matchValue(1, "read , write"); got != "read , write" {
+		t.Errorf("matchValue of a plain column = %q, want it unchanged", got)
+	}
+}
+
+func TestArrayColumnsInvalid(t *testing.T) {
+	for _, opt := range []Option{WithArrayColumns("", 2), WithArrayColumns(",", 6)} {
+		a := NewAdapter("", "", "", "", opt)
+		if err := a.Open(context.Background()); err == nil {
+			a.close()
+			t.Errorf("Open with %+v array columns: err = nil, want an error", a.arrayCols)
+		}
+	}
+}
+
+func TestArrayColumns(t *testing.T) {
+	a := newTestAdapter(t, WithArrayColumns(",", 2))
+	m := newTestModel()
+	m.ClearPolicy()
+	if err := a.SavePolicy(m); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	if err := a.AddPolicy("p", "p", []string{"alice", "data1", "read, write"}); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+
+	var n int
+	_, err := a.db.QueryOne(pg.Scan(&n), "SELECT count(*) FROM x_policy WHERE v2 @> ARRAY['write']")
+	if err != nil {
+		t.Fatalf("containment query: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("rows containing write = %d, want 1", n)
+	}
+
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if got := m.GetPolicy("p", "p"); !reflect.DeepEqual(got, [][]string{{"alice", "data1", "read,write"}}) {
+		t.Errorf("loaded p = %q, want the joined set", got)
+	}
+
+	if err := a.LoadFilteredPolicy(m, Filter{V2: []string{"read,write"}}); err != nil {
+		t.Fatalf("LoadFilteredPolicy: %v", err)
+	}
+	if got := m.GetPolicy("p", "p"); len(got) != 1 {
+		t.Errorf("filtered p = %q, want the rule", got)
+	}
+
+	if err := a.RemovePolicy("p", "p", []string{"alice", "data1", "read,write"}); err != nil {
+		t.Fatalf("RemovePolicy: %v", err)
+	}
+	m.ClearPolicy()
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if got := m.GetPolicy("p", "p"); len(got) != 0 {
+		t.Errorf("p after RemovePolicy = %q, want none", got)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+// WithAudit records every change to the policy in <prefix>x_policy_audit,
+// created on open, in the same transaction as the change, so that a change
+// is committed if and only if its audit rows are. A row holds the Change
+// operation, the ptype, the rule's values and, for OpUpdate, the new values
+// in new_rule, or for OpRemoveFiltered the filter values and field_index;
+// the actor fn returns for the context of the call, the tenant, and the time
+// in at. SavePolicy writes one OpSave row per rule it saves. Purge and
+// ArchiveTable are not recorded.
+func WithAudit(fn func(ctx context.Context) string) Option {
+	return func(a *Adapter) {
+		a.auditActor = fn
+	}
+}
+
+func (a *Adapter) createAuditTable(db orm.DB) error {
+	return ddl(db, "CREATE TABLE IF NOT EXISTS "+a.auditTable+" ("+
+		"id BIGSERIAL PRIMARY KEY, "+
+		"op VARCHAR(32) NOT NULL, "+
+		"p_type VARCHAR(32) NOT NULL, "+
+		"rule TEXT[] NOT NULL, "+
+		"new_rule TEXT[], "+
+		"field_index INT, "+
+		"actor VARCHAR(256) NOT NULL DEFAULT '', "+
+		"tenant VARCHAR(256) NOT NULL DEFAULT '', "+
+		"at TIMESTAMPTZ NOT NULL DEFAULT now())")
+}
+
+// audit writes the audit rows of c to db.
+func (a *Adapter) audit(ctx context.Context, db orm.DB, c Change) error {
+	if a.auditActor == nil {
+		return nil
+	}
+	actor := a.auditActor(ctx)
+	tenant, err := a.tenant(ctx)
+	if err != nil {
+		return err
+	}
+
+	at, atParams := a.stamp()
+	query := a.tag(ctx, "INSERT INTO "+a.auditTable+" (op, p_type, rule, new_rule, field_index, actor, tenant, at) "+
+		"VALUES (?, ?, ?, ?, ?, ?, ?, "+at+")")
+	insert := func(rule []string, newRule, fieldIndex interface{}) error {
+		params := append([]interface{}{c.Op, rule[0], pg.Array(rule[1:]), newRule, fieldIndex, actor, tenant}, atParams...)
+		_, err := db.Exec(query, params...)
+		return err
+	}
+
+	switch {
+	case c.Op == OpUpdate && len(c.Rules) == 2:
+		return insert(c.Rules[0], pg.Array(c.Rules[1][1:]), nil)
+	case c.Op == OpRemoveFiltered:
+		for _, rule := range c.Rules {
+			if err := insert(rule, nil, c.FieldIndex); err != nil {
+				return err
+			}
+		}
+	default:
+		for _, rule := range c.Rules {
+			if err := insert(rule, nil, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// record audits and notifies c, made in db.
+func (a *Adapter) record(ctx context.Context, db orm.DB, c Change) error {
+	if err := a.audit(ctx, db, c); err != nil {
+		return err
+	}
+	return a.notify(db, c)
+}
+
+// execChange runs the statement making the change c, through a prepared
+// statement if prepared is set, and records c. Under WithAudit the statement
+// and the audit rows share a transaction, as they do under
+// WithRowLevelSecurity, which needs one for the tenant setting.
+func (a *Adapter) execChange(ctx context.Context, c Change, prepared bool, query string, params ...interface{}) (pg.Result, error) {
+	return a.queryChange(ctx, c, prepared, nil, query, params...)
+}
+
+// queryChange is execChange scanning the rows the statement returns into
+// model, unless model is nil. It never prepares a statement with a model.
+func (a *Adapter) queryChange(ctx context.Context, c Change, prepared bool, model interface{}, query string, params ...interface{}) (pg.Result, error) {
+	if err := a.flushWrites(ctx); err != nil {
+		return nil, err
+	}
+	run := func(db orm.DB) (pg.Result, error) {
+		if model != nil {
+			return db.Query(model, a.tag(ctx, query), params...)
+		}
+		return db.Exec(a.tag(ctx, query), params...)
+	}
+	if a.auditActor != nil || a.rlsSetting != "" {
+		var res pg.Result
+		err := a.runInTx(ctx, func(tx *pg.Tx) error {
+			var err error
+			if res, err = run(tx); err != nil {
+				return err
+			}
+			return a.record(ctx, tx, c)
+		})
+		if err != nil {
+			return nil, err
+		}
+		a.observeRows(c.Op, res.RowsAffected())
+		return res, nil
+	}
+
+	var res pg.Result
+	var err error
+	if prepared && model == nil {
+		res, err = a.stmtExec(ctx, query, params...)
+	} else {
+		res, err = run(a.conn(ctx))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := a.notify(a.conn(ctx), c); err != nil {
+		return nil, err
+	}
+	a.observeRows(c.Op, res.RowsAffected())
+	return res, nil
+}
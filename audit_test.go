@@ -0,0 +1,69 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type actorKey struct{}
+
+func actorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+type auditRow struct {
+	Op    string    `pg:"op"`
+	PType string    `pg:"p_type"`
+	Rule  []string  `pg:"rule,array"`
+	Actor string    `pg:"actor"`
+	At    time.Time `pg:"at"`
+}
+
+func TestAudit(t *testing.T) {
+	a := newTestAdapter(t, WithAudit(actorFromContext))
+	a.open()
+	defer a.close()
+	if _, err := a.db.Exec("TRUNCATE x_policy_audit"); err != nil {
+		t.Fatalf("TRUNCATE: %v", err)
+	}
+
+	b := a.WithContext(context.WithValue(context.Background(), actorKey{}, "admin@example.com"))
+	rule := []string{"alice", "data1", "read"}
+	if err := b.AddPolicy("p", "p", rule); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+	if err := b.RemovePolicy("p", "p", rule); err != nil {
+		t.Fatalf("RemovePolicy: %v", err)
+	}
+
+	var rows []auditRow
+	if _, err := a.db.Query(&rows, "SELECT op, p_type, rule, actor, at FROM x_policy_audit ORDER BY id"); err != nil {
+		t.Fatalf("reading audit rows: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("got %d audit rows, want 2: %+v", len(rows), rows)
+	}
+	for i, op := range []string{OpAdd, OpRemove} {
+		row := rows[i]
+		if row.Op != op || row.PType != "p" || !reflect.DeepEqual(row.Rule, rule) || row.Actor != "admin@example.com" || row.At.IsZero() {
+			t.Errorf("audit row %d = %+v, want %s of p %q by admin@example.com", i, row, op, rule)
+		}
+	}
+}
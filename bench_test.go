@@ -0,0 +1,231 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The benchmarks run against the database newTestAdapter connects to, which
+// they empty first, and are skipped if it is unreachable. Run them with
+//
+//	PG_ADDR=localhost:5432 go test -run '^$' -bench . -benchmem -count 10 > new.txt
+//
+// and compare two runs with benchstat old.txt new.txt.
+
+package adapter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/model"
+)
+
+// newBenchModel returns the test model holding n policy rules.
+func newBenchModel(n int) model.Model {
+	m := make(model.Model)
+	m.LoadModelFromText(testModelText)
+	for i := 0; i < n; i++ {
+		m.AddPolicy("p", "p", []string{fmt.Sprintf("user%d", i), fmt.Sprintf("data%d", i%100), "read"})
+	}
+	return m
+}
+
+func benchmarkRepeatedLoad(b *testing.B, opts ...Option) {
+	a := newTestAdapter(b, opts...)
+	if err := a.SavePolicy(newBenchModel(1000)); err != nil {
+		b.Fatalf("SavePolicy: %v", err)
+	}
+	defer a.close()
+
+	m := newBenchModel(0)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.ClearPolicy()
+		if err := a.LoadPolicy(m); err != nil {
+			b.Fatalf("LoadPolicy: %v", err)
+		}
+	}
+}
+
+func BenchmarkLoadPolicy(b *testing.B) {
+	for _, n := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprintf("rows=%d", n), func(b *testing.B) {
+			a := newTestAdapter(b)
+			if err := a.SavePolicy(newBenchModel(n)); err != nil {
+				b.Fatalf("SavePolicy: %v", err)
+			}
+			defer a.close()
+
+			m := newBenchModel(0)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				m.ClearPolicy()
+				if err := a.LoadPolicy(m); err != nil {
+					b.Fatalf("LoadPolicy: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSavePolicy(b *testing.B) {
+	a := newTestAdapter(b)
+	a.open()
+	defer a.close()
+
+	m := newBenchModel(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := a.SavePolicy(m); err != nil {
+			b.Fatalf("SavePolicy: %v", err)
+		}
+	}
+}
+
+func BenchmarkAddPolicy(b *testing.B) {
+	a := newTestAdapter(b)
+	a.open()
+	defer a.close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := a.AddPolicy("p", "p", []string{fmt.Sprintf("user%d", i), "data", "read"}); err != nil {
+			b.Fatalf("AddPolicy: %v", err)
+		}
+	}
+}
+
+func BenchmarkRemoveFilteredPolicy(b *testing.B) {
+	a := newTestAdapter(b)
+	if err := a.SavePolicy(newBenchModel(b.N)); err != nil {
+		b.Fatalf("SavePolicy: %v", err)
+	}
+	defer a.close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := a.RemoveFilteredPolicy("p", "p", 0, fmt.Sprintf("user%d", i)); err != nil {
+			b.Fatalf("RemoveFilteredPolicy: %v", err)
+		}
+	}
+}
+
+// policyCSV is an io.Reader of n generated policy lines, made as they are
+// read so that the input itself takes no memory.
+type policyCSV struct {
+	n, i int
+	buf  []byte
+}
+
+func (r *policyCSV) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.i == r.n {
+			return 0, io.EOF
+		}
+		r.buf = []byte(fmt.Sprintf("p, user%d, data%d, read\n", r.i, r.i%100))
+		r.i++
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// BenchmarkImportCSV imports two million rules, over 50 MB of CSV, and fails
+// if the heap grows by more than 16 MB while it runs.
+func BenchmarkImportCSV(b *testing.B) {
+	const rules = 2000000
+	a := newTestAdapter(b)
+	a.open()
+	defer a.close()
+
+	var peak uint64
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		if _, err := a.db.Exec("TRUNCATE x_policy"); err != nil {
+			b.Fatalf("TRUNCATE: %v", err)
+		}
+		runtime.GC()
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		base := ms.HeapInuse
+
+		stop, sampled := make(chan struct{}), make(chan uint64)
+		go func() {
+			var max uint64
+			t := time.NewTicker(10 * time.Millisecond)
+			defer t.Stop()
+			for {
+				select {
+				case <-stop:
+					sampled <- max
+					return
+				case <-t.C:
+					var ms runtime.MemStats
+					runtime.ReadMemStats(&ms)
+					if ms.HeapInuse > base && ms.HeapInuse-base > max {
+						max = ms.HeapInuse - base
+					}
+				}
+			}
+		}()
+		b.StartTimer()
+
+		n, err := a.ImportCSV(context.Background(), &policyCSV{n: rules})
+
+		b.StopTimer()
+		close(stop)
+		if grown := <-sampled; grown > peak {
+			peak = grown
+		}
+		if err != nil {
+			b.Fatalf("ImportCSV: %v", err)
+		}
+		if n != rules {
+			b.Fatalf("ImportCSV added %d rules, want %d", n, rules)
+		}
+		b.StartTimer()
+	}
+	b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MB")
+	if peak > 16<<20 {
+		b.Errorf("heap grew by %d MB during the import, want at most 16", peak>>20)
+	}
+}
+
+func BenchmarkLoadPolicyPrepared(b *testing.B) {
+	benchmarkRepeatedLoad(b)
+}
+
+func BenchmarkLoadPolicyUnprepared(b *testing.B) {
+	benchmarkRepeatedLoad(b, WithPreparedStatements(false))
+}
+
+// BenchmarkInsertParams measures the parameters of one AddPolicy insert, with
+// and without insertScratchPool. It needs no database.
+func BenchmarkInsertParams(b *testing.B) {
+	a := NewAdapter("", "", "", "")
+	line := savePolicyLine("p", []string{"alice", "data1", "read"})
+	b.Run("alloc", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = a.insertParams(line, "")
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			releaseInsertParams(a.pooledInsertParams(line, ""))
+		}
+	})
+}
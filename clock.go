@@ -0,0 +1,39 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import "time"
+
+// WithClock stamps the times the adapter records, the created_at and
+// updated_at of WithTimestamps, the deleted_at of WithSoftDelete and the
+// times of audit rows and snapshots, with clock instead of the database's
+// now(), for deterministic tests or a logical clock. time.Now gives the
+// application servers' time. Without WithClock the database stamps the
+// times, so that instances whose clocks disagree still agree on the order of
+// changes, which LoadIncremental relies on.
+func WithClock(clock func() time.Time) Option {
+	return func(a *Adapter) {
+		a.clock = clock
+	}
+}
+
+// stamp returns the SQL expression for the current time, with its
+// parameters: now(), or a placeholder for the time of WithClock.
+func (a *Adapter) stamp() (string, []interface{}) {
+	if a.clock == nil {
+		return "now()", nil
+	}
+	return "?", []interface{}{a.clock()}
+}
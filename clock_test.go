@@ -0,0 +1,53 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClock(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	a := newTestAdapter(t, WithTimestamps(), WithSoftDelete(), WithClock(func() time.Time { return now }))
+	added := now
+
+	if err := a.AddPolicy("p", "p", []string{"alice", "data1", "read"}); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+	now = now.Add(time.Hour)
+	updated := now
+	if err := a.UpdatePolicy("p", "p", []string{"alice", "data1", "read"}, []string{"alice", "data1", "write"}); err != nil {
+		t.Fatalf("UpdatePolicy: %v", err)
+	}
+	now = now.Add(time.Hour)
+	deleted := now
+	if err := a.RemovePolicy("p", "p", []string{"alice", "data1", "write"}); err != nil {
+		t.Fatalf("RemovePolicy: %v", err)
+	}
+
+	var row struct {
+		CreatedAt time.Time
+		UpdatedAt time.Time
+		DeletedAt time.Time
+	}
+	if _, err := a.db.QueryOne(&row, "SELECT created_at, updated_at, deleted_at FROM x_policy"); err != nil {
+		t.Fatalf("reading timestamps: %v", err)
+	}
+	if !row.CreatedAt.Equal(added) || !row.UpdatedAt.Equal(updated) || !row.DeletedAt.Equal(deleted) {
+		t.Errorf("stamped created %v, updated %v, deleted %v; want %v, %v, %v",
+			row.CreatedAt, row.UpdatedAt, row.DeletedAt, added, updated, deleted)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-pg/pg/v10/orm"
+)
+
+// ConflictTarget names the unique constraint or index an insert that may
+// duplicate a stored rule conflicts on, for the ON CONFLICT clause of
+// WithConflictTarget. Set one of its fields.
+type ConflictTarget struct {
+	// Constraint is the name of a unique or exclusion constraint, as in
+	// ON CONFLICT ON CONSTRAINT x_policy_rule_key.
+	Constraint string
+	// Columns are the columns of a unique index, as in
+	// ON CONFLICT (p_type, v0, v1, v2, v3, v4, v5). The index must not be
+	// partial.
+	Columns []string
+}
+
+// WithConflictTarget makes every insert of a rule, by the adds, SavePolicy
+// and RestoreSnapshot, skip a rule that would violate target, with
+// ON CONFLICT target DO NOTHING, for tables carrying their own unique
+// constraint over the rule. Under WithRuleHash the target defaults to the
+// unique index on rule_hash; otherwise inserts have no ON CONFLICT clause
+// and a duplicate fails as the table's constraints dictate. On a server
+// before 9.5, which lacks ON CONFLICT, the inserts instead look for each
+// rule under an advisory lock first, as AddPolicyIfNotExists does, and skip
+// those the table holds. Open fails unless exactly one field of target is
+// set, to plain SQL identifiers.
+func WithConflictTarget(target ConflictTarget) Option {
+	return func(a *Adapter) {
+		a.conflictTarget = &target
+	}
+}
+
+// checkConflictTarget validates the target of WithConflictTarget.
+func (a *Adapter) checkConflictTarget() error {
+	target := a.conflictTarget
+	if target == nil {
+		return nil
+	}
+	if (target.Constraint == "") == (len(target.Columns) == 0) {
+		return fmt.Errorf("adapter: conflict target needs either a constraint or columns")
+	}
+	if target.Constraint != "" && !identifierRe.MatchString(target.Constraint) {
+		return fmt.Errorf("adapter: invalid constraint name %q", target.Constraint)
+	}
+	for _, col := range target.Columns {
+		if !identifierRe.MatchString(col) {
+			return fmt.Errorf("adapter: invalid column name %q in conflict target", col)
+		}
+	}
+	return nil
+}
+
+// WithUpsertOnAdd makes the adds, AddPolicy, AddPolicies, AddPolicyReturningID
+// and the buffered adds of WithWriteBuffer, refresh the row of a rule the
+// table already holds instead of skipping it, with ON CONFLICT target
+// DO UPDATE: updated_at is stamped under WithTimestamps, and each of cols is
+// set to the value the insert would have stored, EXCLUDED.col, that is the
+// column's default or what a BEFORE INSERT trigger set it to. The target is
+// that of WithConflictTarget or WithRuleHash, and Open fails without one,
+// with a column that is not a plain SQL identifier, with nothing to update,
+// or on a server before 9.5. SavePolicy and RestoreSnapshot, which write to a cleared table, and
+// AddPolicyIfNotExists, which leaves a stored rule alone, keep DO NOTHING. A
+// rule given twice to one AddPolicies fails it, since one statement cannot
+// update a row twice.
+func WithUpsertOnAdd(cols ...string) Option {
+	return func(a *Adapter) {
+		a.upsert = true
+		a.upsertCols = append(a.upsertCols, cols...)
+	}
+}
+
+// checkUpsert validates the options of WithUpsertOnAdd.
+func (a *Adapter) checkUpsert() error {
+	if !a.upsert {
+		return nil
+	}
+	if a.conflictTarget == nil && !a.ruleHash {
+		return fmt.Errorf("adapter: WithUpsertOnAdd requires WithConflictTarget or WithRuleHash")
+	}
+	if len(a.upsertCols) == 0 && !a.timestamps {
+		return fmt.Errorf("adapter: WithUpsertOnAdd has no columns to update without WithTimestamps")
+	}
+	for _, col := range a.upsertCols {
+		if !identifierRe.MatchString(col) {
+			return fmt.Errorf("adapter: invalid column name %q in WithUpsertOnAdd", col)
+		}
+	}
+	return nil
+}
+
+// conflictClause returns the ON CONFLICT clause of the inserts of rules, or
+// "" if they have none.
+func (a *Adapter) conflictClause() string {
+	target := a.conflictOn()
+	if target == "" {
+		return ""
+	}
+	return target + " DO NOTHING"
+}
+
+// addConflictClause is conflictClause for the inserts of the adds, with
+// DO UPDATE under WithUpsertOnAdd.
+func (a *Adapter) addConflictClause() string {
+	if !a.upsert {
+		return a.conflictClause()
+	}
+	var set []string
+	if a.timestamps {
+		if a.clock != nil {
+			set = append(set, "updated_at = EXCLUDED.updated_at")
+		} else {
+			set = append(set, "updated_at = now()")
+		}
+	}
+	for _, col := range a.upsertCols {
+		set = append(set, col+" = EXCLUDED."+col)
+	}
+	return a.conflictOn() + " DO UPDATE SET " + strings.Join(set, ", ")
+}
+
+// conflictOn returns the ON CONFLICT target of the inserts of rules, or ""
+// if they have none, as on a server without ON CONFLICT, where checkedInserts
+// takes over.
+func (a *Adapter) conflictOn() string {
+	if a.serverBefore(90500) {
+		return ""
+	}
+	switch target := a.conflictTarget; {
+	case target != nil && target.Constraint != "":
+		return " ON CONFLICT ON CONSTRAINT " + target.Constraint
+	case target != nil:
+		return " ON CONFLICT (" + strings.Join(target.Columns, ", ") + ")"
+	case a.ruleHash:
+		return " ON CONFLICT " + a.ruleHashIndex()
+	}
+	return ""
+}
+
+// checkedInserts reports whether the inserts of rules fall back from the
+// ON CONFLICT clause of WithConflictTarget to newLines, on a server before
+// 9.5. Under WithRuleHash, which takes 12, they never do.
+func (a *Adapter) checkedInserts() bool {
+	return a.conflictTarget != nil && a.serverBefore(90500)
+}
+
+// newLines is the fallback of the conflict clause on a server without
+// INSERT ... ON CONFLICT: it reports which of lines, rules of any section for
+// tenant, their table lacks, for db to insert them in the same transaction.
+// As AddPolicyIfNotExists does, it takes the advisory lock of ruleLockKey on
+// a rule before looking for it, held until that transaction ends, so that
+// concurrent inserts of the rule find it stored; it takes them in key order,
+// so that concurrent batches do not deadlock. A rule given twice is new once.
+// The table is searched for the rule itself, whatever the columns of the
+// conflict target, so one that conflicts on fewer columns can still fail the
+// insert.
+func (a *Adapter) newLines(ctx context.Context, db orm.DB, tenant string, lines []CasbinRule) ([]bool, error) {
+	tables := make([]*Adapter, len(lines))
+	keys := make([]int64, len(lines))
+	order := make([]int, len(lines))
+	for i, line := range lines {
+		tables[i] = a.forSection(ptypeSection(line.PType))
+		keys[i] = ruleLockKey(tables[i].table, tenant, line)
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return keys[order[i]] < keys[order[j]] })
+
+	isNew := make([]bool, len(lines))
+	seen := make(map[CasbinRule]bool)
+	for _, i := range order {
+		b, line := tables[i], lines[i]
+		if seen[line] {
+			continue
+		}
+		seen[line] = true
+		if _, err := db.Exec(b.tag(ctx, "SELECT pg_advisory_xact_lock(?)"), keys[i]); err != nil {
+			return nil, err
+		}
+		where, params := b.ruleWhere(line, true)
+		where, params = b.scopeTenant(tenant, where, params...)
+		var stored []CasbinRule
+		if _, err := db.Query(&stored, b.tag(ctx, b.unorderedQuery(where)+" LIMIT 1"), params...); err != nil {
+			return nil, err
+		}
+		isNew[i] = len(stored) == 0
+	}
+	return isNew, nil
+}
+
+// insertNew inserts those of lines, rules of the table of a for tenant, that
+// newLines reports new, in one statement on db, and returns how many it
+// inserted.
+func (a *Adapter) insertNew(ctx context.Context, db orm.DB, tenant string, lines []CasbinRule) (int, error) {
+	isNew, err := a.newLines(ctx, db, tenant, lines)
+	if err != nil {
+		return 0, err
+	}
+	var params []interface{}
+	n := 0
+	for i, line := range lines {
+		if isNew[i] {
+			params = append(params, a.insertParams(line, tenant)...)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	_, err = db.Exec(a.tag(ctx, a.addRowsQuery(n)), params...)
+	return n, err
+}
@@ -0,0 +1,217 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+func TestConflictClause(t *testing.T) {
+	for _, tt := range []struct {
+		opts []Option
+		want string
+	}{
+		{nil, ""},
+		{[]Option{WithRuleHash()}, " ON CONFLICT (rule_hash) DO NOTHING"},
+		{[]Option{WithRuleHash(), WithSoftDelete()}, " ON CONFLICT (rule_hash) WHERE deleted_at IS NULL DO NOTHING"},
+		{[]Option{WithRuleHash(), WithConflictTarget(ConflictTarget{Constraint: "x_policy_rule_key"})}, " ON CONFLICT ON CONSTRAINT x_policy_rule_key DO NOTHING"},
+		{[]Option{WithConflictTarget(ConflictTarget{Columns: []string{"p_type", "v0", "v1"}})}, " ON CONFLICT (p_type, v0, v1) DO NOTHING"},
+	} {
+		if got := NewAdapter("", "", "", "", tt.opts...).conflictClause(); got != tt.want {
+			t.Errorf("conflictClause = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestConflictTargetInvalid(t *testing.T) {
+	for _, target := range []ConflictTarget{
+		{},
+		{Constraint: "x_policy_rule_key", Columns: []string{"v0"}},
+		{Constraint: "x_policy_rule_key DO UPDATE SET v0 = ''"},
+		{Columns: []string{"v0", "lower(v1)"}},
+	} {
+		a := NewAdapter("", "", "", "", WithConflictTarget(target))
+		if err := a.Open(context.Background()); err == nil {
+			a.close()
+			t.Errorf("Open with conflict target %+v: err = nil, want an error", target)
+		}
+	}
+}
+
+func TestConflictTargetConstraint(t *testing.T) {
+	a := newTestAdapter(t, WithConflictTarget(ConflictTarget{Constraint: "x_policy_rule_key"}))
+	a.open()
+	defer a.close()
+	if _, err := a.db.Exec("ALTER TABLE x_policy ADD CONSTRAINT x_policy_rule_key UNIQUE (p_type, v0, v1, v2, v3, v4, v5)"); err != nil {
+		t.Fatalf("ADD CONSTRAINT: %v", err)
+	}
+
+	rule := []string{"alice", "data1", "read"}
+	for i := 0; i < 2; i++ {
+		if err := a.AddPolicy("p", "p", rule); err != nil {
+			t.Fatalf("AddPolicy #%d: %v", i+1, err)
+		}
+	}
+	if err := a.AddPolicy("p", "p", []string{"alice", "data1", "write"}); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+	policies, err := a.GetAllPolicies(context.Background())
+	if err != nil {
+		t.Fatalf("GetAllPolicies: %v", err)
+	}
+	if len(policies["p"]) != 2 {
+		t.Errorf("table holds %q, want the duplicate skipped and the other rule added", policies["p"])
+	}
+}
+
+// oldServer is the ServerInfo of a server without INSERT ... ON CONFLICT.
+var oldServer = ServerInfo{Version: "9.4.26", VersionNum: 90426}
+
+func TestConflictFallbackClause(t *testing.T) {
+	a := NewAdapter("", "", "", "", WithConflictTarget(ConflictTarget{Constraint: "x_policy_rule_key"}))
+	if a.checkedInserts() {
+		t.Errorf("checkedInserts = true before Open, want ON CONFLICT assumed")
+	}
+	a.server = oldServer
+	if !a.checkedInserts() {
+		t.Errorf("checkedInserts = false on %s, want the fallback", a.server.Version)
+	}
+	if got := a.addQuery(); strings.Contains(got, "ON CONFLICT") {
+		t.Errorf("addQuery on %s = %q, want no ON CONFLICT", a.server.Version, got)
+	}
+	if got := NewAdapter("", "", "", "").ifNotExists(); got != "IF NOT EXISTS " {
+		t.Errorf("ifNotExists = %q, want IF NOT EXISTS", got)
+	}
+	if got := a.ifNotExists(); got != "" {
+		t.Errorf("ifNotExists on %s = %q, want none", a.server.Version, got)
+	}
+}
+
+func TestConflictFallback(t *testing.T) {
+	a := newTestAdapter(t, WithConflictTarget(ConflictTarget{Constraint: "x_policy_rule_key"}))
+	a.open()
+	defer a.close()
+	if _, err := a.db.Exec("ALTER TABLE x_policy ADD CONSTRAINT x_policy_rule_key UNIQUE (p_type, v0, v1, v2, v3, v4, v5)"); err != nil {
+		t.Fatalf("ADD CONSTRAINT: %v", err)
+	}
+	// Force the fallback, as Open does on a server before 9.5.
+	a.server = oldServer
+
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	rule := []string{"alice", "data1", "write"}
+	for i := 0; i < 2; i++ {
+		if err := a.AddPolicy("p", "p", rule); err != nil {
+			t.Fatalf("AddPolicy #%d: %v", i+1, err)
+		}
+	}
+	err := a.AddPoliciesMixed(context.Background(), []PolicyRule{
+		{"p", "p", rule},
+		{"p", "p", []string{"carol", "data3", "read"}},
+		{"p", "p", []string{"carol", "data3", "read"}},
+	})
+	if err != nil {
+		t.Fatalf("AddPoliciesMixed: %v", err)
+	}
+
+	policies, err := a.GetAllPolicies(context.Background())
+	if err != nil {
+		t.Fatalf("GetAllPolicies: %v", err)
+	}
+	if len(policies["p"]) != 5 {
+		t.Errorf("table holds %q, want each rule once", policies["p"])
+	}
+}
+
+func TestAddConflictClause(t *testing.T) {
+	a := NewAdapter("", "", "", "", WithRuleHash(), WithTimestamps(), WithUpsertOnAdd("priority"))
+	if got, want := a.addConflictClause(), " ON CONFLICT (rule_hash) DO UPDATE SET updated_at = now(), priority = EXCLUDED.priority"; got != want {
+		t.Errorf("addConflictClause = %q, want %q", got, want)
+	}
+	if got, want := a.conflictClause(), " ON CONFLICT (rule_hash) DO NOTHING"; got != want {
+		t.Errorf("conflictClause = %q, want %q", got, want)
+	}
+}
+
+func TestUpsertOnAddInvalid(t *testing.T) {
+	for _, opts := range [][]Option{
+		{WithUpsertOnAdd("priority")},
+		{WithRuleHash(), WithUpsertOnAdd()},
+		{WithRuleHash(), WithUpsertOnAdd("priority = 0, v0")},
+	} {
+		a := NewAdapter("", "", "", "", opts...)
+		if err := a.Open(context.Background()); err == nil {
+			a.close()
+			t.Errorf("Open with %d options: err = nil, want an error", len(opts))
+		}
+	}
+}
+
+func TestUpsertOnAdd(t *testing.T) {
+	a := newTestAdapter(t, WithTimestamps(), WithUpsertOnAdd("priority"),
+		WithConflictTarget(ConflictTarget{Constraint: "x_policy_rule_key"}))
+	a.open()
+	defer a.close()
+	// A trigger takes the priority of new rows from x_policy_priority, so
+	// that each add can carry a different one.
+	for _, q := range []string{
+		"ALTER TABLE x_policy ADD CONSTRAINT x_policy_rule_key UNIQUE (p_type, v0, v1, v2, v3, v4, v5)",
+		"ALTER TABLE x_policy ADD COLUMN priority INT NOT NULL DEFAULT 0",
+		"DROP TABLE IF EXISTS x_policy_priority",
+		"CREATE TABLE x_policy_priority (priority INT NOT NULL)",
+		"INSERT INTO x_policy_priority VALUES (1)",
+		"CREATE OR REPLACE FUNCTION x_policy_priority() RETURNS trigger LANGUAGE plpgsql AS " +
+			"$$BEGIN SELECT priority INTO NEW.priority FROM x_policy_priority; RETURN NEW; END$$",
+		"CREATE TRIGGER x_policy_priority BEFORE INSERT ON x_policy FOR EACH ROW EXECUTE PROCEDURE x_policy_priority()",
+	} {
+		if _, err := a.db.Exec(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	defer a.db.Exec("DROP TABLE IF EXISTS x_policy_priority; DROP FUNCTION IF EXISTS x_policy_priority() CASCADE")
+
+	rule := []string{"alice", "data1", "read"}
+	if err := a.AddPolicy("p", "p", rule); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+	var created time.Time
+	if _, err := a.db.QueryOne(pg.Scan(&created), "SELECT updated_at FROM x_policy"); err != nil {
+		t.Fatalf("updated_at: %v", err)
+	}
+
+	if _, err := a.db.Exec("UPDATE x_policy_priority SET priority = 5"); err != nil {
+		t.Fatalf("UPDATE x_policy_priority: %v", err)
+	}
+	if err := a.AddPolicy("p", "p", rule); err != nil {
+		t.Fatalf("AddPolicy again: %v", err)
+	}
+	var n, priority int
+	var updated time.Time
+	if _, err := a.db.QueryOne(pg.Scan(&n, &priority, &updated), "SELECT count(*), max(priority), max(updated_at) FROM x_policy"); err != nil {
+		t.Fatalf("priority: %v", err)
+	}
+	if n != 1 || priority != 5 {
+		t.Errorf("%d rows of priority %d after the re-add, want 1 of priority 5", n, priority)
+	}
+	if !updated.After(created) {
+		t.Errorf("updated_at %v not refreshed from %v", updated, created)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CopyTo copies every rule in the table into dst's table, which may be in
+// another database, and returns the number of rules copied. The rows stream
+// from a COPY TO of the source straight into a COPY FROM of the destination,
+// so the policy is never held in memory, and values, NULLs included, arrive
+// exactly as stored. The rules are added to those dst already has. With
+// tenants, the rules of the source tenant of ctx become rules of the
+// destination tenant of ctx. Array columns of the source are copied in
+// their joined form; dst must not have any. A sec column of
+// WithSectionColumn is filled in for dst if the source lacks one.
+func (a *Adapter) CopyTo(ctx context.Context, dst *Adapter) (int64, error) {
+	if dst.readOnly {
+		return 0, ErrReadOnly
+	}
+	if len(dst.arrayCols) > 0 {
+		return 0, fmt.Errorf("adapter: CopyTo into array columns is not supported")
+	}
+	if len(dst.packed) > 0 {
+		return 0, errPacked("CopyTo")
+	}
+	if a.sectionTables != nil || dst.sectionTables != nil {
+		return 0, errSectionTables("CopyTo")
+	}
+	if a.rlsSetting != "" || dst.rlsSetting != "" {
+		return 0, fmt.Errorf("adapter: CopyTo is not supported WithRowLevelSecurity")
+	}
+	a.open()
+	dst.open()
+
+	where, params, err := a.scope(ctx, "")
+	if err != nil {
+		return 0, err
+	}
+	var extra []string
+	cols := append([]string(nil), dst.cols...)
+	switch {
+	case a.secColumn && dst.secColumn:
+		cols = append(cols, "sec")
+	case a.secColumn:
+		return 0, fmt.Errorf("adapter: CopyTo from a sec column requires one in dst")
+	case dst.secColumn:
+		extra = append(extra, "left("+a.ptypeCol()+", 1)")
+		cols = append(cols, "sec")
+	}
+	if dst.tenantFunc != nil {
+		tenant, err := dst.tenant(ctx)
+		if err != nil {
+			return 0, err
+		}
+		extra = append(extra, "?")
+		params = append([]interface{}{tenant}, params...)
+		cols = append(cols, "tenant")
+	}
+	query := a.selectQuery(where, extra...)
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := a.conn(ctx).CopyTo(pw, a.tag(ctx, "COPY ("+query+") TO STDOUT"), params...)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	res, err := dst.conn(ctx).CopyFrom(pr, dst.tag(ctx, "COPY "+dst.table+" ("+strings.Join(cols, ", ")+") FROM STDIN"))
+	pr.CloseWithError(err)
+	if srcErr := <-done; srcErr != nil && err == nil {
+		err = srcErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int64(res.RowsAffected()), nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCopyTo(t *testing.T) {
+	src := newTestAdapter(t)
+	if err := src.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	if err := src.AddPolicy("p", "p", []string{"carol", "", "read"}); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+
+	dst := newTestAdapter(t, WithTablePrefix("copy_"))
+	dst.open()
+	defer dst.db.Exec("DROP TABLE IF EXISTS copy_x_policy")
+	if _, err := dst.db.Exec("TRUNCATE copy_x_policy"); err != nil {
+		t.Fatalf("emptying copy_x_policy: %v", err)
+	}
+
+	n, err := src.CopyTo(context.Background(), dst)
+	if err != nil {
+		t.Fatalf("CopyTo: %v", err)
+	}
+	if n != 5 {
+		t.Errorf("CopyTo copied %d rules, want 5", n)
+	}
+
+	want, err := src.selectRules(context.Background(), "")
+	if err != nil {
+		t.Fatalf("reading source: %v", err)
+	}
+	got, err := dst.selectRules(context.Background(), "")
+	if err != nil {
+		t.Fatalf("reading destination: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("destination holds %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+// createRetryDelay is the wait before the first retry of
+// WithMaxOpenRetriesOnCreateTable, doubled for each retry after it. Tests
+// shorten it.
+var createRetryDelay = 500 * time.Millisecond
+
+// WithMaxOpenRetriesOnCreateTable makes Open and EnsureTable run the DDL
+// that creates the tables, and Open the server version probe before it, up
+// to n more times when they fail with a transient error, for managed
+// databases that turn away the first connections and statements after
+// provisioning while the instance finishes starting up. The errors retried
+// are those of the retry classifier, IsRetryable by default, SQLSTATE 57P03
+// (the database system is starting up) and a refused connection; the wait
+// between attempts starts at half a second and doubles, and ends early when
+// ctx is done. This is independent of WithMaxRetries, which retries
+// queries.
+func WithMaxOpenRetriesOnCreateTable(n int) Option {
+	return func(a *Adapter) {
+		a.createRetries = n
+	}
+}
+
+// createTables runs the DDL of EnsureTable, retrying it under
+// WithMaxOpenRetriesOnCreateTable.
+func (a *Adapter) createTables(ctx context.Context, db orm.DB) error {
+	return a.retryCreate(ctx, func() error {
+		return a.createTableSet(db)
+	})
+}
+
+// openTables probes the server db connects to, checks it with checkServer
+// and, unless the adapter is read-only or WithoutCreateTable is set, runs the
+// DDL of Open, retrying under WithMaxOpenRetriesOnCreateTable: on a server
+// still starting up, the probe is the first statement to be turned away.
+func (a *Adapter) openTables(ctx context.Context, db *pg.DB) error {
+	return a.retryCreate(ctx, func() error {
+		info, err := serverInfo(ctx, db)
+		if err != nil {
+			return err
+		}
+		if err := a.checkServer(info); err != nil {
+			return err
+		}
+		a.server = info
+		if a.readOnly || a.noCreate {
+			return nil
+		}
+		return a.createTableSet(db.WithContext(ctx))
+	})
+}
+
+// createTableSet creates the tables the options call for.
+func (a *Adapter) createTableSet(db orm.DB) error {
+	for _, b := range a.sections() {
+		if err := b.createTable(db); err != nil {
+			return err
+		}
+	}
+	if len(a.packed) > 0 {
+		if err := a.createPackedTable(db); err != nil {
+			return err
+		}
+	}
+	if a.auditActor != nil {
+		if err := a.createAuditTable(db); err != nil {
+			return err
+		}
+	}
+	if a.snapshotOnSave {
+		return a.createHistoryTable(db)
+	}
+	return nil
+}
+
+// retryCreate calls fn until it succeeds, fails with an error that is not
+// transient, has been retried createRetries times or ctx is done.
+func (a *Adapter) retryCreate(ctx context.Context, fn func() error) error {
+	retryable := a.retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+
+	delay := createRetryDelay
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= a.createRetries || !(retryable(err) || startingUp(err) || refused(err)) {
+			return err
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		delay *= 2
+	}
+}
+
+// startingUp reports whether err is the server's refusal of connections
+// while it starts up.
+func startingUp(err error) bool {
+	pgErr, ok := err.(pg.Error)
+	return ok && pgErr.Field('C') == "57P03"
+}
+
+// refused reports whether err is a connection refused, as by a server that
+// is not listening yet.
+func refused(err error) bool {
+	opErr, ok := err.(*net.OpError)
+	if !ok {
+		return false
+	}
+	err = opErr.Err
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	return err == syscall.ECONNREFUSED
+}
@@ -0,0 +1,94 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startupError is a pg.Error with the SQLSTATE of a server starting up.
+type startupError struct{}
+
+func (startupError) Error() string            { return "FATAL #57P03 the database system is starting up" }
+func (startupError) Field(field byte) string  { return map[byte]string{'C': "57P03"}[field] }
+func (startupError) IntegrityViolation() bool { return false }
+
+func TestRetryCreate(t *testing.T) {
+	defer func(d time.Duration) { createRetryDelay = d }(createRetryDelay)
+	createRetryDelay = time.Millisecond
+
+	a := NewAdapter("", "", "", "", WithMaxOpenRetriesOnCreateTable(3))
+	calls := 0
+	err := a.retryCreate(context.Background(), func() error {
+		calls++
+		if calls <= 2 {
+			return startupError{}
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("retryCreate = %v after %d calls, want success after 3", err, calls)
+	}
+
+	dial := &net.OpError{Op: "dial", Net: "tcp", Err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}}
+	calls = 0
+	err = a.retryCreate(context.Background(), func() error {
+		calls++
+		if calls <= 2 {
+			return dial
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("retryCreate = %v after %d calls, want success after 3 with the connection refused", err, calls)
+	}
+
+	fatal := errors.New("permission denied for schema public")
+	calls = 0
+	err = a.retryCreate(context.Background(), func() error {
+		calls++
+		return fatal
+	})
+	if err != fatal || calls != 1 {
+		t.Errorf("retryCreate = %v after %d calls, want fatal error after 1", err, calls)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls = 0
+	err = a.retryCreate(ctx, func() error {
+		calls++
+		return startupError{}
+	})
+	if err != (startupError{}) || calls != 1 {
+		t.Errorf("retryCreate with ctx done = %v after %d calls, want no retries", err, calls)
+	}
+
+	b := NewAdapter("", "", "", "", WithMaxRetries(3))
+	calls = 0
+	err = b.retryCreate(context.Background(), func() error {
+		calls++
+		return startupError{}
+	})
+	if calls != 1 {
+		t.Errorf("retryCreate under WithMaxRetries only = %v after %d calls, want no retries", err, calls)
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// copyBufferSize is the most ImportCSV buffers of the rows it has parsed
+// before they go to the server.
+const copyBufferSize = 64 << 10
+
+// ExportCSV writes every rule in the table to w in casbin's policy file
+// format, one rule per line. Rows are sorted by ptype and values, so exports
+// of the same data are byte-identical.
+func (a *Adapter) ExportCSV(ctx context.Context, w io.Writer) error {
+	a.open()
+
+	lines, err := a.selectRules(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, line := range lines {
+		bw.WriteString(a.lineText(line))
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// ImportCSV adds the rules of r, in casbin's policy file format as
+// ExportCSV writes it, to those the table already has, and returns the
+// number of rules added. Blank lines and lines starting with # are skipped,
+// and the spaces around each value are trimmed. The rules stream from r
+// into a COPY FROM of the table as they are parsed, with no more than a
+// line and 64 KiB of rows held at a time, so an import of any size runs in
+// flat memory; a line longer than 64 KiB fails the import. The COPY is one
+// statement, so an error, a rule the table already holds under a unique
+// index included, leaves the table as it was. Values go through
+// WithValueNormalizer and WithBeforeInsert as for AddPolicy, WithProgress
+// reports the rules parsed, and WithNotify sends an OpReload once the import
+// commits.
+func (a *Adapter) ImportCSV(ctx context.Context, r io.Reader) (int64, error) {
+	if a.readOnly {
+		return 0, ErrReadOnly
+	}
+	if len(a.arrayCols) > 0 {
+		return 0, fmt.Errorf("adapter: ImportCSV into array columns is not supported")
+	}
+	if len(a.packed) > 0 {
+		return 0, errPacked("ImportCSV")
+	}
+	if a.sectionTables != nil {
+		return 0, errSectionTables("ImportCSV")
+	}
+	if a.rlsSetting != "" {
+		return 0, fmt.Errorf("adapter: ImportCSV is not supported WithRowLevelSecurity")
+	}
+	if a.auditActor != nil {
+		return 0, fmt.Errorf("adapter: ImportCSV is not supported WithAudit")
+	}
+	a.open()
+
+	tenant, err := a.tenant(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := a.flushWrites(ctx); err != nil {
+		return 0, err
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := a.copyRules(ctx, pw, r, tenant)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	res, err := a.conn(ctx).CopyFrom(pr, a.tag(ctx, "COPY "+a.table+" ("+strings.Join(a.insertColumns(), ", ")+") FROM STDIN"))
+	pr.CloseWithError(err)
+	if parseErr := <-done; parseErr != nil {
+		err = parseErr
+	}
+	if err != nil {
+		return 0, valueTooLong(err)
+	}
+	n := res.RowsAffected()
+	a.observeRows(OpAdd, n)
+	if n == 0 {
+		return 0, nil
+	}
+	return int64(n), a.notify(a.conn(ctx), Change{Op: OpReload})
+}
+
+// copyRules parses the rules of r and writes them to w as the rows of a
+// COPY FROM in text format, holding a line and the buffer of w at a time.
+func (a *Adapter) copyRules(ctx context.Context, w io.Writer, r io.Reader, tenant string) error {
+	sc := bufio.NewScanner(r)
+	bw := bufio.NewWriterSize(w, copyBufferSize)
+	n := 0
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		ptype, rule, ok := parsePolicyLine(sc.Text())
+		if !ok {
+			continue
+		}
+		if ptype == "" || checkSection(ptype[:1], ptype) != nil {
+			return fmt.Errorf("adapter: line %d: invalid ptype %q", lineNo, ptype)
+		}
+		if len(rule) > len(a.cols)-1 {
+			return fmt.Errorf("adapter: line %d: %d values do not fit in v0 to v5", lineNo, len(rule))
+		}
+		line, _, err := a.hookedLine(ctx, false, ptype, a.normalizeRule(rule))
+		if err != nil {
+			return fmt.Errorf("adapter: line %d: %v", lineNo, err)
+		}
+		for i, v := range a.insertParams(line, tenant) {
+			if i > 0 {
+				bw.WriteByte('\t')
+			}
+			bw.WriteString(copyText(v))
+		}
+		if err := bw.WriteByte('\n'); err != nil {
+			return err
+		}
+		n++
+		a.reportProgress(n, -1)
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("adapter: reading the CSV: %v", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if a.progress != nil && n%a.progressEvery != 0 {
+		a.progress(n, -1)
+	}
+	return nil
+}
+
+// parsePolicyLine splits a line of a policy file into its ptype and rule,
+// reporting false for a blank line or a comment.
+func parsePolicyLine(text string) (ptype string, rule []string, ok bool) {
+	text = strings.TrimSpace(text)
+	if text == "" || strings.HasPrefix(text, "#") {
+		return "", nil, false
+	}
+	tokens := strings.Split(text, ",")
+	for i := range tokens {
+		tokens[i] = strings.TrimSpace(tokens[i])
+	}
+	return tokens[0], tokens[1:], true
+}
+
+// copyEscaper escapes a value for the text format of COPY.
+var copyEscaper = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r")
+
+// copyText returns v, one of insertParams, as a field of the text format
+// of COPY.
+func copyText(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "\\N"
+	case string:
+		return copyEscaper.Replace(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return copyEscaper.Replace(fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportCSVIsStable(t *testing.T) {
+	a := newTestAdapter(t)
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	var first, second bytes.Buffer
+	if err := a.ExportCSV(context.Background(), &first); err != nil {
+		t.Fatalf("ExportCSV: %v", err)
+	}
+	if err := a.ExportCSV(context.Background(), &second); err != nil {
+		t.Fatalf("ExportCSV: %v", err)
+	}
+
+	want := "g, alice, data2_admin\n" +
+		"p, alice, data1, read\n" +
+		"p, bob, data2, write\n" +
+		"p, data2_admin, data2, read\n"
+	if first.String() != want {
+		t.Errorf("ExportCSV =\n%s\nwant\n%s", first.String(), want)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Errorf("two exports differ:\n%s\n%s", first.String(), second.String())
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	var reported []int
+	a := newTestAdapter(t, WithProgress(2, func(n, total int) {
+		if total != -1 {
+			t.Errorf("progress total %d, want -1", total)
+		}
+		reported = append(reported, n)
+	}))
+	in := "# exported policy\n" +
+		"p, alice, data1, read\n" +
+		"\n" +
+		"p,bob ,data2,write\n" +
+		"p, carol, c:\\share\\x, read\n" +
+		"g, alice, data2_admin\n"
+	n, err := a.ImportCSV(context.Background(), strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ImportCSV: %v", err)
+	}
+	if n != 4 {
+		t.Errorf("ImportCSV added %d rules, want 4", n)
+	}
+	if want := []int{2, 4}; !reflect.DeepEqual(reported, want) {
+		t.Errorf("progress reported %v, want %v", reported, want)
+	}
+
+	var out bytes.Buffer
+	if err := a.ExportCSV(context.Background(), &out); err != nil {
+		t.Fatalf("ExportCSV: %v", err)
+	}
+	want := "g, alice, data2_admin\n" +
+		"p, alice, data1, read\n" +
+		"p, bob, data2, write\n" +
+		"p, carol, c:\\share\\x, read\n"
+	if out.String() != want {
+		t.Errorf("exported after the import\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestImportCSVInvalidLine(t *testing.T) {
+	a := newTestAdapter(t)
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	for _, in := range []string{
+		"p, dave, data3, read\np, a, b, c, d, e, f, g\n",
+		"p, dave, data3, read\nx, dave, data3\n",
+	} {
+		if _, err := a.ImportCSV(context.Background(), strings.NewReader(in)); err == nil {
+			t.Errorf("ImportCSV of %q succeeded", in)
+		}
+	}
+	policies, err := a.GetAllPolicies(context.Background())
+	if err != nil {
+		t.Fatalf("GetAllPolicies: %v", err)
+	}
+	if len(policies["p"]) != 3 {
+		t.Errorf("%d p rules after the failed imports, want the 3 saved", len(policies["p"]))
+	}
+}
+
+func TestCopyText(t *testing.T) {
+	for v, want := range map[interface{}]string{
+		nil:          `\N`,
+		"alice":      "alice",
+		"a\tb\nc\\d": `a\tb\nc\\d`,
+	} {
+		if got := copyText(v); got != want {
+			t.Errorf("copyText(%q) = %q, want %q", v, got, want)
+		}
+	}
+}
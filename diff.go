@@ -0,0 +1,86 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+
+	"github.com/casbin/casbin/model"
+)
+
+// Diff compares the table with the rules of m and returns the rules that
+// saving m would add and those it would remove, each as the ptype followed
+// by the values, e.g. ["p", "alice", "data1", "read"]. Added rules follow
+// the order of m, sorted by ptype; removed rules follow the table order.
+func (a *Adapter) Diff(ctx context.Context, m model.Model) (added, removed [][]string, err error) {
+	a.open()
+
+	stored, err := a.selectRules(ctx, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	addedLines, removedLines := diffLines(stored, a.modelLines(m))
+	for _, line := range addedLines {
+		added = append(added, append([]string{line.PType}, a.lineRule(line)...))
+	}
+	for _, line := range removedLines {
+		removed = append(removed, append([]string{line.PType}, a.lineRule(line)...))
+	}
+	return added, removed, nil
+}
+
+// modelLines returns the rules of m as rows, ptype by ptype in sorted order.
+func (a *Adapter) modelLines(m model.Model) []CasbinRule {
+	var lines []CasbinRule
+	for _, ptype := range PTypes(m) {
+		sec := ptype[:1]
+		for _, rule := range m[sec][ptype].Policy {
+			lines = append(lines, savePolicyLine(ptype, a.placeValues(rule)))
+		}
+	}
+	return lines
+}
+
+// diffLines returns the rows of want missing from have and the rows of have
+// missing from want. Duplicate rows count once, and rows are compared by
+// ptype and values only, whatever section column they were read with.
+func diffLines(have, want []CasbinRule) (added, removed []CasbinRule) {
+	key := func(line CasbinRule) CasbinRule {
+		line.Sec = ""
+		return line
+	}
+
+	inHave := make(map[CasbinRule]bool, len(have))
+	for _, line := range have {
+		inHave[key(line)] = true
+	}
+	inWant := make(map[CasbinRule]bool, len(want))
+	for _, line := range want {
+		if !inHave[key(line)] && !inWant[key(line)] {
+			added = append(added, line)
+		}
+		inWant[key(line)] = true
+	}
+
+	seen := make(map[CasbinRule]bool)
+	for _, line := range have {
+		if !inWant[key(line)] && !seen[key(line)] {
+			removed = append(removed, line)
+		}
+		seen[key(line)] = true
+	}
+	return added, removed
+}
@@ -0,0 +1,51 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a := newTestAdapter(t)
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	m := newTestModel()
+	m.RemovePolicy("p", "p", []string{"bob", "data2", "write"})
+	m.AddPolicy("p", "p", []string{"carol", "data3", "read"})
+
+	added, removed, err := a.Diff(context.Background(), m)
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	if want := [][]string{{"p", "carol", "data3", "read"}}; !reflect.DeepEqual(added, want) {
+		t.Errorf("added = %q, want %q", added, want)
+	}
+	if want := [][]string{{"p", "bob", "data2", "write"}}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed = %q, want %q", removed, want)
+	}
+
+	added, removed, err = a.Diff(context.Background(), newTestModel())
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	if len(added) != 0 || len(removed) != 0 {
+		t.Errorf("Diff of the saved model = %q, %q, want none", added, removed)
+	}
+}
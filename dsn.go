@@ -0,0 +1,188 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// NewAdapterFromDSN is the constructor for Adapter from a libpq keyword/value
+// connection string such as "host=db user=casbin password=secret dbname=casbin sslmode=disable".
+func NewAdapterFromDSN(dsn string, opts ...Option) (*Adapter, error) {
+	options, err := parseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return newAdapter(*options, opts...), nil
+}
+
+// parseDSN converts a keyword/value DSN into pg.Options. Unknown keywords are
+// rejected rather than ignored so that typos do not silently change behavior.
+func parseDSN(dsn string) (*pg.Options, error) {
+	params, err := splitDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	host, port := "localhost", "5432"
+	options := &pg.Options{User: "postgres"}
+	sslMode := "prefer"
+
+	for _, kv := range params {
+		switch kv[0] {
+		case "host":
+			host = strings.TrimSuffix(strings.TrimPrefix(kv[1], "["), "]")
+		case "port":
+			port = kv[1]
+		case "user":
+			options.User = kv[1]
+		case "password":
+			options.Password = kv[1]
+		case "dbname":
+			options.Database = kv[1]
+		case "sslmode":
+			sslMode = kv[1]
+		case "application_name":
+			options.ApplicationName = kv[1]
+		case "connect_timeout":
+			secs, err := strconv.Atoi(kv[1])
+			if err != nil || secs < 0 {
+				return nil, fmt.Errorf("adapter: invalid connect_timeout %q in DSN", kv[1])
+			}
+			options.DialTimeout = time.Duration(secs) * time.Second
+		default:
+			return nil, fmt.Errorf("adapter: unknown DSN keyword %q", kv[0])
+		}
+	}
+
+	if options.Database == "" {
+		return nil, fmt.Errorf("adapter: dbname not provided in DSN")
+	}
+
+	if strings.HasPrefix(host, "/") {
+		options.Network = "unix"
+		options.Addr = host + "/.s.PGSQL." + port
+	} else {
+		options.Addr = net.JoinHostPort(host, port)
+	}
+
+	switch sslMode {
+	case "disable":
+		options.TLSConfig = nil
+	case "allow", "prefer", "require":
+		options.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	case "verify-full":
+		options.TLSConfig = &tls.Config{ServerName: host}
+	default:
+		return nil, fmt.Errorf("adapter: sslmode %q is not supported", sslMode)
+	}
+
+	return options, nil
+}
+
+// normalizeAddr returns addr as host:port for go-pg. It accepts host:port,
+// a bare host, for which the port defaults to 5432, and IPv6 addresses with
+// or without brackets, which are bracketed. An address it cannot make sense
+// of is returned as is for the dial to report.
+func normalizeAddr(addr string) string {
+	if addr == "" {
+		return ""
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"), ""
+		if strings.Contains(host, ":") && net.ParseIP(host) == nil {
+			return addr
+		}
+	}
+	if port == "" {
+		port = "5432"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// checkAddr rejects an addr given as a URL such as postgres://db:5432,
+// which go-pg would otherwise fail to dial with a cryptic error. The URL is
+// left out of the error, since it may hold a password.
+func checkAddr(addr string) error {
+	i := strings.Index(addr, "://")
+	if i < 0 {
+		return nil
+	}
+	hint := "host:port"
+	if u, err := url.Parse(addr); err == nil && u.Host != "" {
+		hint = strconv.Quote(u.Host)
+	}
+	return fmt.Errorf("adapter: addr is a %s:// URL; pass %s instead, or a keyword/value connection string to NewAdapterFromDSN", addr[:i], hint)
+}
+
+// splitDSN splits a DSN into keyword/value pairs. Values may be single-quoted,
+// in which case \' and \\ are unescaped, following libpq.
+func splitDSN(dsn string) ([][2]string, error) {
+	var params [][2]string
+	s := strings.TrimSpace(dsn)
+
+	for s != "" {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("adapter: missing \"=\" after %q in DSN", s)
+		}
+		key := strings.TrimSpace(s[:eq])
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("adapter: malformed DSN keyword %q", key)
+		}
+		s = strings.TrimLeft(s[eq+1:], " \t")
+
+		var value strings.Builder
+		if strings.HasPrefix(s, "'") {
+			i, closed := 1, false
+			for ; i < len(s); i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+					value.WriteByte(s[i])
+				} else if s[i] == '\'' {
+					closed = true
+					break
+				} else {
+					value.WriteByte(s[i])
+				}
+			}
+			if !closed {
+				return nil, fmt.Errorf("adapter: unterminated quoted value for %q in DSN", key)
+			}
+			s = s[i+1:]
+		} else {
+			end := strings.IndexAny(s, " \t")
+			if end < 0 {
+				end = len(s)
+			}
+			value.WriteString(s[:end])
+			s = s[end:]
+		}
+
+		params = append(params, [2]string{key, value.String()})
+		s = strings.TrimLeft(s, " \t")
+	}
+
+	return params, nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"testing"
+
+	"github.com/casbin/casbin"
+)
+
+const (
+	domainsModel  = "testdata/rbac_with_domains_model.conf"
+	domainsPolicy = "testdata/rbac_with_domains_policy.csv"
+)
+
+// testEnforcerAgrees fails t for every request on which e and a fresh
+// enforcer loaded from a decide differently.
+func testEnforcerAgrees(t *testing.T, step string, e *casbin.Enforcer, a *Adapter) {
+	t.Helper()
+	reloaded := casbin.NewEnforcer(domainsModel, a)
+	for _, sub := range []string{"alice", "bob", "carol"} {
+		for _, dom := range []string{"domain1", "domain2"} {
+			for _, obj := range []string{"data1", "data2", "data3"} {
+				for _, act := range []string{"read", "write"} {
+					if got, want := reloaded.Enforce(sub, dom, obj, act), e.Enforce(sub, dom, obj, act); got != want {
+						t.Errorf("%s: reloaded Enforce(%s, %s, %s, %s) = %v, want %v", step, sub, dom, obj, act, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestEnforcerRBACWithDomains(t *testing.T) {
+	a := newTestAdapter(t)
+	e := casbin.NewEnforcer(domainsModel, domainsPolicy)
+	e.SetAdapter(a)
+	if err := e.SavePolicy(); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	testEnforcerAgrees(t, "save", e, a)
+
+	e.AddPolicy("admin", "domain1", "data3", "read")
+	e.AddGroupingPolicy("carol", "admin", "domain2")
+	if !e.Enforce("alice", "domain1", "data3", "read") || !e.Enforce("carol", "domain2", "data2", "write") {
+		t.Fatal("added rules do not take effect")
+	}
+	testEnforcerAgrees(t, "add", e, a)
+
+	e.RemovePolicy("admin", "domain1", "data1", "write")
+	if e.Enforce("alice", "domain1", "data1", "write") {
+		t.Fatal("removed rule still takes effect")
+	}
+	testEnforcerAgrees(t, "remove", e, a)
+
+	e.RemoveFilteredPolicy(1, "domain2")
+	if e.Enforce("bob", "domain2", "data2", "read") {
+		t.Fatal("filtered-out rules still take effect")
+	}
+	testEnforcerAgrees(t, "remove filtered", e, a)
+}
@@ -1,6 +1,8 @@
 module github.com/CarbonFactory/casbin-postgres-adapter
 
+go 1.13
+
 require (
 	github.com/casbin/casbin v1.8.0
-	github.com/lib/pq v1.0.0
+	github.com/go-pg/pg/v10 v10.11.1
 )
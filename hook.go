@@ -0,0 +1,109 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// RuleHook is a function the adapter calls with a row it is about to write,
+// which it may change or reject by returning an error.
+type RuleHook func(ctx context.Context, r *CasbinRule) error
+
+// WithBeforeInsert makes the adapter call hook with each row before
+// inserting it, from AddPolicy and the other adds, SavePolicy and
+// RestoreSnapshot, for validation or for filling in values derived from the
+// others. The adapter runs it through the go-pg model hook of CasbinRule,
+// BeforeInsert, which go-pg also runs on CasbinRule models inserted with a
+// context from HookContext. The row is written, and recorded by WithAudit
+// and the watcher, as hook leaves its values; changes to PType and Sec are
+// ignored. An error from hook fails the write, and for SavePolicy the whole
+// save. Unlike WithValueNormalizer, hook does not change the values that
+// removes and updates match rows against.
+func WithBeforeInsert(hook RuleHook) Option {
+	return func(a *Adapter) {
+		a.beforeInsert = hook
+	}
+}
+
+// WithBeforeUpdate is WithBeforeInsert for the new row of UpdatePolicy, run
+// through BeforeUpdate.
+func WithBeforeUpdate(hook RuleHook) Option {
+	return func(a *Adapter) {
+		a.beforeUpdate = hook
+	}
+}
+
+var (
+	_ pg.BeforeInsertHook = (*CasbinRule)(nil)
+	_ pg.BeforeUpdateHook = (*CasbinRule)(nil)
+)
+
+// ruleHooksKey is the context key of the hooks of HookContext.
+type ruleHooksKey struct{}
+
+// ruleHooks are the hooks of WithBeforeInsert and WithBeforeUpdate.
+type ruleHooks struct {
+	beforeInsert RuleHook
+	beforeUpdate RuleHook
+}
+
+// HookContext returns ctx carrying the hooks of WithBeforeInsert and
+// WithBeforeUpdate, for the go-pg model hooks of CasbinRule to run on
+// queries made outside the adapter.
+func (a *Adapter) HookContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ruleHooksKey{}, ruleHooks{a.beforeInsert, a.beforeUpdate})
+}
+
+// BeforeInsert implements pg.BeforeInsertHook, calling the hook of
+// WithBeforeInsert that ctx carries from HookContext, if any, with r.
+func (r *CasbinRule) BeforeInsert(ctx context.Context) (context.Context, error) {
+	if hooks, ok := ctx.Value(ruleHooksKey{}).(ruleHooks); ok && hooks.beforeInsert != nil {
+		return ctx, hooks.beforeInsert(ctx, r)
+	}
+	return ctx, nil
+}
+
+// BeforeUpdate implements pg.BeforeUpdateHook, calling the hook of
+// WithBeforeUpdate that ctx carries from HookContext, if any, with r.
+func (r *CasbinRule) BeforeUpdate(ctx context.Context) (context.Context, error) {
+	if hooks, ok := ctx.Value(ruleHooksKey{}).(ruleHooks); ok && hooks.beforeUpdate != nil {
+		return ctx, hooks.beforeUpdate(ctx, r)
+	}
+	return ctx, nil
+}
+
+// hookedLine returns the row of rule under ptype, with the placeholder of
+// WithValuePlaceholder, as the model hook BeforeInsert, or BeforeUpdate if
+// update is set, leaves it, along with the rule it now holds. Without a
+// hook of WithBeforeInsert or WithBeforeUpdate to run, rule is returned
+// unchanged.
+func (a *Adapter) hookedLine(ctx context.Context, update bool, ptype string, rule []string) (CasbinRule, []string, error) {
+	line := savePolicyLine(ptype, a.placeValues(rule))
+	hook, run := a.beforeInsert, (*CasbinRule).BeforeInsert
+	if update {
+		hook, run = a.beforeUpdate, (*CasbinRule).BeforeUpdate
+	}
+	if hook == nil {
+		return line, rule, nil
+	}
+	if _, err := run(&line, a.HookContext(ctx)); err != nil {
+		return CasbinRule{}, nil, err
+	}
+	line.PType, line.Sec = ptype, ""
+	return line, a.lineRule(line), nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+)
+
+func TestBeforeInsertHook(t *testing.T) {
+	forbidden := errors.New("forbidden resource")
+	a := newTestAdapter(t, WithBeforeInsert(func(ctx context.Context, r *CasbinRule) error {
+		if r.V1 == "secrets" {
+			return forbidden
+		}
+		r.V0 = strings.ToLower(r.V0)
+		r.PType = "x"
+		return nil
+	}), WithBeforeUpdate(func(ctx context.Context, r *CasbinRule) error {
+		r.V2 = strings.ToUpper(r.V2)
+		return nil
+	}))
+
+	m := newTestModel()
+	m.AddPolicy("p", "p", []string{"Carol", "data3", "read"})
+	if err := a.SavePolicy(m); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	if err := a.AddPolicy("p", "p", []string{"DAVE", "data4", "read"}); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+	if err := a.AddPolicy("p", "p", []string{"eve", "secrets", "read"}); err != forbidden {
+		t.Errorf("AddPolicy rejected by the hook: err = %v, want %v", err, forbidden)
+	}
+	if err := a.UpdatePolicy("p", "p", []string{"bob", "data2", "write"}, []string{"bob", "data2", "read"}); err != nil {
+		t.Fatalf("UpdatePolicy: %v", err)
+	}
+
+	policies, err := a.GetAllPolicies(context.Background())
+	if err != nil {
+		t.Fatalf("GetAllPolicies: %v", err)
+	}
+	want := map[string][][]string{
+		"p": {
+			{"alice", "data1", "read"},
+			{"bob", "data2", "READ"},
+			{"carol", "data3", "read"},
+			{"data2_admin", "data2", "read"},
+			{"dave", "data4", "read"},
+		},
+		"g": {{"alice", "data2_admin"}},
+	}
+	if !reflect.DeepEqual(policies, want) {
+		t.Errorf("table holds %q, want %q", policies, want)
+	}
+
+	m.AddPolicy("p", "p", []string{"eve", "secrets", "read"})
+	if err := a.SavePolicy(m); err != forbidden {
+		t.Errorf("SavePolicy of a rule the hook rejects: err = %v, want %v", err, forbidden)
+	}
+	if policies, err := a.GetAllPolicies(context.Background()); err != nil || len(policies["p"]) != 5 {
+		t.Errorf("table holds %q after the rejected save, want it unchanged", policies["p"])
+	}
+}
+
+func TestCasbinRuleModelHooks(t *testing.T) {
+	a := NewAdapter("", "", "", "", WithBeforeInsert(func(ctx context.Context, r *CasbinRule) error {
+		r.V0 = strings.ToLower(r.V0)
+		return nil
+	}), WithBeforeUpdate(func(ctx context.Context, r *CasbinRule) error {
+		r.V2 = strings.ToUpper(r.V2)
+		return nil
+	}))
+
+	var hook pg.BeforeInsertHook = &CasbinRule{PType: "p", V0: "Carol", V1: "data3", V2: "read"}
+	if _, err := hook.BeforeInsert(context.Background()); err != nil {
+		t.Fatalf("BeforeInsert: %v", err)
+	}
+	if r := hook.(*CasbinRule); r.V0 != "Carol" {
+		t.Errorf("BeforeInsert without HookContext left V0 %q, want it unchanged", r.V0)
+	}
+	if _, err := hook.BeforeInsert(a.HookContext(context.Background())); err != nil {
+		t.Fatalf("BeforeInsert: %v", err)
+	}
+	if r := hook.(*CasbinRule); r.V0 != "carol" || r.V2 != "read" {
+		t.Errorf("BeforeInsert left %+v, want V0 lowered by the insert hook alone", *r)
+	}
+
+	r := CasbinRule{PType: "p", V0: "Carol", V1: "data3", V2: "read"}
+	if _, err := r.BeforeUpdate(a.HookContext(context.Background())); err != nil {
+		t.Fatalf("BeforeUpdate: %v", err)
+	}
+	if r.V0 != "Carol" || r.V2 != "READ" {
+		t.Errorf("BeforeUpdate left %+v, want V2 raised by the update hook alone", r)
+	}
+}
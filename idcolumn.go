@@ -0,0 +1,65 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"regexp"
+
+	"github.com/go-pg/pg/v10/orm"
+)
+
+// sequenceRe matches the name of WithIDSequence: an identifier, optionally
+// qualified by a schema.
+var sequenceRe = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]{0,62}\.)?[A-Za-z_][A-Za-z0-9_]{0,62}$`)
+
+// WithIDColumn gives the table an id BIGINT primary key numbering the rules
+// as they are added, for AddPolicyReturningID and WithLoadPageSize. On
+// PostgreSQL 10 and later the column is GENERATED ALWAYS AS IDENTITY, whose
+// sequence belongs to the column and needs no name of its own; on 9.6 it is
+// a BIGSERIAL. Open adds the column to an existing table, numbering the
+// rows already there.
+func WithIDColumn() Option {
+	return func(a *Adapter) {
+		a.idColumn = true
+	}
+}
+
+// WithIDSequence is WithIDColumn drawing the ids from the sequence name,
+// such as "policy.casbin_rule_ids", instead of one the server names after
+// the table, for databases whose naming conventions would make those
+// collide. Open creates the sequence if it does not exist. The sequence is
+// not owned by the table, so it may live in another schema, and it outlives
+// the table: ids keep increasing across SaveModeDrop and ArchiveTable. Open
+// fails if name is not an optionally schema-qualified SQL identifier.
+func WithIDSequence(name string) Option {
+	return func(a *Adapter) {
+		a.idColumn = true
+		a.idSequence = name
+	}
+}
+
+// createIDColumn adds the id column to the table if it lacks it.
+func (a *Adapter) createIDColumn(db orm.DB) error {
+	def := "BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY"
+	if a.idSequence != "" {
+		if err := ddl(db, "CREATE SEQUENCE IF NOT EXISTS "+a.idSequence); err != nil {
+			return err
+		}
+		def = "BIGINT NOT NULL DEFAULT nextval('" + a.idSequence + "') PRIMARY KEY"
+	} else if a.serverBefore(100000) {
+		def = "BIGSERIAL PRIMARY KEY"
+	}
+	return ddl(db, "ALTER TABLE "+a.table+" ADD COLUMN IF NOT EXISTS id "+def)
+}
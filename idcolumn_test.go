@@ -0,0 +1,105 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+)
+
+func TestIDSequenceInvalid(t *testing.T) {
+	for _, name := range []string{"a.b.c", "seq; DROP TABLE x_policy", "1seq"} {
+		a := NewAdapter("", "", "", "", WithIDSequence(name))
+		if err := a.Open(context.Background()); err == nil {
+			a.close()
+			t.Errorf("Open with sequence %q: err = nil, want an error", name)
+		}
+	}
+}
+
+// addReturningIDs adds two rules to a and returns their ids.
+func addReturningIDs(t *testing.T, a *Adapter) (int64, int64) {
+	t.Helper()
+	first, err := a.AddPolicyReturningID(context.Background(), "p", []string{"alice", "data1", "read"})
+	if err != nil {
+		t.Fatalf("AddPolicyReturningID: %v", err)
+	}
+	second, err := a.AddPolicyReturningID(context.Background(), "p", []string{"bob", "data2", "write"})
+	if err != nil {
+		t.Fatalf("AddPolicyReturningID: %v", err)
+	}
+	if second <= first {
+		t.Errorf("ids %d then %d, want them increasing", first, second)
+	}
+	return first, second
+}
+
+func TestIDColumn(t *testing.T) {
+	a := newTestAdapter(t, WithIDColumn())
+	a.open()
+	defer a.close()
+	addReturningIDs(t, a)
+
+	var num int
+	if _, err := a.db.QueryOne(pg.Scan(&num), "SELECT current_setting('server_version_num')::int"); err != nil {
+		t.Fatalf("server_version_num: %v", err)
+	}
+	var identity, def string
+	_, err := a.db.QueryOne(pg.Scan(&identity, &def),
+		"SELECT coalesce(is_identity, 'NO'), coalesce(column_default, '') FROM information_schema.columns "+
+			"WHERE table_schema = current_schema() AND table_name = 'x_policy' AND column_name = 'id'")
+	if err != nil {
+		t.Fatalf("id column: %v", err)
+	}
+	if num >= 100000 && identity != "YES" {
+		t.Errorf("id is_identity = %q on server %d, want an identity column", identity, num)
+	}
+	if num < 100000 && !strings.HasPrefix(def, "nextval(") {
+		t.Errorf("id default = %q on server %d, want a serial", def, num)
+	}
+	if err := a.CheckSchema(context.Background()); err != nil {
+		t.Errorf("CheckSchema: %v", err)
+	}
+}
+
+func TestIDSequence(t *testing.T) {
+	a := newTestAdapter(t, WithIDSequence("public.casbin_policy_ids"))
+	a.open()
+	defer a.close()
+	defer a.db.Exec("DROP SEQUENCE IF EXISTS public.casbin_policy_ids")
+	_, second := addReturningIDs(t, a)
+
+	var last int64
+	if _, err := a.db.QueryOne(pg.Scan(&last), "SELECT last_value FROM public.casbin_policy_ids"); err != nil {
+		t.Fatalf("sequence: %v", err)
+	}
+	if last != second {
+		t.Errorf("sequence last_value = %d, want the last id %d", last, second)
+	}
+
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	id, err := a.AddPolicyReturningID(context.Background(), "p", []string{"carol", "data3", "read"})
+	if err != nil {
+		t.Fatalf("AddPolicyReturningID: %v", err)
+	}
+	if id <= second+4 {
+		t.Errorf("id after saving 4 rules = %d, want past %d", id, second+4)
+	}
+}
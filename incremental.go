@@ -0,0 +1,241 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/casbin/casbin/model"
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+// changedRow is a row read by LoadIncremental, with its timestamps.
+type changedRow struct {
+	CasbinRule
+	UpdatedAt time.Time
+	DeletedAt time.Time
+}
+
+// defaultIncrementalOverlap is the overlap of WithIncrementalOverlap unless
+// it is given.
+const defaultIncrementalOverlap = time.Minute
+
+// WithIncrementalOverlap makes LoadIncremental read the changes stamped up to
+// d before since as well, a minute unless set. A row is stamped when its
+// transaction starts, so a change whose transaction started before the mark
+// a call returned but committed after that call's read carries a time
+// earlier than the mark; without the overlap, every later call skips it.
+// Changes seen twice are applied twice, which leaves m as applying them once
+// does. A d of 0 turns the overlap off.
+func WithIncrementalOverlap(d time.Duration) Option {
+	return func(a *Adapter) {
+		if d < 0 {
+			d = 0
+		}
+		a.incrementalOverlap = d
+	}
+}
+
+// LoadIncremental merges into m the rules added or updated since the given
+// time and, with WithSoftDelete, removes those tombstoned since then. It
+// returns the time of the latest change seen, to pass as since on the next
+// call, or since itself if nothing changed. It requires WithTimestamps.
+//
+// Only the current value of a rule is seen, so the rule an UpdatePolicy
+// replaced stays in m, and changes the adapter does not timestamp, such as
+// those of SavePolicy without soft delete, are missed; reload fully after
+// those. Changes are stamped with the start of their transaction, or under
+// WithClock with the writer's clock, not in commit order: the window of
+// WithIncrementalOverlap covers changes that commit late, but a change
+// committing more than that after it was stamped is missed.
+func (a *Adapter) LoadIncremental(ctx context.Context, m model.Model, since time.Time) (time.Time, error) {
+	if !a.timestamps {
+		return since, fmt.Errorf("adapter: LoadIncremental requires WithTimestamps")
+	}
+	if len(a.packed) > 0 {
+		return since, errPacked("LoadIncremental")
+	}
+	a.open()
+	if a.sectionTables != nil {
+		mark := since
+		for _, b := range a.sections() {
+			next, err := b.LoadIncremental(ctx, m, since)
+			if err != nil {
+				return since, err
+			}
+			if next.After(mark) {
+				mark = next
+			}
+		}
+		return mark, nil
+	}
+
+	from := since
+	if !since.IsZero() {
+		from = since.Add(-a.incrementalOverlap)
+	}
+	list := append(a.selectList(), "updated_at")
+	where := "updated_at > ?"
+	params := []interface{}{from}
+	if a.softDelete {
+		list = append(list, "deleted_at")
+		where = "(updated_at > ? OR deleted_at > ?)"
+		params = append(params, from)
+	}
+	where, params = a.filterLoad(where, params...)
+	where, params, err := a.scope(ctx, where, params...)
+	if err != nil {
+		return since, err
+	}
+
+	var rows []changedRow
+	err = a.withTenantSetting(ctx, func(db orm.DB) error {
+		rows = nil
+		_, err := db.Query(&rows, a.tag(ctx, "SELECT "+strings.Join(list, ", ")+" FROM "+a.reader().table+" WHERE "+where), params...)
+		return err
+	})
+	if err != nil {
+		return since, err
+	}
+
+	lines := make([]CasbinRule, len(rows))
+	for i, row := range rows {
+		lines[i] = row.CasbinRule
+	}
+	if err := a.checkPTypes(m, lines); err != nil {
+		return since, err
+	}
+
+	mark := since
+	// Tombstones go first: a rule removed and added again is live.
+	for _, row := range rows {
+		if row.DeletedAt.IsZero() {
+			continue
+		}
+		if !a.skipPType(m, row.CasbinRule) {
+			m.RemovePolicy(row.PType[:1], row.PType, a.lineRule(row.CasbinRule))
+		}
+		if row.DeletedAt.After(mark) {
+			mark = row.DeletedAt
+		}
+	}
+	for _, row := range rows {
+		if row.DeletedAt.IsZero() && !a.skipPType(m, row.CasbinRule) {
+			m.AddPolicy(row.PType[:1], row.PType, a.lineRule(row.CasbinRule))
+		}
+		if row.UpdatedAt.After(mark) {
+			mark = row.UpdatedAt
+		}
+	}
+	a.observeRows(OpLoad, len(rows))
+	return mark, nil
+}
+
+// RuleRef identifies a rule for LoadChanges: by ID, its value in the id
+// column of WithIDColumn, if ID is set, or else by PType and Rule.
+type RuleRef struct {
+	ID    int64
+	PType string
+	Rule  []string
+}
+
+// RuleRefs returns the rules c reports as references for LoadChanges, and
+// false for the changes that can only be followed by a full reload: those
+// of SavePolicy, RemoveFilteredPolicy and OpReload, or payloads of an
+// operation it does not know.
+func (c Change) RuleRefs() ([]RuleRef, bool) {
+	switch c.Op {
+	case OpAdd, OpRemove, OpUpdate:
+	default:
+		return nil, false
+	}
+	refs := make([]RuleRef, 0, len(c.Rules))
+	for _, rule := range c.Rules {
+		if len(rule) == 0 {
+			return nil, false
+		}
+		refs = append(refs, RuleRef{PType: rule[0], Rule: rule[1:]})
+	}
+	return refs, true
+}
+
+// LoadChanges brings the rules refs identify up to date in m, reading only
+// those rows, in a single query, rather than the whole policy, for replicas
+// syncing on the Changes of WithNotify through RuleRefs. A rule found in the
+// table is added to m if m lacks it; a rule referenced by ptype and values
+// that the table no longer holds is removed from m. A reference by ID can
+// only bring a row in: the rule the row held before, if it changed, is not
+// known, and a removed row leaves nothing to find. m is left alone if the
+// read fails.
+func (a *Adapter) LoadChanges(ctx context.Context, m model.Model, refs []RuleRef) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	a.open()
+
+	var ids []int64
+	var conds []string
+	var params []interface{}
+	var byValue []CasbinRule
+	for _, ref := range refs {
+		if ref.ID != 0 {
+			ids = append(ids, ref.ID)
+			continue
+		}
+		rule := append([]string(nil), a.normalizeRule(ref.Rule)...)
+		where, whereParams := a.exactWhere(ref.PType, rule)
+		conds = append(conds, "("+where+")")
+		params = append(params, whereParams...)
+		for i := range rule {
+			rule[i] = a.matchValue(i, rule[i])
+		}
+		if len(rule) <= 6 {
+			byValue = append(byValue, savePolicyLine(ref.PType, a.placeValues(rule)))
+		}
+	}
+	if len(ids) > 0 {
+		conds = append(conds, "id IN (?)")
+		params = append(params, pg.In(ids))
+	}
+
+	lines, err := a.selectRules(ctx, "("+strings.Join(conds, " OR ")+")", params...)
+	if err != nil {
+		return err
+	}
+	if err := a.checkPTypes(m, lines); err != nil {
+		return err
+	}
+
+	found := make(map[string]bool, len(lines))
+	for _, line := range lines {
+		found[policyLineText(line)] = true
+	}
+	for _, line := range byValue {
+		if !found[policyLineText(line)] && knownPType(m, line) {
+			m.RemovePolicy(line.PType[:1], line.PType, a.lineRule(line))
+		}
+	}
+	for _, line := range lines {
+		if !a.skipPType(m, line) {
+			m.AddPolicy(line.PType[:1], line.PType, a.lineRule(line))
+		}
+	}
+	a.observeRows(OpLoad, len(lines))
+	return nil
+}
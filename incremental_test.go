@@ -0,0 +1,194 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadIncremental(t *testing.T) {
+	// Without the overlap, rules loaded before the mark are never read again.
+	a := newTestAdapter(t, WithTimestamps(), WithSoftDelete(), WithIncrementalOverlap(0))
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	m := newTestModel()
+	mark, err := a.LoadIncremental(context.Background(), m, time.Time{})
+	if err != nil {
+		t.Fatalf("LoadIncremental: %v", err)
+	}
+	if mark.IsZero() {
+		t.Fatalf("LoadIncremental returned no high-water mark")
+	}
+
+	if err := a.AddPolicy("p", "p", []string{"carol", "data3", "read"}); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+	if err := a.RemovePolicy("p", "p", []string{"bob", "data2", "write"}); err != nil {
+		t.Fatalf("RemovePolicy: %v", err)
+	}
+
+	// Rules loaded before the mark are not loaded again: drop one from m and
+	// it stays dropped.
+	m.RemovePolicy("p", "p", []string{"alice", "data1", "read"})
+	next, err := a.LoadIncremental(context.Background(), m, mark)
+	if err != nil {
+		t.Fatalf("LoadIncremental: %v", err)
+	}
+	if !next.After(mark) {
+		t.Errorf("mark did not advance: %v, then %v", mark, next)
+	}
+	if !m.HasPolicy("p", "p", []string{"carol", "data3", "read"}) {
+		t.Errorf("added rule not loaded")
+	}
+	if m.HasPolicy("p", "p", []string{"bob", "data2", "write"}) {
+		t.Errorf("removed rule still in the model")
+	}
+	if m.HasPolicy("p", "p", []string{"alice", "data1", "read"}) {
+		t.Errorf("unchanged rule loaded again")
+	}
+
+	again, err := a.LoadIncremental(context.Background(), m, next)
+	if err != nil {
+		t.Fatalf("LoadIncremental: %v", err)
+	}
+	if !again.Equal(next) {
+		t.Errorf("mark moved without changes: %v, then %v", next, again)
+	}
+}
+
+func TestLoadIncrementalLateCommit(t *testing.T) {
+	for _, tt := range []struct {
+		overlap time.Duration
+		seen    bool
+	}{
+		{0, false},
+		{defaultIncrementalOverlap, true},
+	} {
+		a := newTestAdapter(t, WithTimestamps(), WithIncrementalOverlap(tt.overlap))
+		if err := a.SavePolicy(newTestModel()); err != nil {
+			t.Fatalf("SavePolicy: %v", err)
+		}
+		m := newTestModel()
+		mark, err := a.LoadIncremental(context.Background(), m, time.Time{})
+		if err != nil {
+			t.Fatalf("LoadIncremental: %v", err)
+		}
+
+		// A rule stamped before the mark but committed after it, as by a
+		// transaction that started before the first call and ended after.
+		if _, err := a.db.Exec("INSERT INTO x_policy (p_type, v0, v1, v2, created_at, updated_at) VALUES ('p', 'dave', 'data4', 'read', ?, ?)",
+			mark.Add(-time.Second), mark.Add(-time.Second)); err != nil {
+			t.Fatalf("INSERT: %v", err)
+		}
+
+		next, err := a.LoadIncremental(context.Background(), m, mark)
+		if err != nil {
+			t.Fatalf("LoadIncremental: %v", err)
+		}
+		if got := m.HasPolicy("p", "p", []string{"dave", "data4", "read"}); got != tt.seen {
+			t.Errorf("overlap %v: late rule loaded = %v, want %v", tt.overlap, got, tt.seen)
+		}
+		if !next.Equal(mark) {
+			t.Errorf("overlap %v: mark moved from %v to %v for a change stamped before it", tt.overlap, mark, next)
+		}
+		a.close()
+	}
+}
+
+func TestLoadIncrementalRequiresTimestamps(t *testing.T) {
+	a := NewAdapter("", "", "", "")
+	if _, err := a.LoadIncremental(context.Background(), newTestModel(), time.Time{}); err == nil {
+		t.Errorf("LoadIncremental worked without timestamps")
+	}
+}
+
+func TestLoadChanges(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAdapter(t, WithIDColumn())
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	m := newTestModel()
+
+	if err := a.AddPolicy("p", "p", []string{"carol", "data3", "read"}); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+	if err := a.RemovePolicy("p", "p", []string{"bob", "data2", "write"}); err != nil {
+		t.Fatalf("RemovePolicy: %v", err)
+	}
+	if err := a.UpdatePolicy("g", "g", []string{"alice", "data2_admin"}, []string{"alice", "admin"}); err != nil {
+		t.Fatalf("UpdatePolicy: %v", err)
+	}
+	id, err := a.AddPolicyReturningID(ctx, "p", []string{"dave", "data4", "write"})
+	if err != nil {
+		t.Fatalf("AddPolicyReturningID: %v", err)
+	}
+	// Not referenced, so not loaded.
+	if err := a.AddPolicy("p", "p", []string{"erin", "data5", "read"}); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+
+	var refs []RuleRef
+	for _, c := range []Change{
+		{Op: OpAdd, Rules: [][]string{{"p", "carol", "data3", "read"}}},
+		{Op: OpRemove, Rules: [][]string{{"p", "bob", "data2", "write"}}},
+		{Op: OpUpdate, Rules: [][]string{{"g", "alice", "data2_admin"}, {"g", "alice", "admin"}}},
+	} {
+		changed, ok := c.RuleRefs()
+		if !ok {
+			t.Fatalf("RuleRefs of %+v: not ok", c)
+		}
+		refs = append(refs, changed...)
+	}
+	refs = append(refs, RuleRef{ID: id})
+	if err := a.LoadChanges(ctx, m, refs); err != nil {
+		t.Fatalf("LoadChanges: %v", err)
+	}
+
+	want := map[string][][]string{
+		"p": {{"alice", "data1", "read"}, {"data2_admin", "data2", "read"}, {"carol", "data3", "read"}, {"dave", "data4", "write"}},
+		"g": {{"alice", "admin"}},
+	}
+	for ptype, rules := range want {
+		if got := m[ptype][ptype].Policy; !reflect.DeepEqual(sortedRules(got), sortedRules(rules)) {
+			t.Errorf("%s after LoadChanges = %q, want %q", ptype, got, rules)
+		}
+	}
+}
+
+func TestChangeRuleRefs(t *testing.T) {
+	refs, ok := Change{Op: OpUpdate, Rules: [][]string{{"p", "alice", "data1", "read"}, {"p", "alice", "data1", "write"}}}.RuleRefs()
+	want := []RuleRef{
+		{PType: "p", Rule: []string{"alice", "data1", "read"}},
+		{PType: "p", Rule: []string{"alice", "data1", "write"}},
+	}
+	if !ok || !reflect.DeepEqual(refs, want) {
+		t.Errorf("RuleRefs of an update = %+v, %v, want %+v, true", refs, ok, want)
+	}
+	for _, c := range []Change{
+		{Op: OpSave},
+		{Op: OpReload},
+		{Op: OpRemoveFiltered, Rules: [][]string{{"p", "alice"}}},
+	} {
+		if _, ok := c.RuleRefs(); ok {
+			t.Errorf("RuleRefs of %s: ok, want a full reload", c.Op)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import "time"
+
+// Driver names the database driver the adapter uses.
+const Driver = "go-pg/v10"
+
+// AdapterInfo summarizes the configuration an adapter resolved from its
+// options, for confirming they took effect. It carries no secrets: the
+// password and the TLS configuration are left out.
+type AdapterInfo struct {
+	Driver   string
+	Addr     string
+	User     string
+	Database string
+	TLS      bool
+	// Tables are the policy tables, in the connection's current schema: p
+	// first with WithSectionTables.
+	Tables   []string
+	ReadFrom string
+	Columns  []string
+
+	PoolSize         int
+	MinIdleConns     int
+	PoolTimeout      time.Duration
+	MaxConnAge       time.Duration
+	MaxConnAgeJitter float64
+	Keepalive        time.Duration
+
+	Tenanted           bool
+	RowLevelSecurity   string
+	ReadOnly           bool
+	SoftDelete         bool
+	Timestamps         bool
+	SectionColumn      bool
+	IDColumn           bool
+	RuleHash           bool
+	SnapshotOnSave     bool
+	NullUnusedColumns  bool
+	StrictSchema       bool
+	LoadPageSize       int
+	PreparedStatements bool
+	SaveMode           SaveMode
+	IsolationLevel     IsolationLevel
+	MaxRetries         int
+	LockTimeout        time.Duration
+	ContextTimeout     time.Duration
+	NotifyChannel      string
+	Open               bool
+}
+
+// Info returns the adapter's effective configuration.
+func (a *Adapter) Info() AdapterInfo {
+	var tables []string
+	for _, b := range a.sections() {
+		tables = append(tables, b.table)
+	}
+	return AdapterInfo{
+		Driver:   Driver,
+		Addr:     a.options.Addr,
+		User:     a.options.User,
+		Database: a.options.Database,
+		TLS:      a.options.TLSConfig != nil || a.sslRootCert.isSet() || a.sslCert.isSet(),
+		Tables:   tables,
+		ReadFrom: a.readFrom,
+		Columns:  append([]string(nil), a.cols...),
+
+		PoolSize:         a.options.PoolSize,
+		MinIdleConns:     a.options.MinIdleConns,
+		PoolTimeout:      a.options.PoolTimeout,
+		MaxConnAge:       a.options.MaxConnAge,
+		MaxConnAgeJitter: a.ageJitter,
+		Keepalive:        a.keepalive,
+
+		Tenanted:           a.tenantFunc != nil,
+		RowLevelSecurity:   a.rlsSetting,
+		ReadOnly:           a.readOnly,
+		SoftDelete:         a.softDelete,
+		Timestamps:         a.timestamps,
+		SectionColumn:      a.secColumn,
+		IDColumn:           a.idColumn,
+		RuleHash:           a.ruleHash,
+		SnapshotOnSave:     a.snapshotOnSave,
+		NullUnusedColumns:  a.nullUnused,
+		StrictSchema:       a.strictSchema,
+		LoadPageSize:       a.pageSize,
+		PreparedStatements: !a.noPrepare,
+		SaveMode:           a.saveMode,
+		IsolationLevel:     a.isolation,
+		MaxRetries:         a.maxRetries,
+		LockTimeout:        a.lockTimeout,
+		ContextTimeout:     a.ctxTimeout,
+		NotifyChannel:      a.notifyChannel,
+		Open:               a.db != nil,
+	}
+}
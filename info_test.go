@@ -0,0 +1,61 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInfo(t *testing.T) {
+	a := NewAdapter("casbin", "s3cret", "policies", "db.internal:5433",
+		WithTablePrefix("app_"),
+		WithPoolSize(7),
+		WithTenantFromContext(func(ctx context.Context) (string, error) { return "t1", nil }),
+		WithSoftDelete(),
+		WithSaveMode(SaveModeDrop),
+		WithLockTimeout(time.Second),
+		WithPreparedStatements(false))
+
+	info := a.Info()
+	for _, c := range []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"Driver", info.Driver, Driver},
+		{"Addr", info.Addr, "db.internal:5433"},
+		{"User", info.User, "casbin"},
+		{"Database", info.Database, "policies"},
+		{"Tables", strings.Join(info.Tables, ","), "app_x_policy"},
+		{"PoolSize", info.PoolSize, 7},
+		{"Tenanted", info.Tenanted, true},
+		{"SoftDelete", info.SoftDelete, true},
+		{"SaveMode", info.SaveMode, SaveModeDrop},
+		{"LockTimeout", info.LockTimeout, time.Second},
+		{"PreparedStatements", info.PreparedStatements, false},
+		{"Open", info.Open, false},
+	} {
+		if c.got != c.want {
+			t.Errorf("Info().%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+
+	if s := fmt.Sprintf("%+v", info); strings.Contains(s, "s3cret") {
+		t.Errorf("Info() leaks the password: %s", s)
+	}
+}
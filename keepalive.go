@@ -0,0 +1,82 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// WithKeepalive makes Open start a background loop that pings every idle
+// connection of the pool each interval, until Close. This keeps connections
+// of a process that rarely touches the database from being dropped by a
+// firewall's idle timeout, and a connection that did die fails its ping and
+// is removed from the pool rather than failing the next real query. Ping
+// errors are otherwise ignored.
+func WithKeepalive(interval time.Duration) Option {
+	return func(a *Adapter) {
+		a.keepalive = interval
+	}
+}
+
+// startKeepalive starts the keepalive loop on db if one is configured.
+func (a *Adapter) startKeepalive(db *pg.DB) {
+	if a.keepalive <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	a.stopKeepalive = func() {
+		cancel()
+		<-done
+	}
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(a.keepalive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ping(ctx, db)
+			}
+		}
+	}()
+}
+
+// ping pings each idle connection of db once, holding those already pinged
+// so that the next ping gets another.
+func ping(ctx context.Context, db *pg.DB) {
+	n := int(db.PoolStats().IdleConns)
+	if n < 1 {
+		n = 1
+	}
+	conns := make([]*pg.Conn, 0, n)
+	defer func() {
+		for _, cn := range conns {
+			cn.Close()
+		}
+	}()
+
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		cn := db.Conn()
+		conns = append(conns, cn)
+		cn.Ping(ctx)
+	}
+}
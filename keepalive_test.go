@@ -0,0 +1,56 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// pings returns the number of keepalive pings the hook has seen.
+func (h *recordingHook) pings() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := 0
+	for _, q := range h.queries {
+		if q == "SELECT 1" {
+			n++
+		}
+	}
+	return n
+}
+
+func TestKeepalive(t *testing.T) {
+	hook := &recordingHook{}
+	a := newTestAdapter(t, WithQueryHook(hook), WithKeepalive(20*time.Millisecond))
+	if err := a.Open(context.Background()); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if n := hook.pings(); n < 3 {
+		t.Errorf("pings after 10 intervals = %d, want at least 3", n)
+	}
+
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	n := hook.pings()
+	time.Sleep(100 * time.Millisecond)
+	if got := hook.pings(); got != n {
+		t.Errorf("pings went from %d to %d after Close", n, got)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import "fmt"
+
+// loadFilterOps are the operators WithLoadFilter accepts.
+var loadFilterOps = map[string]bool{
+	"=": true, "<>": true, "<": true, "<=": true, ">": true, ">=": true,
+	"LIKE": true, "NOT LIKE": true,
+}
+
+// loadFilter is the predicate of WithLoadFilter.
+type loadFilter struct {
+	column string
+	op     string
+	value  string
+}
+
+// WithLoadFilter narrows every load, LoadIncremental included, to the rows
+// whose column compares to value under op, as in WithLoadFilter("v0",
+// "LIKE", "acme:%") for a policy that encodes its tenant in v0. column is
+// the name of a rule column after WithColumnNamer, other than an array
+// column of WithArrayColumns; op is one of =, <>, <, <=, >, >=, LIKE and
+// NOT LIKE. value is passed as a query parameter: Open fails for any other
+// column or operator, so nothing of the filter but value reaches the query
+// unchecked. Writes, GetAllPolicies and the like are not filtered.
+func WithLoadFilter(column, op, value string) Option {
+	return func(a *Adapter) {
+		a.loadFilter = &loadFilter{column: column, op: op, value: value}
+	}
+}
+
+// checkLoadFilter validates the column and operator of WithLoadFilter.
+func (a *Adapter) checkLoadFilter() error {
+	f := a.loadFilter
+	if f == nil {
+		return nil
+	}
+	if !loadFilterOps[f.op] {
+		return fmt.Errorf("adapter: invalid load filter operator %q", f.op)
+	}
+	for i, col := range a.cols {
+		if col != f.column {
+			continue
+		}
+		if i > 0 && a.isArray(i-1) {
+			return fmt.Errorf("adapter: load filter on array column %q is not supported", f.column)
+		}
+		return nil
+	}
+	return fmt.Errorf("adapter: unknown load filter column %q", f.column)
+}
+
+// filterLoad narrows the condition where of a load by WithLoadFilter,
+// putting the filter's parameter before params, as scope does.
+func (a *Adapter) filterLoad(where string, params ...interface{}) (string, []interface{}) {
+	f := a.loadFilter
+	if f == nil {
+		return where, params
+	}
+	cond := f.column + " " + f.op + " ?"
+	if where != "" {
+		cond += " AND (" + where + ")"
+	}
+	return cond, append([]interface{}{f.value}, params...)
+}
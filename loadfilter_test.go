@@ -0,0 +1,71 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLoadFilterInvalid(t *testing.T) {
+	for _, opt := range []Option{
+		WithLoadFilter("v6", "=", "acme"),
+		WithLoadFilter("v0 = v0 OR v0", "=", "acme"),
+		WithLoadFilter("v0", "= '' OR v0 =", "acme"),
+		WithLoadFilter("v0", "ILIKE", "acme"),
+	} {
+		a := NewAdapter("", "", "", "", opt)
+		if err := a.Open(context.Background()); err == nil {
+			a.close()
+			t.Errorf("Open with load filter %+v: err = nil, want an error", *a.loadFilter)
+		}
+	}
+
+	a := NewAdapter("", "", "", "", WithArrayColumns(",", 2), WithLoadFilter("v2", "=", "read"))
+	if err := a.Open(context.Background()); err == nil {
+		a.close()
+		t.Errorf("Open with a load filter on an array column: err = nil, want an error")
+	}
+}
+
+func TestLoadFilter(t *testing.T) {
+	a := newTestAdapter(t, WithLoadFilter("v1", "LIKE", "data2%"))
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	m := newTestModel()
+	m.ClearPolicy()
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if got := m.GetPolicy("p", "p"); len(got) != 2 || m.HasPolicy("p", "p", []string{"alice", "data1", "read"}) {
+		t.Errorf("loaded %q, want the 2 rules on data2", got)
+	}
+	if got := m.GetPolicy("g", "g"); len(got) != 1 {
+		t.Errorf("loaded %q, want alice's data2_admin role", got)
+	}
+
+	m.ClearPolicy()
+	if err := a.LoadFilteredPolicy(m, Filter{PType: []string{"p"}}); err != nil {
+		t.Fatalf("LoadFilteredPolicy: %v", err)
+	}
+	if got := m.GetPolicy("p", "p"); len(got) != 2 {
+		t.Errorf("filtered load returned %q, want the 2 rules on data2", got)
+	}
+	if got := m.GetPolicy("g", "g"); len(got) != 0 {
+		t.Errorf("filtered load returned %q, want no roles", got)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// ErrTableBusy is returned by SavePolicy when, under WithLockTimeout, another
+// session held a lock on the policy table for longer than the timeout.
+var ErrTableBusy = errors.New("adapter: policy table busy")
+
+// WithLockTimeout bounds how long SavePolicy waits for the locks it needs on
+// the policy table. If another session holds a conflicting lock, during
+// maintenance say, for longer than d, the save is rolled back and fails with
+// ErrTableBusy rather than blocking until the lock is released, and the
+// caller can retry later. d is rounded up to a whole millisecond. The
+// timeout is set with SET LOCAL, so under WithTx it stays in force for the
+// rest of the caller's transaction.
+func WithLockTimeout(d time.Duration) Option {
+	return func(a *Adapter) {
+		a.lockTimeout = d
+	}
+}
+
+// setLockTimeout applies the configured lock timeout to tx.
+func (a *Adapter) setLockTimeout(tx *pg.Tx) error {
+	if a.lockTimeout <= 0 {
+		return nil
+	}
+	ms := (a.lockTimeout + time.Millisecond - 1) / time.Millisecond
+	_, err := tx.Exec(fmt.Sprintf("SET LOCAL lock_timeout = %d", ms))
+	return err
+}
+
+// tableBusy maps a lock timeout (SQLSTATE 55P03) to ErrTableBusy.
+func tableBusy(err error) error {
+	if pgErr, ok := err.(pg.Error); ok && pgErr.Field('C') == "55P03" {
+		return ErrTableBusy
+	}
+	return err
+}
+
+// ruleLockKey returns the key of the advisory lock AddPolicyIfNotExists takes
+// on line in table for tenant: a 64-bit FNV-1a hash of them.
+func ruleLockKey(table, tenant string, line CasbinRule) int64 {
+	h := fnv.New64a()
+	for _, s := range []string{table, tenant, line.PType, line.V0, line.V1, line.V2, line.V3, line.V4, line.V5} {
+		h.Write([]byte(s))
+		h.Write([]byte(ruleHashSep))
+	}
+	return int64(h.Sum64())
+}
@@ -0,0 +1,61 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSavePolicyLockTimeout(t *testing.T) {
+	a := newTestAdapter(t, WithLockTimeout(100*time.Millisecond))
+
+	holder, err := a.db.Begin()
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	defer holder.Rollback()
+	if _, err := holder.Exec("LOCK TABLE " + a.table + " IN ACCESS EXCLUSIVE MODE"); err != nil {
+		t.Fatalf("LOCK TABLE: %v", err)
+	}
+
+	start := time.Now()
+	err = a.SavePolicy(newTestModel())
+	if err != ErrTableBusy {
+		t.Fatalf("SavePolicy: err = %v, want ErrTableBusy", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("SavePolicy took %v to fail, want it to fail promptly", elapsed)
+	}
+}
+
+func TestRuleLockKey(t *testing.T) {
+	line := CasbinRule{PType: "p", V0: "alice", V1: "data1", V2: "read"}
+	key := ruleLockKey("x_policy", "", line)
+	if got := ruleLockKey("x_policy", "", line); got != key {
+		t.Errorf("ruleLockKey = %d, then %d, want the same key", key, got)
+	}
+
+	shifted := CasbinRule{PType: "p", V0: "alic", V1: "edata1", V2: "read"}
+	for name, other := range map[string]int64{
+		"another table":  ruleLockKey("y_policy", "", line),
+		"another tenant": ruleLockKey("x_policy", "acme", line),
+		"shifted values": ruleLockKey("x_policy", "", shifted),
+	} {
+		if other == key {
+			t.Errorf("ruleLockKey of %s = %d, the key of the rule", name, other)
+		}
+	}
+}
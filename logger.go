@@ -0,0 +1,59 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// Logger receives diagnostic output from the adapter. *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// WithLogger routes the adapter's diagnostics, including the queries it
+// runs, to l. Queries are logged as unformatted templates, so policy values
+// bound as parameters never reach the log; only the warnings about a rule,
+// such as that of a RemovePolicy matching no row, name it. Without a
+// logger, no query hook is installed and nothing is logged.
+func WithLogger(l Logger) Option {
+	return func(a *Adapter) {
+		a.logger = l
+	}
+}
+
+// queryLogger is a go-pg query hook that logs redacted query text.
+type queryLogger struct {
+	logger Logger
+}
+
+func (h queryLogger) BeforeQuery(ctx context.Context, _ *pg.QueryEvent) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h queryLogger) AfterQuery(_ context.Context, ev *pg.QueryEvent) error {
+	query, err := ev.UnformattedQuery()
+	if err != nil {
+		return nil
+	}
+	if ev.Err != nil {
+		h.logger.Printf("adapter: query failed: %s: %v", query, ev.Err)
+		return nil
+	}
+	h.logger.Printf("adapter: query: %s", query)
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+)
+
+func TestQueryLoggerRedactsParams(t *testing.T) {
+	var buf bytes.Buffer
+	h := queryLogger{log.New(&buf, "", 0)}
+
+	h.AfterQuery(context.Background(), &pg.QueryEvent{
+		Query:  "DELETE FROM x_policy WHERE v0 = ?",
+		Params: []interface{}{"alice"},
+	})
+
+	out := buf.String()
+	if !strings.Contains(out, "DELETE FROM x_policy WHERE v0 = ?") {
+		t.Errorf("query template not logged: %q", out)
+	}
+	if strings.Contains(out, "alice") {
+		t.Errorf("parameter value leaked into log: %q", out)
+	}
+}
+
+// ctxLogger adapts a *log.Logger to the logger interface of go-pg.
+type ctxLogger struct {
+	*log.Logger
+}
+
+func (l ctxLogger) Printf(_ context.Context, format string, v ...interface{}) {
+	l.Logger.Printf(format, v...)
+}
+
+func TestQueryLoggingOffByDefault(t *testing.T) {
+	var buf bytes.Buffer
+	pg.SetLogger(ctxLogger{log.New(&buf, "", 0)})
+	log.SetOutput(&buf)
+	defer func() {
+		pg.SetLogger(ctxLogger{log.New(os.Stderr, "pg: ", log.LstdFlags|log.Lshortfile)})
+		log.SetOutput(os.Stderr)
+	}()
+
+	a := newTestAdapter(t)
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	if buf.Len() != 0 {
+		t.Errorf("unexpected log output with logging off: %q", buf.String())
+	}
+}
+
+func TestQueryLoggingWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	a := newTestAdapter(t, WithLogger(log.New(&buf, "", 0)))
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, "x_policy") {
+		t.Errorf("no queries logged: %q", out)
+	}
+	if strings.Contains(out, "alice") {
+		t.Errorf("policy value leaked into log: %q", out)
+	}
+}
+
+func TestRemovePolicyNoMatchWarning(t *testing.T) {
+	var buf bytes.Buffer
+	a := newTestAdapter(t, WithLogger(log.New(&buf, "", 0)))
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	buf.Reset()
+	if err := a.RemovePolicy("p", "p", []string{"alice", "data1", "read"}); err != nil {
+		t.Fatalf("RemovePolicy: %v", err)
+	}
+	if strings.Contains(buf.String(), "warning") {
+		t.Errorf("warning logged for a rule that was removed: %q", buf.String())
+	}
+
+	buf.Reset()
+	if err := a.RemovePolicy("p", "p", []string{"alice", "data1", "read"}); err != nil {
+		t.Fatalf("RemovePolicy of a missing rule: %v", err)
+	}
+	if want := "adapter: warning: RemovePolicy matched no row in x_policy: p, alice, data1, read"; !strings.Contains(buf.String(), want) {
+		t.Errorf("log %q does not hold %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// OpLoad is the op Metrics.ObserveRows reports loads under.
+const OpLoad = "load"
+
+// Metrics receives measurements of the adapter's operations, for export to a
+// monitoring system. Query latencies and errors are for a query hook to
+// measure; see WithQueryHook.
+type Metrics interface {
+	// ObserveRows is called once an operation succeeds with the number of
+	// rows it touched. op is OpLoad for the loads, OpSave for SavePolicy and
+	// the saves of ReplaceTenantPolicies and RestoreSnapshot, OpAdd for the
+	// adds, OpUpdate for UpdatePolicy and OpRemove for the removals,
+	// filtered ones included. A remove matching nothing reports 0.
+	ObserveRows(op string, n int)
+}
+
+// WithMetrics reports the rows each operation touches to m, for graphing the
+// policy's size and the rows each save writes.
+func WithMetrics(m Metrics) Option {
+	return func(a *Adapter) {
+		a.metrics = m
+	}
+}
+
+func (a *Adapter) observeRows(op string, n int) {
+	if a.metrics == nil {
+		return
+	}
+	if op == OpRemoveFiltered {
+		op = OpRemove
+	}
+	a.metrics.ObserveRows(op, n)
+}
+
+// PoolMetrics is implemented by a Metrics that also takes the statistics of
+// the adapter's connection pool, sampled under WithPoolStats.
+type PoolMetrics interface {
+	// ObservePool is called each interval with the pool's counters: hits,
+	// misses and timeouts since the pool opened, and the connections it
+	// holds, idle and stale, at the time.
+	ObservePool(stats pg.PoolStats)
+}
+
+// WithPoolStats makes Open start a loop reporting the pool's statistics to
+// the Metrics of WithMetrics each interval, until Close, if that Metrics
+// implements PoolMetrics; otherwise it does nothing.
+func WithPoolStats(interval time.Duration) Option {
+	return func(a *Adapter) {
+		a.poolStatsEvery = interval
+	}
+}
+
+// startPoolStats starts the loop of WithPoolStats on db if one is
+// configured.
+func (a *Adapter) startPoolStats(db *pg.DB) {
+	m, ok := a.metrics.(PoolMetrics)
+	if !ok || a.poolStatsEvery <= 0 {
+		return
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	a.stopPoolStats = func() {
+		close(stop)
+		<-done
+	}
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(a.poolStatsEvery)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				m.ObservePool(*db.PoolStats())
+			}
+		}
+	}()
+}
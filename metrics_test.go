@@ -0,0 +1,113 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// fakeMetrics records the observations reported to it as "op=n".
+type fakeMetrics struct {
+	rows []string
+}
+
+func (m *fakeMetrics) ObserveRows(op string, n int) {
+	m.rows = append(m.rows, fmt.Sprintf("%s=%d", op, n))
+}
+
+func TestMetricsObserveRows(t *testing.T) {
+	metrics := &fakeMetrics{}
+	a := newTestAdapter(t, WithMetrics(metrics))
+
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	if err := a.AddPolicy("p", "p", []string{"carol", "data3", "read"}); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+	if err := a.UpdatePolicy("p", "p", []string{"carol", "data3", "read"}, []string{"carol", "data3", "write"}); err != nil {
+		t.Fatalf("UpdatePolicy: %v", err)
+	}
+	if err := a.RemoveFilteredPolicy("p", "p", 1, "data2"); err != nil {
+		t.Fatalf("RemoveFilteredPolicy: %v", err)
+	}
+	if err := a.RemovePolicy("p", "p", []string{"nobody", "data1", "read"}); err != nil {
+		t.Fatalf("RemovePolicy: %v", err)
+	}
+	m := newTestModel()
+	m.ClearPolicy()
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+
+	want := []string{"save=4", "add=1", "update=1", "remove=2", "remove=0", "load=3"}
+	if !reflect.DeepEqual(metrics.rows, want) {
+		t.Errorf("observed %q, want %q", metrics.rows, want)
+	}
+}
+
+// fakePoolMetrics is a fakeMetrics that also records pool statistics.
+type fakePoolMetrics struct {
+	fakeMetrics
+	mu    sync.Mutex
+	pools []pg.PoolStats
+}
+
+func (m *fakePoolMetrics) ObservePool(stats pg.PoolStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pools = append(m.pools, stats)
+}
+
+func (m *fakePoolMetrics) samples() []pg.PoolStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]pg.PoolStats(nil), m.pools...)
+}
+
+func TestPoolStats(t *testing.T) {
+	metrics := &fakePoolMetrics{}
+	a := newTestAdapter(t, WithMetrics(metrics), WithPoolStats(10*time.Millisecond))
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		samples := metrics.samples()
+		if n := len(samples); n > 0 && samples[n-1].TotalConns > 0 && samples[n-1].Hits+samples[n-1].Misses > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pool statistics reported %+v, want a pool with connections", samples)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	n := len(metrics.samples())
+	time.Sleep(50 * time.Millisecond)
+	if got := len(metrics.samples()); got != n {
+		t.Errorf("%d pool statistics reported after Close, want none", got-n)
+	}
+}
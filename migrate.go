@@ -0,0 +1,172 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+// CurrentSchemaVersion is the version of the schema this release creates
+// for the policy table. Version 1 is the table of releases before the
+// version marker, with p_type VARCHAR(10); version 2 widens p_type, and the
+// p_type of the history and audit tables, to VARCHAR(32).
+const CurrentSchemaVersion = 2
+
+// migrations[i] takes the tables from schema version i+1 to i+2. A migration
+// must leave tables it has already been applied to unchanged, since a table
+// created or recreated by a newer release can carry an older marker.
+var migrations = []func(a *Adapter, db orm.DB) error{
+	(*Adapter).widenPType,
+}
+
+// WithAutoMigrateOnVersionMismatch makes Open run Migrate when the version
+// marker of the policy table records an older schema than
+// CurrentSchemaVersion, logging the upgrade with the logger of WithLogger,
+// if any. A table on a newer schema, from a newer release, is left alone.
+// Open fails if it is combined with WithReadOnly or WithoutCreateTable.
+func WithAutoMigrateOnVersionMismatch() Option {
+	return func(a *Adapter) {
+		a.autoMigrate = true
+	}
+}
+
+// versionTable is the marker table recording the schema version of the
+// policy table, in a single row.
+func (a *Adapter) versionTable() string {
+	return a.table + "_schema_version"
+}
+
+// createVersionTable creates the marker table of the policy table, holding
+// version unless it holds one already.
+func (a *Adapter) createVersionTable(db orm.DB, version int) error {
+	err := ddl(db, "CREATE TABLE IF NOT EXISTS "+a.versionTable()+" (id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id), version INT NOT NULL)")
+	if err != nil {
+		return err
+	}
+	if a.serverBefore(90500) {
+		// Without ON CONFLICT; a concurrent insert fails with the
+		// unique_violation ddl takes for success.
+		return ddl(db, "INSERT INTO "+a.versionTable()+" (version) SELECT ? WHERE NOT EXISTS (SELECT 1 FROM "+a.versionTable()+")", version)
+	}
+	return ddl(db, "INSERT INTO "+a.versionTable()+" (version) VALUES (?) ON CONFLICT DO NOTHING", version)
+}
+
+// SchemaVersion returns the schema version the marker table records for the
+// policy table: 1 for a table from before the marker, the lowest among them
+// with WithSectionTables.
+func (a *Adapter) SchemaVersion(ctx context.Context) (int, error) {
+	a.open()
+	lowest := CurrentSchemaVersion
+	for _, b := range a.sections() {
+		var version int
+		_, err := b.conn(ctx).QueryOne(pg.Scan(&version), b.tag(ctx, "SELECT version FROM "+b.versionTable()))
+		if pgErr, ok := err.(pg.Error); ok && pgErr.Field('C') == "42P01" {
+			version, err = 1, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if version < lowest {
+			lowest = version
+		}
+	}
+	return lowest, nil
+}
+
+// Migrate brings the policy table forward to CurrentSchemaVersion, running
+// the migrations from the version its marker records, in one transaction
+// per table that also updates the marker. Concurrent calls, from replicas
+// starting together, wait for each other and migrate once. The migrations
+// only widen column types, which rewrites neither the table nor its
+// indexes. A table on a newer schema is left alone.
+func (a *Adapter) Migrate(ctx context.Context) error {
+	if a.readOnly {
+		return ErrReadOnly
+	}
+	a.open()
+	return a.migrate(ctx, a.db)
+}
+
+func (a *Adapter) migrate(ctx context.Context, db *pg.DB) error {
+	for _, b := range a.sections() {
+		if err := db.RunInTransaction(ctx, b.migrateTable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateTable runs the migrations the marker of the policy table calls for
+// and records the new version, holding a lock on the marker meanwhile.
+func (a *Adapter) migrateTable(tx *pg.Tx) error {
+	if err := a.createVersionTable(tx, 1); err != nil {
+		return err
+	}
+	var version int
+	if _, err := tx.QueryOne(pg.Scan(&version), "SELECT version FROM "+a.versionTable()+" FOR UPDATE"); err != nil {
+		return err
+	}
+	if version > CurrentSchemaVersion && a.logger != nil {
+		a.logger.Printf("adapter: %s has schema version %d, newer than %d; leaving it alone", a.table, version, CurrentSchemaVersion)
+	}
+	if version >= CurrentSchemaVersion {
+		return nil
+	}
+
+	if a.logger != nil {
+		a.logger.Printf("adapter: migrating %s from schema version %d to %d", a.table, version, CurrentSchemaVersion)
+	}
+	for v := version; v < CurrentSchemaVersion; v++ {
+		if err := migrations[v-1](a, tx); err != nil {
+			return fmt.Errorf("adapter: migrating %s to schema version %d: %w", a.table, v+1, err)
+		}
+	}
+	_, err := tx.Exec("UPDATE "+a.versionTable()+" SET version = ?", CurrentSchemaVersion)
+	return err
+}
+
+// widenPType is the migration to schema version 2.
+func (a *Adapter) widenPType(db orm.DB) error {
+	if err := widenColumn(db, a.table, a.ptypeCol(), 32); err != nil {
+		return err
+	}
+	if err := widenColumn(db, a.historyTable, a.ptypeCol(), 32); err != nil {
+		return err
+	}
+	return widenColumn(db, a.auditTable, "p_type", 32)
+}
+
+// widenColumn changes column of table to VARCHAR(n) if it is a VARCHAR of
+// fewer characters, and leaves it alone otherwise, a missing table or column
+// included, so it is safe to run again.
+func widenColumn(db orm.DB, table, column string, n int) error {
+	var have []schemaColumn
+	_, err := db.Query(&have,
+		"SELECT column_name, data_type, character_maximum_length FROM information_schema.columns "+
+			"WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?", table, column)
+	if err != nil {
+		return err
+	}
+	if len(have) == 0 || have[0].DataType != "character varying" ||
+		have[0].CharacterMaximumLength == 0 || have[0].CharacterMaximumLength >= n {
+		return nil
+	}
+	_, err = db.Exec("ALTER TABLE "+table+" ALTER COLUMN "+column+" TYPE VARCHAR(?)", n)
+	return err
+}
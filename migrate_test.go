@@ -0,0 +1,145 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// newOldSchemaTable creates x_policy as releases before the schema version
+// marker did, holding one rule, and returns the connection options.
+func newOldSchemaTable(t *testing.T) pg.Options {
+	t.Helper()
+	a := newTestAdapter(t, WithoutCreateTable())
+	a.open()
+	defer a.close()
+	_, err := a.db.Exec("CREATE TABLE x_policy (p_type VARCHAR(10), v0 VARCHAR(256), v1 VARCHAR(256), v2 VARCHAR(256), v3 VARCHAR(256), v4 VARCHAR(256), v5 VARCHAR(256))")
+	if err != nil {
+		t.Fatalf("CREATE TABLE: %v", err)
+	}
+	if _, err := a.db.Exec("INSERT INTO x_policy (p_type, v0, v1, v2) VALUES ('p', 'alice', 'data1', 'read')"); err != nil {
+		t.Fatalf("INSERT: %v", err)
+	}
+	return a.options
+}
+
+// ptypeWidth returns the declared width of the p_type column of x_policy.
+func ptypeWidth(t *testing.T, a *Adapter) int {
+	t.Helper()
+	var n int
+	_, err := a.db.QueryOne(pg.Scan(&n), "SELECT character_maximum_length FROM information_schema.columns "+
+		"WHERE table_schema = current_schema() AND table_name = 'x_policy' AND column_name = 'p_type'")
+	if err != nil {
+		t.Fatalf("information_schema: %v", err)
+	}
+	return n
+}
+
+func TestAutoMigrateOnOpen(t *testing.T) {
+	ctx := context.Background()
+	options := newOldSchemaTable(t)
+
+	var buf bytes.Buffer
+	a := newAdapter(options, WithAutoMigrateOnVersionMismatch(), WithLogger(log.New(&buf, "", 0)))
+	if err := a.Open(ctx); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer a.close()
+
+	if got := ptypeWidth(t, a); got != 32 {
+		t.Errorf("p_type is VARCHAR(%d) after Open, want VARCHAR(32)", got)
+	}
+	if version, err := a.SchemaVersion(ctx); err != nil || version != CurrentSchemaVersion {
+		t.Errorf("SchemaVersion = %d, %v, want %d", version, err, CurrentSchemaVersion)
+	}
+	if want := "adapter: migrating x_policy from schema version 1 to 2"; !strings.Contains(buf.String(), want) {
+		t.Errorf("log %q does not mention %q", buf.String(), want)
+	}
+	if err := a.CheckSchema(ctx); err != nil {
+		t.Errorf("CheckSchema of the migrated table: %v", err)
+	}
+
+	m := newTestModel()
+	m.ClearPolicy()
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if got := m.GetPolicy("p", "p"); len(got) != 1 || strings.Join(got[0], ",") != "alice,data1,read" {
+		t.Errorf("loaded %q, want the rule of the old table", got)
+	}
+	if err := a.AddPolicy("p", "p_eleven_ch", []string{"bob", "data2", "read"}); err != nil {
+		t.Errorf("AddPolicy of an 11-character ptype: %v", err)
+	}
+
+	// Migrating again, on the next open or by hand, changes nothing.
+	buf.Reset()
+	a.close()
+	a.open()
+	if err := a.Migrate(ctx); err != nil {
+		t.Errorf("Migrate of a migrated table: %v", err)
+	}
+	if strings.Contains(buf.String(), "migrating") {
+		t.Errorf("migrated table migrated again: %q", buf.String())
+	}
+	if got := ptypeWidth(t, a); got != 32 {
+		t.Errorf("p_type is VARCHAR(%d) after migrating again, want VARCHAR(32)", got)
+	}
+}
+
+func TestOpenWithoutAutoMigrate(t *testing.T) {
+	ctx := context.Background()
+	a := newAdapter(newOldSchemaTable(t))
+	if err := a.Open(ctx); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer a.close()
+
+	if got := ptypeWidth(t, a); got != 10 {
+		t.Errorf("p_type is VARCHAR(%d) without WithAutoMigrateOnVersionMismatch, want VARCHAR(10)", got)
+	}
+	if version, err := a.SchemaVersion(ctx); err != nil || version != 1 {
+		t.Errorf("SchemaVersion = %d, %v, want 1", version, err)
+	}
+
+	if err := a.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if got := ptypeWidth(t, a); got != 32 {
+		t.Errorf("p_type is VARCHAR(%d) after Migrate, want VARCHAR(32)", got)
+	}
+}
+
+func TestSchemaVersionOfNewTable(t *testing.T) {
+	a := newTestAdapter(t)
+	if version, err := a.SchemaVersion(context.Background()); err != nil || version != CurrentSchemaVersion {
+		t.Errorf("SchemaVersion of a new table = %d, %v, want %d", version, err, CurrentSchemaVersion)
+	}
+}
+
+func TestAutoMigrateRequiresWritableSchema(t *testing.T) {
+	for _, opt := range []Option{WithReadOnly(), WithoutCreateTable()} {
+		a := NewAdapter("", "", "", "", WithAutoMigrateOnVersionMismatch(), opt)
+		if err := a.Open(context.Background()); err == nil {
+			a.Close()
+			t.Error("Open: err = nil, want WithAutoMigrateOnVersionMismatch rejected")
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// onConnectRe matches the statements WithOnConnect accepts: a session SET of
+// a parameter, SET TIME ZONE, or a SELECT of set_config, each on its own.
+var onConnectRe = regexp.MustCompile(`(?is)^\s*(` +
+	`SET\s+(SESSION\s+)?[a-z_][a-z0-9_.]*\s*(=|\sTO\s)[^;]+` +
+	`|SET\s+(SESSION\s+)?TIME\s+ZONE\s[^;]+` +
+	`|SELECT\s+set_config\s*\([^;]+\)` +
+	`)\s*;?\s*$`)
+
+// sessionSetRe matches the SET statements onConnectRe lets through that
+// change the role or do not last the session.
+var sessionSetRe = regexp.MustCompile(`(?is)^\s*SET\s+(SESSION\s+)?(ROLE|SESSION\s+AUTHORIZATION|LOCAL)\b`)
+
+// WithOnConnect runs sqls, in order, on every connection the pool opens,
+// for session settings such as search_path, timezone or work_mem that the
+// connection options do not cover. Each statement must be a single SET of a
+// parameter, such as "SET search_path = app, public" or "SET TIME ZONE
+// 'UTC'", or a SELECT set_config(...); SET ROLE, SET SESSION AUTHORIZATION
+// and SET LOCAL, which would not outlast the statement's transaction, are
+// refused, and Open fails on any other statement. A statement that fails
+// fails the connection, and with it the query that needed one.
+func WithOnConnect(sqls ...string) Option {
+	return func(a *Adapter) {
+		a.onConnect = append(a.onConnect, sqls...)
+	}
+}
+
+// checkOnConnect validates the statements given to WithOnConnect.
+func (a *Adapter) checkOnConnect() error {
+	for _, q := range a.onConnect {
+		if !onConnectRe.MatchString(q) || sessionSetRe.MatchString(q) {
+			return fmt.Errorf("adapter: WithOnConnect statement %q is not a SET of a session parameter", q)
+		}
+	}
+	return nil
+}
+
+// withOnConnect returns the OnConnect of options, running the statements of
+// WithOnConnect after any hook options already had.
+func (a *Adapter) withOnConnect(options pg.Options) pg.Options {
+	if len(a.onConnect) == 0 {
+		return options
+	}
+	next := options.OnConnect
+	sqls := a.onConnect
+	options.OnConnect = func(ctx context.Context, cn *pg.Conn) error {
+		if next != nil {
+			if err := next(ctx, cn); err != nil {
+				return err
+			}
+		}
+		for _, q := range sqls {
+			if _, err := cn.ExecContext(ctx, q); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return options
+}
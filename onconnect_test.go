@@ -0,0 +1,75 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+)
+
+func TestOnConnect(t *testing.T) {
+	a := newTestAdapter(t, WithWarmup(2), WithOnConnect(
+		"SET timezone = 'Asia/Tokyo'",
+		"SELECT set_config('work_mem', '8MB', false)",
+	))
+	a.open()
+	defer a.close()
+
+	// Several queries at once use several connections, each set up anew.
+	for i := 0; i < 4; i++ {
+		var timezone, workMem string
+		_, err := a.db.QueryOne(pg.Scan(&timezone, &workMem), "SELECT current_setting('timezone'), current_setting('work_mem')")
+		if err != nil {
+			t.Fatalf("current_setting: %v", err)
+		}
+		if timezone != "Asia/Tokyo" || workMem != "8MB" {
+			t.Errorf("timezone, work_mem = %q, %q, want Asia/Tokyo, 8MB", timezone, workMem)
+		}
+	}
+}
+
+func TestOnConnectStatements(t *testing.T) {
+	for _, q := range []string{
+		"SET timezone = 'UTC'",
+		"set search_path to app, public;",
+		"SET SESSION work_mem = '64MB'",
+		"SET TIME ZONE 'Europe/Paris'",
+		"SELECT set_config('statement_timeout', '5s', false)",
+	} {
+		a := NewAdapter("", "", "", "", WithOnConnect(q))
+		if err := a.checkOnConnect(); err != nil {
+			t.Errorf("checkOnConnect(%q): %v", q, err)
+		}
+	}
+
+	for _, q := range []string{
+		"DROP TABLE x_policy",
+		"SET timezone = 'UTC'; DROP TABLE x_policy",
+		"SET ROLE admin",
+		"SET ROLE TO admin",
+		"SET SESSION AUTHORIZATION admin",
+		"SET LOCAL work_mem = '1MB'",
+		"SELECT pg_terminate_backend(1)",
+		"",
+	} {
+		a := NewAdapter("", "", "", "", WithOnConnect(q))
+		if err := a.Open(context.Background()); err == nil {
+			a.Close()
+			t.Errorf("Open with WithOnConnect(%q): err = nil", q)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+// WithPackedGrouping stores the grouping rules of ptypes that have two
+// values, a subject and a role, packed into <table>_packed: one row per
+// ptype and subject, holding the subject in v0 and its roles in a TEXT[]
+// v1, so millions of g edges take a row per subject instead of one per
+// edge. Reads expand each row into a rule per role, so the loads, EachPolicy
+// and the other reads see the rules as if they were stored one per row;
+// rules of other ptypes, or of other lengths, stay in the policy table. A
+// subject holds each role once, so adding a packed rule twice stores it
+// once. The price is query flexibility: outside the adapter, the roles of a
+// subject are an array to unnest.
+//
+// AddPolicy, RemovePolicy, RemoveFilteredPolicy and SavePolicy write packed
+// rules; the other methods writing rules of a single ptype fail for these
+// ptypes, as do ArchiveTable, LoadIncremental and CopyTo into a packing
+// adapter. Open fails if a ptype is not a grouping ptype, and with
+// WithTenantFromContext, WithSoftDelete, WithSectionTables,
+// WithSectionColumn, WithArrayColumns, WithLoadPageSize, WithReadFrom,
+// WithSnapshotOnSave, WithRuleHash or WithIDColumn, and, unless read-only,
+// on a server before 9.5, whose inserts lack the ON CONFLICT DO UPDATE
+// packing relies on.
+func WithPackedGrouping(ptypes ...string) Option {
+	return func(a *Adapter) {
+		a.packed = append(a.packed, ptypes...)
+	}
+}
+
+// checkPacked validates the options given to WithPackedGrouping.
+func (a *Adapter) checkPacked() error {
+	if len(a.packed) == 0 {
+		return nil
+	}
+	for _, ptype := range a.packed {
+		if err := checkSection("g", ptype); err != nil {
+			return err
+		}
+	}
+	for _, conflict := range []struct {
+		option string
+		set    bool
+	}{
+		{"WithTenantFromContext", a.tenantFunc != nil},
+		{"WithSoftDelete", a.softDelete},
+		{"WithSectionTables", a.sectionTables != nil},
+		{"WithSectionColumn", a.secColumn},
+		{"WithArrayColumns", len(a.arrayCols) > 0},
+		{"WithLoadPageSize", a.pageSize > 0},
+		{"WithReadFrom", a.readFrom != ""},
+		{"WithSnapshotOnSave", a.snapshotOnSave},
+		// source has no rule_hash or id for the expanded rules to match on.
+		{"WithRuleHash", a.ruleHash},
+		{"WithIDColumn", a.idColumn},
+	} {
+		if conflict.set {
+			return fmt.Errorf("adapter: WithPackedGrouping cannot be combined with %s", conflict.option)
+		}
+	}
+	return nil
+}
+
+func errPacked(method string) error {
+	return fmt.Errorf("adapter: %s does not support the ptypes of WithPackedGrouping", method)
+}
+
+// isPacked reports whether ptype is one of WithPackedGrouping.
+func (a *Adapter) isPacked(ptype string) bool {
+	for _, p := range a.packed {
+		if p == ptype {
+			return true
+		}
+	}
+	return false
+}
+
+// packable reports whether line is stored in the packed table: a rule of a
+// packed ptype with a subject and a role and no other values.
+func (a *Adapter) packable(line CasbinRule) bool {
+	return a.isPacked(line.PType) && line.V0 != "" && line.V1 != "" &&
+		line.V2 == "" && line.V3 == "" && line.V4 == "" && line.V5 == ""
+}
+
+func (a *Adapter) packedTable() string {
+	return a.table + "_packed"
+}
+
+func (a *Adapter) createPackedTable(db orm.DB) error {
+	p, v0, v1 := a.ptypeCol(), a.valueCol(0), a.valueCol(1)
+	return ddl(db, "CREATE TABLE IF NOT EXISTS "+a.packedTable()+" ("+
+		p+" VARCHAR(32) NOT NULL, "+v0+" VARCHAR(256) NOT NULL, "+v1+" TEXT[] NOT NULL, "+
+		"PRIMARY KEY ("+p+", "+v0+"))")
+}
+
+// source returns the relation reads select from: the table, or under
+// WithPackedGrouping the table together with the packed rules, one row per
+// role, under the table's name.
+func (a *Adapter) source() string {
+	if len(a.packed) == 0 {
+		return a.table
+	}
+	return "(SELECT " + strings.Join(a.cols, ", ") + " FROM " + a.table + " UNION ALL " +
+		"SELECT " + a.ptypeCol() + ", " + a.valueCol(0) + ", unnest(" + a.valueCol(1) + "), '', '', '', '' FROM " + a.packedTable() +
+		") AS " + a.table
+}
+
+// changePacked makes the change c to the packed table with fn, which
+// returns the number of rules it added or removed, and records c, in one
+// transaction.
+func (a *Adapter) changePacked(ctx context.Context, c Change, fn func(tx *pg.Tx) (int, error)) error {
+	if err := a.flushWrites(ctx); err != nil {
+		return err
+	}
+	var n int
+	err := a.runInTx(ctx, func(tx *pg.Tx) error {
+		var err error
+		if n, err = fn(tx); err != nil {
+			return err
+		}
+		return a.record(ctx, tx, c)
+	})
+	if err != nil {
+		return err
+	}
+	a.observeRows(c.Op, n)
+	return nil
+}
+
+// addPacked adds the role of line to those of its subject, unless the
+// subject holds it already, and reports whether it did.
+func (a *Adapter) addPacked(ctx context.Context, db orm.DB, line CasbinRule) (int, error) {
+	p, v0, v1 := a.ptypeCol(), a.valueCol(0), a.valueCol(1)
+	res, err := db.Exec(a.tag(ctx, "INSERT INTO "+a.packedTable()+" AS t ("+p+", "+v0+", "+v1+") VALUES (?, ?, ARRAY[?]::TEXT[]) "+
+		"ON CONFLICT ("+p+", "+v0+") DO UPDATE SET "+v1+" = t."+v1+" || EXCLUDED."+v1+" WHERE NOT EXCLUDED."+v1+" <@ t."+v1),
+		line.PType, line.V0, line.V1)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
+
+// removePacked removes the packed rules of ptype with the given subject and
+// role, "" matching any, and returns how many it removed. Subjects left
+// without roles are deleted.
+func (a *Adapter) removePacked(ctx context.Context, db orm.DB, ptype, subject, role string) (int, error) {
+	v0, v1 := a.valueCol(0), a.valueCol(1)
+	where := a.ptypeCol() + " = ?"
+	params := []interface{}{ptype}
+	if subject != "" {
+		where += " AND " + v0 + " = ?"
+		params = append(params, subject)
+	}
+
+	var n int
+	if role == "" {
+		_, err := db.QueryOne(pg.Scan(&n), a.tag(ctx, "WITH d AS (DELETE FROM "+a.packedTable()+" WHERE "+where+" RETURNING cardinality("+v1+") AS n) "+
+			"SELECT coalesce(sum(n), 0) FROM d"), params...)
+		return n, err
+	}
+	_, err := db.QueryOne(pg.Scan(&n), a.tag(ctx, "WITH u AS (UPDATE "+a.packedTable()+" SET "+v1+" = array_remove("+v1+", ?) "+
+		"WHERE "+where+" AND ? = ANY("+v1+") RETURNING 1) SELECT count(*) FROM u"), append(append([]interface{}{role}, params...), role)...)
+	if err != nil || n == 0 {
+		return n, err
+	}
+	_, err = db.Exec(a.tag(ctx, "DELETE FROM "+a.packedTable()+" WHERE "+where+" AND cardinality("+v1+") = 0"), params...)
+	return n, err
+}
+
+// subjectRoles are the roles of a subject of a packed ptype, in a SavePolicy
+// of packed rules.
+type subjectRoles struct {
+	ptype, subject string
+	roles          []string
+}
+
+// packRules groups the packable lines by ptype and subject, in the order
+// they first appear, dropping repeated roles.
+func packRules(lines []CasbinRule) []*subjectRoles {
+	type key struct{ ptype, subject string }
+	var packed []*subjectRoles
+	bySubject := make(map[key]*subjectRoles)
+	seen := make(map[CasbinRule]bool)
+	for _, line := range lines {
+		if seen[line] {
+			continue
+		}
+		seen[line] = true
+		k := key{line.PType, line.V0}
+		s, ok := bySubject[k]
+		if !ok {
+			s = &subjectRoles{ptype: line.PType, subject: line.V0}
+			bySubject[k] = s
+			packed = append(packed, s)
+		}
+		s.roles = append(s.roles, line.V1)
+	}
+	return packed
+}
+
+// savePacked replaces the packed rules with those of lines in tx.
+func (a *Adapter) savePacked(ctx context.Context, tx *pg.Tx, lines []CasbinRule) error {
+	if _, err := tx.Exec(a.tag(ctx, "TRUNCATE TABLE "+a.packedTable())); err != nil {
+		return err
+	}
+	query := a.tag(ctx, "INSERT INTO "+a.packedTable()+" ("+a.ptypeCol()+", "+a.valueCol(0)+", "+a.valueCol(1)+") VALUES (?, ?, ?)")
+	for _, s := range packRules(lines) {
+		if _, err := tx.Exec(query, s.ptype, s.subject, pg.Array(s.roles)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/casbin/casbin"
+	"github.com/go-pg/pg/v10"
+)
+
+// packedRows returns the number of rows of x_policy and of x_policy_packed.
+func packedRows(t *testing.T, a *Adapter) (rows, packed int) {
+	t.Helper()
+	if _, err := a.db.QueryOne(pg.Scan(&rows, &packed), "SELECT (SELECT count(*) FROM x_policy), (SELECT count(*) FROM x_policy_packed)"); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	return rows, packed
+}
+
+func TestPackedGrouping(t *testing.T) {
+	a := newTestAdapter(t, WithPackedGrouping("g"))
+	m := newTestModel()
+	for i := 0; i < 500; i++ {
+		role := fmt.Sprintf("role%d", i)
+		m.AddPolicy("g", "g", []string{"alice", role})
+		m.AddPolicy("p", "p", []string{role, fmt.Sprintf("data%d", i), "read"})
+	}
+	m.AddPolicy("g", "g", []string{"bob", "role7"})
+	if err := a.SavePolicy(m); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	if rows, packed := packedRows(t, a); rows != 503 || packed != 2 {
+		t.Errorf("stored %d rows and %d packed rows, want the 503 p rules and a row each for alice and bob", rows, packed)
+	}
+
+	loaded := newTestModel()
+	loaded.ClearPolicy()
+	if err := a.LoadPolicy(loaded); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if got, want := sortedRules(loaded.GetPolicy("g", "g")), sortedRules(m.GetPolicy("g", "g")); !reflect.DeepEqual(got, want) {
+		t.Errorf("loaded %d g rules, want the %d saved", len(got), len(want))
+	}
+	if got, want := sortedRules(loaded.GetPolicy("p", "p")), sortedRules(m.GetPolicy("p", "p")); !reflect.DeepEqual(got, want) {
+		t.Errorf("loaded %d p rules, want the %d saved", len(got), len(want))
+	}
+
+	e := casbin.NewEnforcer(casbin.NewModel(testModelText), a)
+	if got := len(e.GetRolesForUser("alice")); got != 501 {
+		t.Errorf("alice has %d roles, want 501", got)
+	}
+	for _, req := range []struct {
+		sub, obj string
+		want     bool
+	}{
+		{"alice", "data0", true},
+		{"alice", "data499", true},
+		{"alice", "data2", true},
+		{"bob", "data7", true},
+		{"bob", "data8", false},
+	} {
+		if got := e.Enforce(req.sub, req.obj, "read"); got != req.want {
+			t.Errorf("Enforce(%s, %s, read) = %v, want %v", req.sub, req.obj, got, req.want)
+		}
+	}
+
+	e.AddGroupingPolicy("bob", "role8")
+	e.AddGroupingPolicy("bob", "role8")
+	e.RemoveGroupingPolicy("alice", "role0")
+	e.RemoveFilteredGroupingPolicy(1, "role7")
+	e.RemoveFilteredGroupingPolicy(0, "alice", "role499")
+
+	reloaded := casbin.NewEnforcer(casbin.NewModel(testModelText), a)
+	if got, want := sortedRules(reloaded.GetGroupingPolicy()), sortedRules(e.GetGroupingPolicy()); !reflect.DeepEqual(got, want) {
+		t.Errorf("reloaded %d g rules, want the enforcer's %d", len(got), len(want))
+	}
+	for _, req := range []struct {
+		sub, obj string
+		want     bool
+	}{
+		{"alice", "data0", false},
+		{"alice", "data7", false},
+		{"alice", "data499", false},
+		{"alice", "data1", true},
+		{"bob", "data7", false},
+		{"bob", "data8", true},
+	} {
+		if got := reloaded.Enforce(req.sub, req.obj, "read"); got != req.want {
+			t.Errorf("reloaded Enforce(%s, %s, read) = %v, want %v", req.sub, req.obj, got, req.want)
+		}
+	}
+
+	e.RemoveFilteredGroupingPolicy(0, "bob")
+	if _, packed := packedRows(t, a); packed != 1 {
+		t.Errorf("%d packed rows after removing bob's roles, want alice's only", packed)
+	}
+}
+
+func TestPackedGroupingUnsupported(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAdapter(t, WithPackedGrouping("g"))
+	if err := a.UpdatePolicy("g", "g", []string{"alice", "admin"}, []string{"alice", "root"}); err == nil {
+		t.Error("UpdatePolicy of a packed ptype: err = nil")
+	}
+	if _, _, err := a.AddPolicyIfNotExists(ctx, "g", []string{"alice", "admin"}); err == nil {
+		t.Error("AddPolicyIfNotExists of a packed ptype: err = nil")
+	}
+	if err := a.UpdatePolicy("p", "p", []string{"alice", "data1", "read"}, []string{"alice", "data1", "write"}); err != nil {
+		t.Errorf("UpdatePolicy of an unpacked ptype: %v", err)
+	}
+}
+
+func TestPackedGroupingInvalid(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"policy ptype":        {WithPackedGrouping("p")},
+		"with soft delete":    {WithPackedGrouping("g"), WithSoftDelete()},
+		"with page size":      {WithPackedGrouping("g"), WithLoadPageSize(100)},
+		"with section column": {WithPackedGrouping("g"), WithSectionColumn()},
+	} {
+		a := NewAdapter("", "", "", "", opts...)
+		if err := a.Open(context.Background()); err == nil {
+			a.Close()
+			t.Errorf("Open %s: err = nil", name)
+		}
+	}
+}
+
+func TestPackedGroupingMatchColumns(t *testing.T) {
+	for option, opt := range map[string]Option{
+		"WithRuleHash": WithRuleHash(),
+		"WithIDColumn": WithIDColumn(),
+	} {
+		err := NewAdapter("", "", "", "", WithPackedGrouping("g"), opt).checkPacked()
+		if want := "adapter: WithPackedGrouping cannot be combined with " + option; err == nil || err.Error() != want {
+			t.Errorf("checkPacked with %s: err = %v, want %q", option, err, want)
+		}
+	}
+}
+
+func TestPackRules(t *testing.T) {
+	lines := []CasbinRule{
+		{PType: "g", V0: "alice", V1: "admin"},
+		{PType: "g", V0: "bob", V1: "reader"},
+		{PType: "g", V0: "alice", V1: "reader"},
+		{PType: "g", V0: "alice", V1: "admin"},
+		{PType: "g2", V0: "alice", V1: "group"},
+	}
+	var got []subjectRoles
+	for _, s := range packRules(lines) {
+		got = append(got, *s)
+	}
+	want := []subjectRoles{
+		{ptype: "g", subject: "alice", roles: []string{"admin", "reader"}},
+		{ptype: "g", subject: "bob", roles: []string{"reader"}},
+		{ptype: "g2", subject: "alice", roles: []string{"group"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("packRules = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-pg/pg/v10/orm"
+)
+
+// WithLoadPageSize makes LoadPolicy, LoadFilteredPolicy and
+// LoadPolicyWithContext read the table n rows at a time, ordered by an id
+// column and each page continuing after the last id of the one before, so
+// that no single query has to produce a table of tens of millions of rows
+// and a page that fails with a retryable error is fetched again under
+// WithMaxRetries instead of restarting the load. Rules then load in id
+// order. As with AddPolicyReturningID, the table must have an id column,
+// from WithIDColumn or one such as id BIGSERIAL PRIMARY KEY added by hand,
+// for the pages to use its index.
+// An n below 1 turns paging off.
+func WithLoadPageSize(n int) Option {
+	return func(a *Adapter) {
+		a.pageSize = n
+	}
+}
+
+// pagedRow is a row read by pagedRules, with its id.
+type pagedRow struct {
+	CasbinRule
+	ID int64
+}
+
+// pagedRules is selectRules fetching the rows a page at a time, in id order.
+func (a *Adapter) pagedRules(ctx context.Context, where string, params ...interface{}) ([]CasbinRule, error) {
+	if a.sectionTables != nil {
+		var lines []CasbinRule
+		for _, b := range a.sections() {
+			section, err := b.pagedRules(ctx, where, params...)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, section...)
+		}
+		return lines, nil
+	}
+	if a.strictSchema {
+		if err := a.checkUnknownColumns(ctx); err != nil {
+			return nil, err
+		}
+	}
+	where, params, err := a.scope(ctx, where, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	cond := "id > ?"
+	if where != "" {
+		cond = where + " AND " + cond
+	}
+	query := a.tag(ctx, a.unorderedQuery(cond, "id")+fmt.Sprintf(" ORDER BY id LIMIT %d", a.pageSize))
+
+	var lines []CasbinRule
+	var last int64
+	for {
+		var page []pagedRow
+		fetch := func() error {
+			return a.withTenantSetting(ctx, func(db orm.DB) error {
+				page = nil
+				_, err := db.Query(&page, query, append(params, last)...)
+				return err
+			})
+		}
+		if a.rlsSetting == "" {
+			err = a.retry(fetch)
+		} else {
+			// runInTx retries the transaction withTenantSetting runs in.
+			err = fetch()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, row := range page {
+			lines = append(lines, row.CasbinRule)
+		}
+		if len(page) < a.pageSize {
+			return lines, nil
+		}
+		last = page[len(page)-1].ID
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func sortedRules(rules [][]string) [][]string {
+	sorted := append([][]string(nil), rules...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return strings.Join(sorted[i], ",") < strings.Join(sorted[j], ",")
+	})
+	return sorted
+}
+
+func TestLoadPageSize(t *testing.T) {
+	hook := &recordingHook{}
+	a := newTestAdapter(t, WithLoadPageSize(7), WithQueryHook(hook))
+	a.open()
+	defer a.close()
+	if _, err := a.db.Exec("ALTER TABLE x_policy ADD COLUMN id BIGSERIAL PRIMARY KEY"); err != nil {
+		t.Fatalf("adding id column: %v", err)
+	}
+
+	want := newBenchModel(100)
+	want.AddPolicy("g", "g", []string{"user1", "admin"})
+	if err := a.SavePolicy(want); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	hook.mu.Lock()
+	hook.queries = nil
+	hook.mu.Unlock()
+	m := newBenchModel(0)
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	for _, sec := range []string{"p", "g"} {
+		if got, want := sortedRules(m.GetPolicy(sec, sec)), sortedRules(want.GetPolicy(sec, sec)); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s loaded %d rules, want %d", sec, len(got), len(want))
+		}
+	}
+	if pages := len(hook.queries); pages != 15 {
+		t.Errorf("loaded 101 rules in %d queries, want 15 pages of 7", pages)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import "strings"
+
+// WithValuePlaceholder stores placeholder in place of each empty value of a
+// rule, so that a value left empty on purpose, as in "p, alice, , read",
+// is told apart from the unused columns past the end of the rule, which
+// stay empty. Without it, empty values are dropped on load and the rule
+// comes back shorter than it was written. Rules load, and are handed to
+// callbacks, audit records and the watcher, with the placeholder turned
+// back into ""; hooks of WithBeforeInsert see the row as written, and the
+// values of Filter and of the filtered removes match placeholder, not "",
+// since "" there matches anything. placeholder must not be a value of any
+// rule, and changing it leaves the rules already stored with the old one.
+func WithValuePlaceholder(placeholder string) Option {
+	return func(a *Adapter) {
+		a.placeholder = placeholder
+		a.hasPlaceholder = true
+	}
+}
+
+// placeValues returns rule with its empty values replaced by the
+// placeholder of WithValuePlaceholder, copying rule rather than changing
+// it, since it may belong to the caller's model.
+func (a *Adapter) placeValues(rule []string) []string {
+	if !a.hasPlaceholder {
+		return rule
+	}
+	placed := make([]string, len(rule))
+	for i, v := range rule {
+		if v == "" {
+			v = a.placeholder
+		}
+		placed[i] = v
+	}
+	return placed
+}
+
+// lineRule is the package lineRule with the placeholder of
+// WithValuePlaceholder turned back into "".
+func (a *Adapter) lineRule(line CasbinRule) []string {
+	rule := lineRule(line)
+	if a.hasPlaceholder {
+		for i, v := range rule {
+			if v == a.placeholder {
+				rule[i] = ""
+			}
+		}
+	}
+	return rule
+}
+
+// lineText is policyLineText with the rule of a.lineRule, the form in
+// which rules are loaded into models.
+func (a *Adapter) lineText(line CasbinRule) string {
+	return strings.Join(append([]string{line.PType}, a.lineRule(line)...), ", ")
+}
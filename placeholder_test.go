@@ -0,0 +1,74 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/casbin/casbin/model"
+)
+
+func TestValuePlaceholder(t *testing.T) {
+	a := newTestAdapter(t, WithValuePlaceholder("<empty>"))
+
+	m := make(model.Model)
+	m.LoadModelFromText(testModelText)
+	m.AddPolicy("p", "p", []string{"alice", "", "read"})
+	m.AddPolicy("p", "p", []string{"bob", "data2", ""})
+	if err := a.SavePolicy(m); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	if err := a.AddPolicy("p", "p", []string{"", "data3", "write"}); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+
+	lines, err := a.selectRules(context.Background(), "")
+	if err != nil {
+		t.Fatalf("selectRules: %v", err)
+	}
+	if len(lines) != 3 || lines[0].V1 != "<empty>" || lines[0].V3 != "" {
+		t.Errorf("stored rows %+v, want the placeholder in v1 of the first and v3 left empty", lines)
+	}
+
+	loaded := make(model.Model)
+	loaded.LoadModelFromText(testModelText)
+	if err := a.LoadPolicy(loaded); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	want := [][]string{{"alice", "", "read"}, {"bob", "data2", ""}, {"", "data3", "write"}}
+	if got := loaded["p"]["p"].Policy; !reflect.DeepEqual(got, want) {
+		t.Errorf("loaded %q, want %q", got, want)
+	}
+
+	if err := a.RemovePolicy("p", "p", []string{"alice", "", "read"}); err != nil {
+		t.Fatalf("RemovePolicy: %v", err)
+	}
+	policies, err := a.GetAllPolicies(context.Background())
+	if err != nil {
+		t.Fatalf("GetAllPolicies: %v", err)
+	}
+	if want := [][]string{{"bob", "data2", ""}, {"", "data3", "write"}}; !reflect.DeepEqual(policies["p"], want) {
+		t.Errorf("after RemovePolicy %q, want %q", policies["p"], want)
+	}
+}
+
+func TestValuePlaceholderEmpty(t *testing.T) {
+	a := NewAdapter("", "", "", "", WithValuePlaceholder(""))
+	if err := a.Open(context.Background()); err == nil {
+		t.Fatal("Open accepted an empty placeholder")
+	}
+}
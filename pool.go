@@ -0,0 +1,68 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import "sync"
+
+// insertScratch holds the row of an insert and its parameters, which point
+// into it, so that AddPolicy builds them without allocating. It goes back to
+// insertScratchPool once the statement has run.
+type insertScratch struct {
+	line   CasbinRule
+	tenant string
+	sec    string
+	params []interface{}
+}
+
+var insertScratchPool = sync.Pool{
+	New: func() interface{} {
+		return &insertScratch{params: make([]interface{}, 0, 11)}
+	},
+}
+
+// pooledInsertParams returns the parameters of insertQuery for line, owned by
+// tenant, as insertParams does, in scratch from insertScratchPool. The
+// parameters are only valid until releaseInsertParams.
+func (a *Adapter) pooledInsertParams(line CasbinRule, tenant string) *insertScratch {
+	s := insertScratchPool.Get().(*insertScratch)
+	if a.nullUnused || len(a.arrayCols) > 0 {
+		s.params = append(s.params[:0], a.insertParams(line, tenant)...)
+		return s
+	}
+
+	s.line, s.tenant, s.sec = line, tenant, ptypeSection(line.PType)
+	l := &s.line
+	s.params = append(s.params[:0], &l.PType, &l.V0, &l.V1, &l.V2, &l.V3, &l.V4, &l.V5)
+	if a.tenantFunc != nil {
+		s.params = append(s.params, &s.tenant)
+	}
+	if a.secColumn {
+		s.params = append(s.params, &s.sec)
+	}
+	if a.timestamps && a.clock != nil {
+		now := a.clock()
+		s.params = append(s.params, now, now)
+	}
+	return s
+}
+
+// releaseInsertParams returns s to insertScratchPool.
+func releaseInsertParams(s *insertScratch) {
+	for i := range s.params {
+		s.params[i] = nil
+	}
+	s.params = s.params[:0]
+	insertScratchPool.Put(s)
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// derefParams returns params with the string pointers of
+// pooledInsertParams replaced by their strings.
+func derefParams(params []interface{}) []interface{} {
+	out := make([]interface{}, len(params))
+	for i, p := range params {
+		if s, ok := p.(*string); ok {
+			out[i] = *s
+		} else {
+			out[i] = p
+		}
+	}
+	return out
+}
+
+func TestPooledInsertParams(t *testing.T) {
+	at := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	line := savePolicyLine("p", []string{"alice", "data1", "read"})
+	for _, opts := range [][]Option{
+		nil,
+		{WithTenantFromContext(tenantFromContext), WithSectionColumn()},
+		{WithTimestamps(), WithClock(func() time.Time { return at })},
+		// WithArrayColumns is left out: it takes the insertParams path, and
+		// the pg.Array values it holds do not compare with DeepEqual.
+		{WithNullUnusedColumns()},
+	} {
+		a := NewAdapter("", "", "", "", opts...)
+		want := a.insertParams(line, "acme")
+		for i := 0; i < 2; i++ {
+			s := a.pooledInsertParams(line, "acme")
+			if got := derefParams(s.params); !reflect.DeepEqual(got, want) {
+				t.Errorf("pooledInsertParams = %v, want %v", got, want)
+			}
+			releaseInsertParams(s)
+		}
+	}
+}
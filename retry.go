@@ -0,0 +1,80 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// WithMaxRetries makes the adapter run a transaction up to n more times when
+// it fails with an error the retry classifier accepts, IsRetryable by
+// default. The whole transaction is retried, so partial work is never
+// committed twice.
+func WithMaxRetries(n int) Option {
+	return func(a *Adapter) {
+		a.maxRetries = n
+	}
+}
+
+// WithRetryClassifier replaces IsRetryable as the test for which errors are
+// retried under WithMaxRetries.
+func WithRetryClassifier(retryable func(err error) bool) Option {
+	return func(a *Adapter) {
+		a.retryable = retryable
+	}
+}
+
+// IsRetryable reports whether err is worth retrying: a serialization failure
+// or deadlock (SQLSTATE 40001, 40P01), a connection exception (class 08), or
+// a network timeout or reset. Everything else, constraint violations
+// included, is treated as fatal.
+func IsRetryable(err error) bool {
+	if pgErr, ok := err.(pg.Error); ok {
+		code := pgErr.Field('C')
+		return code == "40001" || code == "40P01" || strings.HasPrefix(code, "08")
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+		if sysErr, ok := err.(*os.SyscallError); ok {
+			err = sysErr.Err
+		}
+	}
+	return err == io.EOF || err == io.ErrUnexpectedEOF || err == syscall.ECONNRESET || err == syscall.EPIPE
+}
+
+// retry calls fn until it succeeds, fails with an error that is not
+// retryable, or has been retried maxRetries times.
+func (a *Adapter) retry(fn func() error) error {
+	retryable := a.retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= a.maxRetries || !retryable(err) {
+			return err
+		}
+	}
+}
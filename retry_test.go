@@ -0,0 +1,72 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestRetryDefaultClassifier(t *testing.T) {
+	a := NewAdapter("", "", "", "", WithMaxRetries(3))
+
+	calls := 0
+	err := a.retry(func() error {
+		calls++
+		if calls < 3 {
+			return io.ErrUnexpectedEOF
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("retry = %v after %d calls, want success after 3", err, calls)
+	}
+
+	fatal := errors.New("duplicate key")
+	calls = 0
+	err = a.retry(func() error {
+		calls++
+		return fatal
+	})
+	if err != fatal || calls != 1 {
+		t.Errorf("retry = %v after %d calls, want fatal error after 1", err, calls)
+	}
+}
+
+func TestRetryCustomClassifier(t *testing.T) {
+	fatal := errors.New("duplicate key")
+	a := NewAdapter("", "", "", "", WithMaxRetries(2), WithRetryClassifier(func(err error) bool {
+		return err == fatal
+	}))
+
+	calls := 0
+	err := a.retry(func() error {
+		calls++
+		return fatal
+	})
+	if err != fatal || calls != 3 {
+		t.Errorf("retry = %v after %d calls, want fatal error after 3", err, calls)
+	}
+
+	calls = 0
+	err = a.retry(func() error {
+		calls++
+		return io.ErrUnexpectedEOF
+	})
+	if err != io.ErrUnexpectedEOF || calls != 1 {
+		t.Errorf("retry = %v after %d calls, want no retries", err, calls)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+// WithRowLevelSecurity leaves tenant isolation to Postgres row-level
+// security instead of the adapter's WHERE clauses. Every operation runs in a
+// transaction that first sets setting, "app.tenant_id" say, to the tenant of
+// WithTenantFromContext, as SET LOCAL would, and the adapter's queries no
+// longer mention the tenant column. Rules are still written with their
+// tenant, for the policy's WITH CHECK to verify. It requires
+// WithTenantFromContext; an adapter bound by WithTx sets the setting in the
+// caller's transaction.
+//
+// The adapter does not define the policy. Once the table exists, enable RLS
+// on it and add a policy reading the setting:
+//
+//	ALTER TABLE x_policy ENABLE ROW LEVEL SECURITY;
+//	CREATE POLICY x_policy_tenant ON x_policy
+//		USING (tenant = current_setting('app.tenant_id'))
+//		WITH CHECK (tenant = current_setting('app.tenant_id'));
+//
+// Superusers, roles with BYPASSRLS and the table owner are exempt from RLS;
+// connect as another role, or add ALTER TABLE x_policy FORCE ROW LEVEL
+// SECURITY to subject the owner too. SavePolicy clears the tenant's rules
+// with DELETE, to which RLS applies. CopyTo is not supported.
+func WithRowLevelSecurity(setting string) Option {
+	return func(a *Adapter) {
+		a.rlsSetting = setting
+	}
+}
+
+// setTenant sets the row-level security setting to the tenant of ctx for
+// the rest of tx.
+func (a *Adapter) setTenant(ctx context.Context, tx *pg.Tx) error {
+	tenant, err := a.tenant(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("SELECT set_config(?, ?, true)", a.rlsSetting, tenant)
+	return err
+}
+
+// withTenantSetting calls fn with what the adapter's queries run on, which
+// under WithRowLevelSecurity is a transaction with the setting set.
+func (a *Adapter) withTenantSetting(ctx context.Context, fn func(db orm.DB) error) error {
+	if a.rlsSetting == "" {
+		return fn(a.conn(ctx))
+	}
+	return a.runInTx(ctx, func(tx *pg.Tx) error {
+		return fn(tx)
+	})
+}
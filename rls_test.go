@@ -0,0 +1,109 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+)
+
+func TestRowLevelSecurity(t *testing.T) {
+	owner := newTestAdapter(t, WithTenantFromContext(tenantFromContext))
+	owner.open()
+	defer owner.close()
+
+	// Superusers bypass RLS, so the adapter under test runs as a plain role
+	// that owns the table, and FORCE subjects the owner to the policy.
+	if _, err := owner.db.Exec("DO $$ BEGIN CREATE ROLE casbin_rls; EXCEPTION WHEN duplicate_object THEN NULL; END $$"); err != nil {
+		t.Skipf("cannot create a role: %v", err)
+	}
+	defer func() {
+		owner.db.Exec("DROP TABLE IF EXISTS x_policy")
+		owner.db.Exec("DROP OWNED BY casbin_rls")
+		owner.db.Exec("DROP ROLE casbin_rls")
+	}()
+	for _, query := range []string{
+		"DO $$ BEGIN EXECUTE format('GRANT USAGE, CREATE ON SCHEMA %I TO casbin_rls', current_schema()); END $$",
+		"ALTER TABLE x_policy OWNER TO casbin_rls",
+		"ALTER TABLE x_policy ENABLE ROW LEVEL SECURITY",
+		"ALTER TABLE x_policy FORCE ROW LEVEL SECURITY",
+		"CREATE POLICY x_policy_tenant ON x_policy " +
+			"USING (tenant = current_setting('app.tenant_id')) " +
+			"WITH CHECK (tenant = current_setting('app.tenant_id'))",
+	} {
+		if _, err := owner.db.Exec(query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+
+	hook := &recordingHook{}
+	a := newAdapter(pg.Options{
+		User:     getenv("PG_USER", "postgres"),
+		Password: getenv("PG_PASSWORD", ""),
+		Database: getenv("PG_DATABASE", "casbin"),
+		Addr:     normalizeAddr(getenv("PG_ADDR", "localhost:5432")),
+		OnConnect: func(ctx context.Context, cn *pg.Conn) error {
+			_, err := cn.Exec("SET ROLE casbin_rls")
+			return err
+		},
+	}, WithTenantFromContext(tenantFromContext), WithRowLevelSecurity("app.tenant_id"), WithQueryHook(hook))
+	defer a.close()
+
+	acme := context.WithValue(context.Background(), tenantKey{}, "acme")
+	globex := context.WithValue(context.Background(), tenantKey{}, "globex")
+	if err := a.WithContext(acme).AddPolicy("p", "p", []string{"alice", "data1", "read"}); err != nil {
+		t.Fatalf("AddPolicy for acme: %v", err)
+	}
+	if err := a.WithContext(globex).AddPolicy("p", "p", []string{"bob", "data2", "write"}); err != nil {
+		t.Fatalf("AddPolicy for globex: %v", err)
+	}
+	if err := a.WithContext(globex).RemoveFilteredPolicy("p", "p", 0, "alice"); err != nil {
+		t.Fatalf("RemoveFilteredPolicy for globex: %v", err)
+	}
+
+	for ctx, want := range map[context.Context]string{acme: "alice", globex: "bob"} {
+		policies, err := a.GetAllPolicies(ctx)
+		if err != nil {
+			t.Fatalf("GetAllPolicies: %v", err)
+		}
+		if p := policies["p"]; len(p) != 1 || p[0][0] != want {
+			t.Errorf("tenant sees %q, want only %s's rule", p, want)
+		}
+	}
+	if hook.saw("tenant = ") {
+		t.Errorf("adapter filtered on the tenant itself; want RLS to do it")
+	}
+
+	var total int
+	if _, err := owner.db.QueryOne(pg.Scan(&total), "SELECT count(*) FROM x_policy"); err != nil {
+		t.Fatalf("counting rows: %v", err)
+	}
+	if total != 2 {
+		t.Errorf("table has %d rows, want 2", total)
+	}
+
+	err := a.db.RunInTransaction(context.Background(), func(tx *pg.Tx) error {
+		if _, err := tx.Exec("SELECT set_config('app.tenant_id', 'acme', true)"); err != nil {
+			return err
+		}
+		_, err := tx.Exec("INSERT INTO x_policy (p_type, v0, tenant) VALUES ('p', 'mallory', 'globex')")
+		return err
+	})
+	if err == nil {
+		t.Errorf("acme wrote a row of globex")
+	}
+}
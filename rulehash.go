@@ -0,0 +1,99 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+// WithRuleHash adds a rule_hash column holding the md5 of each row's ptype
+// and values, NULLs counting as "", with a unique index over it, per tenant
+// under WithTenantFromContext and over the live rows under WithSoftDelete.
+// RemovePolicy, UpdatePolicy and the other methods matching a whole rule
+// then find it through the index whatever its arity, and inserts skip, with
+// ON CONFLICT DO NOTHING on the index unless WithConflictTarget names
+// another target, a rule the table holds already. The column is
+// GENERATED ALWAYS AS ... STORED, so rows written by other clients get it
+// too, which takes PostgreSQL 12 or later; array columns of
+// WithArrayColumns are not supported. Open adds the column and index to an
+// existing table, and fails if the table holds duplicate rules.
+func WithRuleHash() Option {
+	return func(a *Adapter) {
+		a.ruleHash = true
+	}
+}
+
+// ruleHashSep separates the fields hashed into rule_hash, so that rules
+// whose values run together differently do not collide.
+const ruleHashSep = "\x1f"
+
+// createRuleHash adds the rule_hash column and its unique index to the
+// table if it lacks them.
+func (a *Adapter) createRuleHash(db orm.DB) error {
+	if len(a.arrayCols) > 0 {
+		return fmt.Errorf("adapter: WithRuleHash does not support array columns")
+	}
+	if a.serverBefore(120000) {
+		return fmt.Errorf("adapter: WithRuleHash requires PostgreSQL 12 or later, the server runs %s", a.server.Version)
+	}
+
+	fields := make([]string, len(a.cols))
+	for i, col := range a.cols {
+		fields[i] = "coalesce(" + col + ", '')"
+	}
+	expr := "md5(" + strings.Join(fields, " || chr(31) || ") + ")"
+	if err := ddl(db, "ALTER TABLE "+a.table+" ADD COLUMN IF NOT EXISTS rule_hash CHAR(32) GENERATED ALWAYS AS ("+expr+") STORED"); err != nil {
+		return err
+	}
+
+	query := "CREATE UNIQUE INDEX IF NOT EXISTS " + a.table + "_rule_hash_idx ON " + a.table + " " + a.ruleHashIndex()
+	// Not ddl: a unique_violation here means the table holds duplicates.
+	_, err := db.Exec(query)
+	if pgErr, ok := err.(pg.Error); ok {
+		switch pgErr.Field('C') {
+		case "42P07":
+			return nil
+		case "23505":
+			return fmt.Errorf("adapter: WithRuleHash: %s holds duplicate rules", a.table)
+		}
+	}
+	return err
+}
+
+// ruleHashIndex returns the columns and predicate of the unique index of
+// WithRuleHash, in the form both CREATE INDEX and ON CONFLICT take.
+func (a *Adapter) ruleHashIndex() string {
+	index := "(rule_hash)"
+	if a.tenantFunc != nil {
+		index = "(tenant, rule_hash)"
+	}
+	if a.softDelete {
+		index += " WHERE deleted_at IS NULL"
+	}
+	return index
+}
+
+// ruleHashOf returns the rule_hash of line, as the server computes it for a
+// database encoded in UTF-8.
+func ruleHashOf(line CasbinRule) string {
+	sum := md5.Sum([]byte(strings.Join([]string{line.PType, line.V0, line.V1, line.V2, line.V3, line.V4, line.V5}, ruleHashSep)))
+	return hex.EncodeToString(sum[:])
+}
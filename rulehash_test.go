@@ -0,0 +1,87 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+)
+
+func TestRuleHashOf(t *testing.T) {
+	ab := ruleHashOf(CasbinRule{PType: "p", V0: "ab", V1: "c"})
+	if ab != ruleHashOf(CasbinRule{PType: "p", V0: "ab", V1: "c"}) {
+		t.Errorf("ruleHashOf is not deterministic")
+	}
+	if ab == ruleHashOf(CasbinRule{PType: "p", V0: "a", V1: "bc"}) {
+		t.Errorf("ruleHashOf of values running together differently collided")
+	}
+	if len(ab) != 32 {
+		t.Errorf("ruleHashOf = %q, want 32 hex digits", ab)
+	}
+}
+
+func TestRuleHash(t *testing.T) {
+	a := newTestAdapter(t, WithRuleHash(), WithNullUnusedColumns())
+	info, err := a.TestConnection(context.Background())
+	if err != nil {
+		t.Fatalf("TestConnection: %v", err)
+	}
+	if info.VersionNum < 120000 {
+		t.Skipf("PostgreSQL %s lacks generated columns", info.Version)
+	}
+	m := newTestModel()
+	m.AddPolicy("p", "p", []string{"carol", "", "read"})
+	if err := a.SavePolicy(m); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	defer a.close()
+
+	hashless := NewAdapter("", "", "", "", WithNullUnusedColumns())
+	for _, line := range []CasbinRule{
+		savePolicyLine("p", []string{"alice", "data1", "read"}),
+		savePolicyLine("p", []string{"carol", "", "read"}),
+		savePolicyLine("g", []string{"alice", "data2_admin"}),
+		savePolicyLine("g", []string{"alice"}),
+	} {
+		var byHash, byColumns int
+		where, params := a.ruleWhere(line, true)
+		if _, err := a.db.QueryOne(pg.Scan(&byHash), "SELECT count(*) FROM x_policy WHERE "+where, params...); err != nil {
+			t.Fatalf("count by hash: %v", err)
+		}
+		where, params = hashless.ruleWhere(line, true)
+		if _, err := a.db.QueryOne(pg.Scan(&byColumns), "SELECT count(*) FROM x_policy WHERE "+where, params...); err != nil {
+			t.Fatalf("count by columns: %v", err)
+		}
+		if byHash != byColumns {
+			t.Errorf("%+v matches %d rows by hash, %d by columns", line, byHash, byColumns)
+		}
+	}
+
+	if err := a.AddPolicy("p", "p", []string{"alice", "data1", "read"}); err != nil {
+		t.Errorf("AddPolicy of a stored rule: %v", err)
+	}
+	if err := a.RemovePolicy("p", "p", []string{"carol", "", "read"}); err != nil {
+		t.Errorf("RemovePolicy: %v", err)
+	}
+	policies, err := a.GetAllPolicies(context.Background())
+	if err != nil {
+		t.Fatalf("GetAllPolicies: %v", err)
+	}
+	if len(policies["p"]) != 3 {
+		t.Errorf("table holds %q, want the duplicate skipped and carol's rule removed", policies["p"])
+	}
+}
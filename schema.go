@@ -0,0 +1,225 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+)
+
+//go:embed schema.sql
+var schemaSQL string
+
+// SchemaSQL returns the statements creating the policy table, indexes and
+// schema version marker that Open creates under the default options, for
+// migration tools such as golang-migrate to run in its place with
+// WithoutCreateTable. The options changing the table, WithTablePrefix,
+// WithColumnNamer, WithTimestamps and the like, are not reflected;
+// CheckSchema tells whether a table suits them.
+func SchemaSQL() string {
+	return schemaSQL
+}
+
+// WithoutCreateTable keeps Open from creating or altering any table, for
+// schemas managed by migrations, from SchemaSQL say. Unlike WithReadOnly it
+// leaves writes alone. EnsureTable still creates what is missing, as does
+// SavePolicy under SaveModeDrop, which recreates the table.
+func WithoutCreateTable() Option {
+	return func(a *Adapter) {
+		a.noCreate = true
+	}
+}
+
+// WithMinValueWidth sets the fewest characters the value columns, v0 to v5,
+// must hold, for tables created elsewhere with columns too narrow for the
+// values a model produces, which then fail saves with "value too long".
+// CheckSchema accepts a VARCHAR value column of n characters or more, or a
+// TEXT one, in place of the VARCHAR(256) the adapter creates, and reports
+// the narrower ones. The loads log a warning naming them with the logger of
+// WithLogger, if any, and load the rules all the same.
+func WithMinValueWidth(n int) Option {
+	return func(a *Adapter) {
+		a.minWidth = n
+	}
+}
+
+// schemaColumn describes a column as information_schema.columns reports it.
+type schemaColumn struct {
+	ColumnName             string `pg:"column_name"`
+	DataType               string `pg:"data_type"`
+	CharacterMaximumLength int    `pg:"character_maximum_length"`
+}
+
+func (c schemaColumn) String() string {
+	if c.CharacterMaximumLength > 0 {
+		return fmt.Sprintf("%s(%d)", c.DataType, c.CharacterMaximumLength)
+	}
+	return c.DataType
+}
+
+// expectedSchema returns the columns createTable creates under the current
+// options, in expectedColumns order.
+func (a *Adapter) expectedSchema() []schemaColumn {
+	columns := []schemaColumn{{a.ptypeCol(), "character varying", 32}}
+	for i := 0; i < 6; i++ {
+		if a.isArray(i) {
+			columns = append(columns, schemaColumn{a.valueCol(i), "ARRAY", 0})
+		} else {
+			columns = append(columns, schemaColumn{a.valueCol(i), "character varying", 256})
+		}
+	}
+	if a.softDelete {
+		columns = append(columns, schemaColumn{"deleted_at", "timestamp with time zone", 0})
+	}
+	if a.timestamps {
+		columns = append(columns,
+			schemaColumn{"created_at", "timestamp with time zone", 0},
+			schemaColumn{"updated_at", "timestamp with time zone", 0})
+	}
+	if a.tenantFunc != nil {
+		columns = append(columns, schemaColumn{"tenant", "character varying", 256})
+	}
+	if a.secColumn {
+		columns = append(columns, schemaColumn{"sec", "character varying", 1})
+	}
+	if a.idColumn {
+		columns = append(columns, schemaColumn{"id", "bigint", 0})
+	}
+	if a.ruleHash {
+		columns = append(columns, schemaColumn{"rule_hash", "character", 32})
+	}
+	return columns
+}
+
+// CheckSchema compares the columns of the policy table with those the
+// adapter would create under its options, column names from
+// WithColumnNamer included, and returns an error describing every column
+// that is missing or has another type or width. Columns the adapter does not
+// use are not reported; WithStrictSchema rejects those on read. CheckSchema
+// is meant to be called on startup, to catch drift before serving traffic.
+func (a *Adapter) CheckSchema(ctx context.Context) error {
+	a.open()
+	if a.sectionTables != nil {
+		for _, b := range a.sections() {
+			if err := b.CheckSchema(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	have, err := a.tableColumns(ctx)
+	if err != nil {
+		return err
+	}
+	if len(have) == 0 {
+		return fmt.Errorf("adapter: table %s does not exist", a.table)
+	}
+
+	byName := make(map[string]schemaColumn, len(have))
+	for _, c := range have {
+		byName[c.ColumnName] = c
+	}
+
+	var mismatches []string
+	for _, want := range a.expectedSchema() {
+		got, ok := byName[want.ColumnName]
+		if !ok {
+			mismatches = append(mismatches, fmt.Sprintf("column %s is missing", want.ColumnName))
+		} else if a.minWidth > 0 && a.isValueCol(want.ColumnName) && want.DataType == "character varying" {
+			if narrow := a.narrowColumn(got); narrow != "" {
+				mismatches = append(mismatches, narrow)
+			}
+		} else if got != want {
+			mismatches = append(mismatches, fmt.Sprintf("column %s is %s, want %s", want.ColumnName, got, want))
+		}
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("adapter: schema of %s does not match: %s", a.table, strings.Join(mismatches, "; "))
+	}
+	return nil
+}
+
+// tableColumns returns the columns of the policy table.
+func (a *Adapter) tableColumns(ctx context.Context) ([]schemaColumn, error) {
+	var have []schemaColumn
+	_, err := a.conn(ctx).Query(&have, a.tag(ctx,
+		"SELECT column_name, data_type, character_maximum_length FROM information_schema.columns "+
+			"WHERE table_schema = current_schema() AND table_name = ?"), a.table)
+	return have, err
+}
+
+// isValueCol reports whether name is one of the value columns, v0 to v5.
+func (a *Adapter) isValueCol(name string) bool {
+	for i := 0; i < 6; i++ {
+		if a.valueCol(i) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// narrowColumn describes c if it is a value column that holds fewer
+// characters than WithMinValueWidth asks for, or is neither VARCHAR nor
+// TEXT, and returns "" otherwise.
+func (a *Adapter) narrowColumn(c schemaColumn) string {
+	switch {
+	case c.DataType == "text", c.DataType == "character varying" && c.CharacterMaximumLength == 0:
+		return ""
+	case c.DataType != "character varying":
+		return fmt.Sprintf("column %s is %s, want character varying of at least %d characters", c.ColumnName, c, a.minWidth)
+	case c.CharacterMaximumLength < a.minWidth:
+		return fmt.Sprintf("column %s is %s, narrower than the %d characters of WithMinValueWidth", c.ColumnName, c, a.minWidth)
+	}
+	return ""
+}
+
+// warnNarrowColumns logs, for the loads, the value columns of the policy
+// tables narrower than WithMinValueWidth asks for.
+func (a *Adapter) warnNarrowColumns(ctx context.Context) error {
+	for _, b := range a.sections() {
+		have, err := b.tableColumns(ctx)
+		if err != nil {
+			return err
+		}
+		byName := make(map[string]schemaColumn, len(have))
+		for _, c := range have {
+			byName[c.ColumnName] = c
+		}
+		for i := 0; i < 6; i++ {
+			c, ok := byName[b.valueCol(i)]
+			if !ok || b.isArray(i) {
+				continue
+			}
+			if narrow := b.narrowColumn(c); narrow != "" {
+				b.logger.Printf("adapter: warning: %s: %s", b.table, narrow)
+			}
+		}
+	}
+	return nil
+}
+
+// valueTooLong adds to the error of a value too long for its column
+// (SQLSTATE 22001) a pointer to the check that finds narrow columns.
+func valueTooLong(err error) error {
+	if pgErr, ok := err.(pg.Error); ok && pgErr.Field('C') == "22001" {
+		return fmt.Errorf("adapter: a value is longer than its column allows; CheckSchema with WithMinValueWidth reports narrow columns: %w", err)
+	}
+	return err
+}
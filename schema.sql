@@ -0,0 +1,8 @@
+-- The policy table and indexes the adapter creates under its default
+-- options, and the marker recording their schema version; see SchemaSQL.
+-- Options such as WithTimestamps add columns.
+CREATE TABLE IF NOT EXISTS x_policy (p_type VARCHAR(32), v0 VARCHAR(256), v1 VARCHAR(256), v2 VARCHAR(256), v3 VARCHAR(256), v4 VARCHAR(256), v5 VARCHAR(256));
+CREATE INDEX IF NOT EXISTS x_policy_p_type_idx ON x_policy (p_type);
+CREATE INDEX IF NOT EXISTS x_policy_p_type_v0_idx ON x_policy (p_type, v0);
+CREATE TABLE IF NOT EXISTS x_policy_schema_version (id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id), version INT NOT NULL);
+INSERT INTO x_policy_schema_version (version) VALUES (2) ON CONFLICT DO NOTHING;
@@ -0,0 +1,139 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestCheckSchema(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAdapter(t, WithTimestamps())
+	if err := a.CheckSchema(ctx); err != nil {
+		t.Fatalf("CheckSchema on a fresh table: %v", err)
+	}
+
+	if _, err := a.db.Exec("ALTER TABLE x_policy ALTER COLUMN v1 TYPE TEXT"); err != nil {
+		t.Fatalf("ALTER TABLE: %v", err)
+	}
+	if _, err := a.db.Exec("ALTER TABLE x_policy DROP COLUMN updated_at"); err != nil {
+		t.Fatalf("ALTER TABLE: %v", err)
+	}
+
+	err := a.CheckSchema(ctx)
+	if err == nil {
+		t.Fatal("CheckSchema after altering v1: err = nil, want a mismatch")
+	}
+	for _, want := range []string{"column v1 is text, want character varying(256)", "column updated_at is missing"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("CheckSchema error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestMinValueWidth(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	a := newTestAdapter(t, WithMinValueWidth(64), WithLogger(log.New(&buf, "", 0)))
+	a.open()
+	defer a.close()
+	for _, q := range []string{
+		"ALTER TABLE x_policy ALTER COLUMN v0 TYPE TEXT",
+		"ALTER TABLE x_policy ALTER COLUMN v1 TYPE VARCHAR(10)",
+		"ALTER TABLE x_policy ALTER COLUMN v2 TYPE VARCHAR(512)",
+	} {
+		if _, err := a.db.Exec(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+
+	err := a.CheckSchema(ctx)
+	want := "column v1 is character varying(10), narrower than the 64 characters of WithMinValueWidth"
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("CheckSchema: err = %v, want it to mention %q", err, want)
+	}
+	for _, col := range []string{"column v0", "column v2"} {
+		if strings.Contains(err.Error(), col) {
+			t.Errorf("CheckSchema error %q reports %s, which is wide enough", err, col)
+		}
+	}
+
+	m := newTestModel()
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if want := "adapter: warning: x_policy: " + want; !strings.Contains(buf.String(), want) {
+		t.Errorf("load logged %q, want %q", buf.String(), want)
+	}
+
+	m.AddPolicy("p", "p", []string{"alice", strings.Repeat("d", 20), "read"})
+	err = a.SavePolicy(m)
+	if err == nil || !strings.Contains(err.Error(), "CheckSchema") {
+		t.Errorf("SavePolicy of a 20-character value into VARCHAR(10): err = %v, want it to point at CheckSchema", err)
+	}
+}
+
+// indexDefs returns the definitions of the indexes on x_policy.
+func indexDefs(t *testing.T, a *Adapter) []string {
+	t.Helper()
+	var defs []string
+	if _, err := a.db.Query(&defs, "SELECT indexdef FROM pg_indexes WHERE schemaname = current_schema() AND tablename = 'x_policy' ORDER BY indexname"); err != nil {
+		t.Fatalf("pg_indexes: %v", err)
+	}
+	return defs
+}
+
+func TestSchemaSQL(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAdapter(t, WithoutCreateTable())
+	a.open()
+	defer a.close()
+	if err := a.CheckSchema(ctx); err == nil {
+		t.Fatalf("CheckSchema: err = nil, want Open to have created no table")
+	}
+
+	if _, err := a.db.Exec(SchemaSQL()); err != nil {
+		t.Fatalf("running SchemaSQL: %v", err)
+	}
+	if err := a.CheckSchema(ctx); err != nil {
+		t.Errorf("CheckSchema of the table of SchemaSQL: %v", err)
+	}
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	m := newTestModel()
+	m.ClearPolicy()
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if len(m.GetPolicy("p", "p")) != 3 {
+		t.Errorf("loaded %q, want the 3 rules saved", m.GetPolicy("p", "p"))
+	}
+	migrated := indexDefs(t, a)
+
+	if _, err := a.db.Exec("DROP TABLE x_policy"); err != nil {
+		t.Fatalf("DROP TABLE: %v", err)
+	}
+	if err := a.EnsureTable(ctx); err != nil {
+		t.Fatalf("EnsureTable: %v", err)
+	}
+	if created := indexDefs(t, a); strings.Join(created, "\n") != strings.Join(migrated, "\n") {
+		t.Errorf("SchemaSQL creates indexes\n%s\nwant those of EnsureTable\n%s", strings.Join(migrated, "\n"), strings.Join(created, "\n"))
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"fmt"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+// WithSectionTables stores policy rules (section p) in pTable and role rules
+// (section g) in gTable instead of both in x_policy, so that each can be
+// partitioned and indexed on its own; WithTablePrefix prefixes both. Each
+// table gets the columns, constraint and indexes the other options call for.
+// Loads read both tables, p first, and writes go to the table of the rule's
+// section. Index names are unique in a schema, so an index declared with
+// WithIndexes is created on the p table only. ArchiveTable and CopyTo do not
+// support split tables.
+func WithSectionTables(pTable, gTable string) Option {
+	return func(a *Adapter) {
+		a.sectionTables = map[string]string{"p": pTable, "g": gTable}
+	}
+}
+
+// forSection returns the adapter for the table holding the rules of sec:
+// with WithSectionTables, a copy of a bound to that table; otherwise a.
+func (a *Adapter) forSection(sec string) *Adapter {
+	if a.sectionTables == nil {
+		return a
+	}
+	b := *a
+	b.table = a.sectionTables[sec]
+	b.sectionTables = nil
+	return &b
+}
+
+// sections returns the adapters of the tables a stores rules in, p first.
+func (a *Adapter) sections() []*Adapter {
+	if a.sectionTables == nil {
+		return []*Adapter{a}
+	}
+	return []*Adapter{a.forSection("p"), a.forSection("g")}
+}
+
+func errSectionTables(method string) error {
+	return fmt.Errorf("adapter: %s does not support WithSectionTables", method)
+}
+
+// WithSectionColumn stores the section of each rule, "p" or "g", in a sec
+// column, for queries to filter on without parsing ptypes, and reads it back
+// into CasbinRule.Sec. Open adds the column to an existing table, fills it in
+// for the rows already there and adds a CHECK constraint named
+// <table>_sec_check tying it to the first letter of the ptype, the section
+// casbin's methods name.
+func WithSectionColumn() Option {
+	return func(a *Adapter) {
+		a.secColumn = true
+	}
+}
+
+// createSectionColumn adds the sec column to the table if it lacks it,
+// fills it in and constrains it.
+func (a *Adapter) createSectionColumn(db orm.DB) error {
+	if err := ddl(db, "ALTER TABLE "+a.table+" ADD COLUMN IF NOT EXISTS sec VARCHAR(1)"); err != nil {
+		return err
+	}
+	if _, err := db.Exec("UPDATE " + a.table + " SET sec = left(" + a.ptypeCol() + ", 1) WHERE sec IS NULL"); err != nil {
+		return err
+	}
+
+	var exists bool
+	_, err := db.QueryOne(pg.Scan(&exists),
+		"SELECT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = ?::regclass AND conname = ?)", a.table, a.table+"_sec_check")
+	if err != nil || exists {
+		return err
+	}
+	return ddl(db, "ALTER TABLE "+a.table+" ADD CONSTRAINT "+a.table+"_sec_check CHECK (sec = left("+a.ptypeCol()+", 1))")
+}
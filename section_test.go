@@ -0,0 +1,115 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+)
+
+func TestSectionTables(t *testing.T) {
+	a := newTestAdapter(t, WithSectionTables("x_policy_p", "x_policy_g"))
+	a.open()
+	defer a.close()
+	defer a.db.Exec("DROP TABLE IF EXISTS x_policy_p, x_policy_g")
+	if _, err := a.db.Exec("TRUNCATE x_policy_p, x_policy_g"); err != nil {
+		t.Fatalf("TRUNCATE: %v", err)
+	}
+
+	count := func(table string) int {
+		var n int
+		if _, err := a.db.QueryOne(pg.Scan(&n), "SELECT count(*) FROM "+table); err != nil {
+			t.Fatalf("counting %s: %v", table, err)
+		}
+		return n
+	}
+
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	if err := a.AddPolicy("g", "g", []string{"bob", "data2_admin"}); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+	if err := a.AddPolicy("p", "p", []string{"carol", "data3", "read"}); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+	if err := a.RemovePolicy("p", "p", []string{"bob", "data2", "write"}); err != nil {
+		t.Fatalf("RemovePolicy: %v", err)
+	}
+	if p, g := count("x_policy_p"), count("x_policy_g"); p != 3 || g != 2 {
+		t.Errorf("x_policy_p has %d rows and x_policy_g %d, want 3 and 2", p, g)
+	}
+
+	m := newTestModel()
+	m.ClearPolicy()
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	wantP := [][]string{{"alice", "data1", "read"}, {"carol", "data3", "read"}, {"data2_admin", "data2", "read"}}
+	if got := m.GetPolicy("p", "p"); !reflect.DeepEqual(got, wantP) {
+		t.Errorf("loaded p = %q, want %q", got, wantP)
+	}
+	wantG := [][]string{{"alice", "data2_admin"}, {"bob", "data2_admin"}}
+	if got := m.GetPolicy("g", "g"); !reflect.DeepEqual(got, wantG) {
+		t.Errorf("loaded g = %q, want %q", got, wantG)
+	}
+}
+
+func TestSectionColumn(t *testing.T) {
+	old := newTestAdapter(t)
+	if err := old.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	old.close()
+
+	a := NewAdapter(old.options.User, old.options.Password, old.options.Database, old.options.Addr, WithSectionColumn())
+	defer a.close()
+	if err := a.AddPolicy("g", "g", []string{"bob", "data2_admin"}); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+
+	var rows []struct {
+		PType string
+		Sec   string
+	}
+	if _, err := a.db.Query(&rows, "SELECT p_type, sec FROM x_policy"); err != nil {
+		t.Fatalf("reading sec: %v", err)
+	}
+	if len(rows) != 5 {
+		t.Fatalf("got %d rows, want 5", len(rows))
+	}
+	for _, row := range rows {
+		if row.Sec != row.PType[:1] {
+			t.Errorf("%s rule has sec %q", row.PType, row.Sec)
+		}
+	}
+
+	lines, err := a.selectRules(context.Background(), "")
+	if err != nil {
+		t.Fatalf("selectRules: %v", err)
+	}
+	for _, line := range lines {
+		if line.Sec != line.PType[:1] {
+			t.Errorf("%s rule read with Sec %q", line.PType, line.Sec)
+		}
+	}
+
+	if _, err := a.db.Exec("INSERT INTO x_policy (p_type, v0, sec) VALUES ('p', 'mallory', 'g')"); err == nil {
+		t.Errorf("inserting a p rule in section g succeeded")
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// minServerVersion is the oldest server the adapter supports, as
+// server_version_num: 9.4, which added the to_regclass Open looks tables up
+// with. Some options need a newer server; see checkServer.
+const minServerVersion = 90400
+
+// ServerInfo describes the server an adapter connects to.
+type ServerInfo struct {
+	// Version is the server_version setting, such as "12.4".
+	Version string
+	// VersionNum is the server_version_num setting, such as 120004.
+	VersionNum int
+	// OnConflict reports INSERT ... ON CONFLICT support, from 9.5.
+	OnConflict bool
+	// AddColumnIfNotExists reports ALTER TABLE ... ADD COLUMN IF NOT EXISTS
+	// support, from 9.6, which the options adding columns rely on.
+	AddColumnIfNotExists bool
+}
+
+// TestConnection checks that the adapter can reach the server and returns
+// what it learned about it. It fails, as Open would, if the server is older
+// than the adapter or one of its options supports, returning the ServerInfo
+// all the same. On an adapter that is not open it connects as Open would,
+// TLS and WithOnConnect included, but unlike Open it neither creates the
+// policy table nor warms up the pool.
+func (a *Adapter) TestConnection(ctx context.Context) (ServerInfo, error) {
+	db := a.db
+	if db == nil {
+		options, err := a.connectOptions()
+		if err != nil {
+			return ServerInfo{}, err
+		}
+		db = connect(&options)
+		defer db.Close()
+	}
+	info, err := serverInfo(ctx, db)
+	if err != nil {
+		return info, err
+	}
+	return info, a.checkServer(info)
+}
+
+// serverInfo returns the ServerInfo of the server db connects to.
+func serverInfo(ctx context.Context, db *pg.DB) (ServerInfo, error) {
+	var info ServerInfo
+	var num string
+	_, err := db.WithContext(ctx).QueryOne(pg.Scan(&info.Version, &num),
+		"SELECT current_setting('server_version'), current_setting('server_version_num')")
+	if err != nil {
+		return info, err
+	}
+	if info.VersionNum, err = strconv.Atoi(num); err != nil {
+		return info, fmt.Errorf("adapter: invalid server_version_num %q", num)
+	}
+	info.OnConflict = info.VersionNum >= 90500
+	info.AddColumnIfNotExists = info.VersionNum >= 90600
+	return info, nil
+}
+
+// checkServer fails for a server older than minServerVersion, or than one of
+// the options in use needs. Open checks it before touching the table. Without
+// INSERT ... ON CONFLICT, before 9.5, the inserts of rules fall back to the
+// check of newLines, but WithUpsertOnAdd and WithPackedGrouping, which update
+// the rows they conflict with, cannot. The options adding columns to the
+// table need ADD COLUMN IF NOT EXISTS, from 9.6, and WithRuleHash a
+// generated column, from 12, unless the adapter leaves the table alone.
+func (a *Adapter) checkServer(info ServerInfo) error {
+	if info.VersionNum < minServerVersion {
+		return fmt.Errorf("adapter: PostgreSQL %s is too old, 9.4 or later is required", info.Version)
+	}
+	create := !a.readOnly && !a.noCreate
+	for _, need := range []struct {
+		option  string
+		used    bool
+		ok      bool
+		release string
+	}{
+		{"WithUpsertOnAdd", a.upsert, info.OnConflict, "9.5"},
+		{"WithPackedGrouping", len(a.packed) > 0 && !a.readOnly, info.OnConflict, "9.5"},
+		{"WithSoftDelete", a.softDelete && create, info.AddColumnIfNotExists, "9.6"},
+		{"WithTenantFromContext", a.tenantFunc != nil && create, info.AddColumnIfNotExists, "9.6"},
+		{"WithSectionColumn", a.secColumn && create, info.AddColumnIfNotExists, "9.6"},
+		{"WithTimestamps", a.timestamps && create, info.AddColumnIfNotExists, "9.6"},
+		{"WithIDColumn", a.idColumn && create, info.AddColumnIfNotExists, "9.6"},
+		{"WithRuleHash", a.ruleHash && create, info.VersionNum >= 120000, "12"},
+	} {
+		if need.used && !need.ok {
+			return fmt.Errorf("adapter: %s requires PostgreSQL %s or later, the server runs %s", need.option, need.release, info.Version)
+		}
+	}
+	return nil
+}
+
+// ifNotExists returns the IF NOT EXISTS of CREATE INDEX and CREATE SEQUENCE,
+// or "" before 9.5, which lacks it there: ddl then takes the duplicate_table
+// error of an existing index or sequence for success.
+func (a *Adapter) ifNotExists() string {
+	if a.serverBefore(90500) {
+		return ""
+	}
+	return "IF NOT EXISTS "
+}
+
+// serverBefore reports whether the server Open probed is older than num, a
+// server_version_num; an adapter that has not probed one assumes not.
+func (a *Adapter) serverBefore(num int) bool {
+	return a.server.VersionNum != 0 && a.server.VersionNum < num
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+)
+
+func TestTestConnection(t *testing.T) {
+	a := newTestAdapter(t)
+	info, err := a.TestConnection(context.Background())
+	if err != nil {
+		t.Fatalf("TestConnection: %v", err)
+	}
+	if a.db != nil {
+		t.Error("TestConnection opened the adapter")
+	}
+
+	a.open()
+	defer a.close()
+	var version string
+	if _, err := a.db.QueryOne(pg.Scan(&version), "SHOW server_version"); err != nil {
+		t.Fatalf("SHOW server_version: %v", err)
+	}
+	if info.Version != version {
+		t.Errorf("Version = %q, want %q", info.Version, version)
+	}
+	if info.VersionNum < minServerVersion || !info.OnConflict || !info.AddColumnIfNotExists {
+		t.Errorf("info = %+v, want a supported server", info)
+	}
+}
+
+func TestCheckServer(t *testing.T) {
+	v94 := ServerInfo{Version: "9.4.26", VersionNum: 90426}
+	v95 := ServerInfo{Version: "9.5.25", VersionNum: 90525, OnConflict: true}
+	v96 := ServerInfo{Version: "9.6.24", VersionNum: 90624, OnConflict: true, AddColumnIfNotExists: true}
+	v12 := ServerInfo{Version: "12.4", VersionNum: 120004, OnConflict: true, AddColumnIfNotExists: true}
+	for _, tt := range []struct {
+		info ServerInfo
+		opts []Option
+		ok   bool
+	}{
+		{ServerInfo{Version: "9.3.25", VersionNum: 90325}, nil, false},
+		{v94, nil, true},
+		{v94, []Option{WithConflictTarget(ConflictTarget{Constraint: "x_policy_rule_key"})}, true},
+		{v94, []Option{WithConflictTarget(ConflictTarget{Constraint: "x_policy_rule_key"}), WithUpsertOnAdd("priority")}, false},
+		{v95, []Option{WithConflictTarget(ConflictTarget{Constraint: "x_policy_rule_key"}), WithUpsertOnAdd("priority")}, true},
+		{v94, []Option{WithPackedGrouping("g")}, false},
+		{v94, []Option{WithPackedGrouping("g"), WithReadOnly()}, true},
+		{v95, []Option{WithTimestamps()}, false},
+		{v95, []Option{WithTimestamps(), WithoutCreateTable()}, true},
+		{v96, []Option{WithTimestamps(), WithSoftDelete()}, true},
+		{v96, []Option{WithRuleHash()}, false},
+		{v12, []Option{WithRuleHash()}, true},
+	} {
+		a := NewAdapter("", "", "", "", tt.opts...)
+		if err := a.checkServer(tt.info); (err == nil) != tt.ok {
+			t.Errorf("checkServer(%s) with %d options = %v, want ok %v", tt.info.Version, len(tt.opts), err, tt.ok)
+		}
+	}
+}
+
+func TestTestConnectionOptions(t *testing.T) {
+	certPEM, keyPEM := newTestCert(t)
+	a := NewAdapter("casbin", "", "casbin", "127.0.0.1:1", WithSSLRootCert(certPEM), WithSSLClientCert(certPEM, keyPEM),
+		WithOnConnect("SET search_path = public"))
+
+	var got pg.Options
+	defer func(orig func(*pg.Options) *pg.DB) { connect = orig }(connect)
+	connect = func(opt *pg.Options) *pg.DB {
+		got = *opt
+		return pg.Connect(opt)
+	}
+	a.TestConnection(context.Background())
+
+	if got.TLSConfig == nil || len(got.TLSConfig.Certificates) != 1 {
+		t.Errorf("TestConnection dialed with TLS config %v, want the client certificate", got.TLSConfig)
+	}
+	if got.OnConnect == nil {
+		t.Error("TestConnection dialed without the WithOnConnect statements")
+	}
+
+	bad := NewAdapter("casbin", "", "casbin", "127.0.0.1:1", WithSSLRootCert([]byte("not a certificate")))
+	if _, err := bad.TestConnection(context.Background()); err == nil {
+		t.Error("TestConnection with an invalid root certificate: err = nil")
+	}
+}
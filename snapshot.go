@@ -0,0 +1,162 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+// ErrSnapshotNotFound is returned by RestoreSnapshot for a version that holds
+// no rules.
+var ErrSnapshotNotFound = errors.New("adapter: snapshot not found")
+
+// WithSnapshotOnSave makes SavePolicy copy the rules it is about to replace
+// into <prefix>x_policy_history, created on open, within the save's
+// transaction, for RestoreSnapshot to roll back to. Each snapshot gets the
+// next version of the table's sequence and the time of the save; with
+// tenants, it holds the tenant's rules only. Replacing an empty table leaves
+// no snapshot. Snapshots are kept until deleted from the table.
+func WithSnapshotOnSave() Option {
+	return func(a *Adapter) {
+		a.snapshotOnSave = true
+	}
+}
+
+// Snapshot describes a snapshot taken by WithSnapshotOnSave.
+type Snapshot struct {
+	Version int64
+	SavedAt time.Time
+	Rules   int
+}
+
+func (a *Adapter) historySeq() string {
+	return a.historyTable + "_version_seq"
+}
+
+func (a *Adapter) createHistoryTable(db orm.DB) error {
+	if err := ddl(db, "CREATE SEQUENCE "+a.ifNotExists()+a.historySeq()); err != nil {
+		return err
+	}
+	defs := append([]string{"version BIGINT NOT NULL", "saved_at TIMESTAMPTZ NOT NULL DEFAULT now()"}, a.columnDefs()...)
+	defs = append(defs, "tenant VARCHAR(256) NOT NULL DEFAULT ''")
+	if err := ddl(db, "CREATE TABLE IF NOT EXISTS "+a.historyTable+" ("+strings.Join(defs, ", ")+")"); err != nil {
+		return err
+	}
+	if a.secColumn {
+		if err := ddl(db, "ALTER TABLE "+a.historyTable+" ADD COLUMN IF NOT EXISTS sec VARCHAR(1)"); err != nil {
+			return err
+		}
+	}
+	return ddl(db, "CREATE INDEX "+a.ifNotExists()+a.historyTable+"_version_idx ON "+a.historyTable+" (version)")
+}
+
+// historyScope returns the condition narrowing the history to the tenant of
+// ctx, which the history's lack of a row-level security policy leaves to the
+// adapter even under WithRowLevelSecurity.
+func (a *Adapter) historyScope(ctx context.Context) (string, []interface{}, error) {
+	if a.tenantFunc == nil {
+		return "TRUE", nil, nil
+	}
+	tenant, err := a.tenant(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	return "tenant = ?", []interface{}{tenant}, nil
+}
+
+// snapshot copies the live rules of the tenant of ctx into the history as
+// the next version.
+func (a *Adapter) snapshot(ctx context.Context, tx *pg.Tx) error {
+	var version int64
+	if _, err := tx.QueryOne(pg.Scan(&version), a.tag(ctx, "SELECT nextval('"+a.historySeq()+"')")); err != nil {
+		return err
+	}
+
+	at, atParams := a.stamp()
+	cols := strings.Join(a.cols, ", ")
+	if a.tenantFunc != nil {
+		cols += ", tenant"
+	}
+	if a.secColumn {
+		cols += ", sec"
+	}
+	for _, b := range a.sections() {
+		where, params, err := b.scope(ctx, "TRUE")
+		if err != nil {
+			return err
+		}
+		if a.softDelete {
+			where += " AND deleted_at IS NULL"
+		}
+		query := "INSERT INTO " + a.historyTable + " (version, saved_at, " + cols + ") SELECT ?, " + at + ", " + cols + " FROM " + b.table + " WHERE " + where
+		if _, err := tx.Exec(a.tag(ctx, query), append(append([]interface{}{version}, atParams...), params...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Snapshots returns the snapshots of the tenant of ctx, oldest first.
+func (a *Adapter) Snapshots(ctx context.Context) ([]Snapshot, error) {
+	a.open()
+
+	where, params, err := a.historyScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []Snapshot
+	_, err = a.conn(ctx).Query(&snapshots, a.tag(ctx,
+		"SELECT version, min(saved_at) AS saved_at, count(*) AS rules FROM "+a.historyTable+
+			" WHERE "+where+" GROUP BY version ORDER BY version"), params...)
+	return snapshots, err
+}
+
+// RestoreSnapshot replaces the rules of the tenant of ctx with those of the
+// snapshot version, as SavePolicy would, snapshotting the rules it replaces
+// in turn, so that a restore can itself be rolled back. It fails with
+// ErrSnapshotNotFound if the tenant has no such snapshot.
+func (a *Adapter) RestoreSnapshot(ctx context.Context, version int64) error {
+	if a.readOnly {
+		return ErrReadOnly
+	}
+	a.open()
+
+	where, params, err := a.historyScope(ctx)
+	if err != nil {
+		return err
+	}
+	var lines []CasbinRule
+	_, err = a.conn(ctx).Query(&lines, a.tag(ctx,
+		"SELECT "+strings.Join(a.selectList(), ", ")+" FROM "+a.historyTable+
+			" WHERE version = ? AND "+where+" ORDER BY "+strings.Join(a.cols, ", ")), append([]interface{}{version}, params...)...)
+	if err != nil {
+		return err
+	}
+	if len(lines) == 0 {
+		return ErrSnapshotNotFound
+	}
+
+	rules := make([][]string, len(lines))
+	for i, line := range lines {
+		rules[i] = append([]string{line.PType}, a.lineRule(line)...)
+	}
+	return a.save(ctx, rules)
+}
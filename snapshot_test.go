@@ -0,0 +1,74 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestRestoreSnapshot(t *testing.T) {
+	a := newTestAdapter(t, WithSnapshotOnSave())
+	a.open()
+	defer a.close()
+	if _, err := a.db.Exec("TRUNCATE x_policy_history"); err != nil {
+		t.Fatalf("TRUNCATE: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("first SavePolicy: %v", err)
+	}
+	m := newTestModel()
+	m.ClearPolicy()
+	m.AddPolicy("p", "p", []string{"hank", "data9", "read"})
+	if err := a.SavePolicy(m); err != nil {
+		t.Fatalf("second SavePolicy: %v", err)
+	}
+
+	snapshots, err := a.Snapshots(ctx)
+	if err != nil {
+		t.Fatalf("Snapshots: %v", err)
+	}
+	if len(snapshots) != 1 || snapshots[0].Rules != 4 || snapshots[0].SavedAt.IsZero() {
+		t.Fatalf("snapshots = %+v, want one of the first save's 4 rules", snapshots)
+	}
+
+	if err := a.RestoreSnapshot(ctx, snapshots[0].Version); err != nil {
+		t.Fatalf("RestoreSnapshot: %v", err)
+	}
+	want := newTestModel()
+	m.ClearPolicy()
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	for _, sec := range []string{"p", "g"} {
+		if got, want := m.GetPolicy(sec, sec), want.GetPolicy(sec, sec); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s after restore = %q, want %q", sec, got, want)
+		}
+	}
+
+	snapshots, err = a.Snapshots(ctx)
+	if err != nil {
+		t.Fatalf("Snapshots: %v", err)
+	}
+	if len(snapshots) != 2 || snapshots[1].Rules != 1 {
+		t.Errorf("snapshots after restore = %+v, want hank's rule snapshotted too", snapshots)
+	}
+	if err := a.RestoreSnapshot(ctx, snapshots[1].Version+1); err != ErrSnapshotNotFound {
+		t.Errorf("RestoreSnapshot of a missing version: err = %v, want ErrSnapshotNotFound", err)
+	}
+}
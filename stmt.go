@@ -0,0 +1,175 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// WithPreparedStatements turns caching of prepared statements for the full
+// load, AddPolicy and RemovePolicy on or off. It is on by default. go-pg
+// binds each prepared statement to a connection of its own, so caching pins
+// up to three connections of the pool.
+func WithPreparedStatements(enabled bool) Option {
+	return func(a *Adapter) {
+		a.noPrepare = !enabled
+	}
+}
+
+// WithPgBouncerCompatible makes the adapter safe to use through PgBouncer in
+// transaction pooling mode, where consecutive statements of a session may run
+// on different server connections. It turns off prepared statements, which
+// live on the connection that prepared them. The adapter sets nothing at
+// session level: the isolation level and lock timeout are set per
+// transaction, with SET TRANSACTION and SET LOCAL.
+//
+// Some features still need a session and do not work in this mode: a
+// Watcher, which LISTENs on its connection, and WithWarmup, whose
+// connections PgBouncer does not keep open to the server. Point them at
+// Postgres directly or at a session-pooled PgBouncer database.
+func WithPgBouncerCompatible() Option {
+	return func(a *Adapter) {
+		a.noPrepare = true
+	}
+}
+
+// stmtQuery runs query, written with ? placeholders, through a cached
+// prepared statement and scans the rows into model. Statements belong to
+// connections of the pool, so an adapter bound to a transaction runs query
+// directly instead, as does a query tagged by WithQueryTag.
+func (a *Adapter) stmtQuery(ctx context.Context, model interface{}, query string, params ...interface{}) (pg.Result, error) {
+	if tagged := a.tag(ctx, query); a.noPrepare || a.tx != nil || tagged != query {
+		return a.conn(ctx).Query(model, tagged, params...)
+	}
+	return a.withStmt(query, func(stmt *pg.Stmt) (pg.Result, error) {
+		return stmt.QueryContext(ctx, model, params...)
+	})
+}
+
+// stmtExec is stmtQuery for statements that return no rows.
+func (a *Adapter) stmtExec(ctx context.Context, query string, params ...interface{}) (pg.Result, error) {
+	if tagged := a.tag(ctx, query); a.noPrepare || a.tx != nil || tagged != query {
+		return a.conn(ctx).Exec(tagged, params...)
+	}
+	return a.withStmt(query, func(stmt *pg.Stmt) (pg.Result, error) {
+		return stmt.ExecContext(ctx, params...)
+	})
+}
+
+// withStmt calls fn with the prepared statement for query, preparing and
+// caching it on first use. The cache is keyed by query text, which names the
+// table. A statement that fails is evicted so the next call prepares it
+// afresh; if the failure means the server no longer accepts the statement
+// (the table was recreated underneath it, say), fn is retried once.
+func (a *Adapter) withStmt(query string, fn func(stmt *pg.Stmt) (pg.Result, error)) (pg.Result, error) {
+	for attempt := 0; ; attempt++ {
+		stmt, err := a.stmt(query)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := fn(stmt)
+		if err == nil {
+			return res, nil
+		}
+		a.evictStmt(query, stmt)
+		if attempt > 0 || !isStaleStmt(err) {
+			return nil, err
+		}
+	}
+}
+
+// stmtCache holds the prepared statements of an adapter, keyed by query
+// text. It is shared with the adapters WithTx derives.
+type stmtCache struct {
+	mu    sync.Mutex
+	stmts map[string]*pg.Stmt
+}
+
+func (a *Adapter) stmt(query string) (*pg.Stmt, error) {
+	c := a.stmts
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if stmt, ok := c.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := a.db.Prepare(numberPlaceholders(query))
+	if err != nil {
+		return nil, err
+	}
+	if c.stmts == nil {
+		c.stmts = make(map[string]*pg.Stmt)
+	}
+	c.stmts[query] = stmt
+	return stmt, nil
+}
+
+func (a *Adapter) evictStmt(query string, stmt *pg.Stmt) {
+	c := a.stmts
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stmts[query] == stmt {
+		delete(c.stmts, query)
+		stmt.Close()
+	}
+}
+
+// closeStmts closes every cached statement. It is called when the adapter's
+// connection pool goes away, since the statements die with it.
+func (a *Adapter) closeStmts() {
+	c := a.stmts
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, stmt := range c.stmts {
+		stmt.Close()
+	}
+	c.stmts = nil
+}
+
+// isStaleStmt reports whether err means a prepared statement must be
+// prepared again: it no longer exists on the server (26000) or its cached
+// plan no longer matches the table (0A000).
+func isStaleStmt(err error) bool {
+	pgErr, ok := err.(pg.Error)
+	if !ok {
+		return false
+	}
+	code := pgErr.Field('C')
+	return code == "26000" || code == "0A000"
+}
+
+// numberPlaceholders rewrites the ? placeholders of query as $1, $2, ...,
+// which is what Postgres expects in a prepared statement.
+func numberPlaceholders(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"strings"
+)
+
+// WithQueryTag prepends the tag fn returns for the context of a call, such
+// as "request_id=42", to the queries the call runs as a /* request_id=42 */
+// comment, so that slow queries in pg_stat_statements or the server log can
+// be matched with application logs. An empty tag adds no comment. Comment
+// delimiters and ? placeholders are removed from the tag, so it cannot end the
+// comment early or bind parameters. Tagged queries bypass the prepared
+// statement cache, and the DDL of Open and SavePolicy's TRUNCATE or DROP are
+// not tagged.
+func WithQueryTag(fn func(ctx context.Context) string) Option {
+	return func(a *Adapter) {
+		a.tagFunc = fn
+	}
+}
+
+// tag returns query with the tag of ctx prepended as a comment.
+func (a *Adapter) tag(ctx context.Context, query string) string {
+	if a.tagFunc == nil {
+		return query
+	}
+	tag := sanitizeTag(a.tagFunc(ctx))
+	if tag == "" {
+		return query
+	}
+	return "/* " + tag + " */ " + query
+}
+
+// sanitizeTag removes from tag whatever could end or nest a comment or be
+// taken for a placeholder.
+func sanitizeTag(tag string) string {
+	for {
+		clean := strings.NewReplacer("*/", "", "/*", "", "?", "").Replace(tag)
+		if clean == tag {
+			return strings.TrimSpace(clean)
+		}
+		tag = clean
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"testing"
+)
+
+type requestIDKey struct{}
+
+func requestIDTag(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	if id == "" {
+		return ""
+	}
+	return "request_id=" + id
+}
+
+func TestSanitizeTag(t *testing.T) {
+	for tag, want := range map[string]string{
+		"request_id=42":         "request_id=42",
+		"x */ DROP TABLE y; /*": "x  DROP TABLE y;",
+		"a **// b":              "a  b",
+		"a /*/ b":               "a / b",
+		"id=? ?name":            "id= name",
+	} {
+		if got := sanitizeTag(tag); got != want {
+			t.Errorf("sanitizeTag(%q) = %q, want %q", tag, got, want)
+		}
+	}
+}
+
+func TestQueryTag(t *testing.T) {
+	hook := &recordingHook{}
+	a := newTestAdapter(t, WithQueryHook(hook), WithQueryTag(requestIDTag))
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "r-1")
+	if _, err := a.GetPoliciesBySubject(ctx, "alice"); err != nil {
+		t.Fatalf("GetPoliciesBySubject: %v", err)
+	}
+	if !hook.saw("/* request_id=r-1 */ SELECT") {
+		t.Errorf("tagged query not seen: %q", hook.queries)
+	}
+	if hook.saw("/*  */") {
+		t.Errorf("empty tag added a comment: %q", hook.queries)
+	}
+}
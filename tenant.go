@@ -0,0 +1,128 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/casbin/casbin/model"
+)
+
+// WithTenantFromContext scopes every operation to the tenant fn derives from
+// the context, from a JWT claim say, so that tenants sharing the table see
+// and change only their own rules. The tenant is stored in a tenant column,
+// added to the table on open. Methods taking a context pass it to fn; the
+// casbin Adapter methods pass the context given to WithContext, or
+// context.Background(). If fn fails, so does the operation.
+//
+// SavePolicy replaces only the tenant's rules, so it deletes them whatever
+// the save mode. ArchiveTable is not scoped; it moves every tenant's rules.
+func WithTenantFromContext(fn func(ctx context.Context) (string, error)) Option {
+	return func(a *Adapter) {
+		a.tenantFunc = fn
+	}
+}
+
+// ReplaceTenantPolicies replaces the rules of tenant with those of m in one
+// transaction, as SavePolicy does for the tenant of its context, leaving the
+// other tenants' rules alone. The tenant is taken as given rather than from
+// ctx, for administering one tenant on behalf of another. It requires
+// WithTenantFromContext.
+func (a *Adapter) ReplaceTenantPolicies(ctx context.Context, tenant string, m model.Model) error {
+	if a.tenantFunc == nil {
+		return fmt.Errorf("adapter: ReplaceTenantPolicies requires WithTenantFromContext")
+	}
+	a.open()
+
+	b := *a
+	b.ctx = ctx
+	b.tenantFunc = func(context.Context) (string, error) {
+		return tenant, nil
+	}
+	return b.SavePolicy(m)
+}
+
+// WithContext returns an adapter whose casbin Adapter methods, which take no
+// context, use ctx, so that an enforcer can be built per request. The
+// returned adapter shares a's configuration and connection pool.
+func (a *Adapter) WithContext(ctx context.Context) *Adapter {
+	a.open()
+
+	b := *a
+	b.ctx = ctx
+	return &b
+}
+
+// WithContextTimeout bounds the casbin Adapter methods, which take no
+// context, to d, so that a legacy caller cannot hang on an unresponsive
+// database. It applies to the context given to WithContext too, unless that
+// context has a deadline of its own. Methods taking a context are left to
+// the caller's context.
+func WithContextTimeout(d time.Duration) Option {
+	return func(a *Adapter) {
+		a.ctxTimeout = d
+	}
+}
+
+// context returns the context of the methods that take none, which must call
+// cancel when done.
+func (a *Adapter) context() (ctx context.Context, cancel context.CancelFunc) {
+	ctx = a.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if _, ok := ctx.Deadline(); ok || a.ctxTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, a.ctxTimeout)
+}
+
+// tenant returns the tenant of ctx, or "" without WithTenantFromContext.
+func (a *Adapter) tenant(ctx context.Context) (string, error) {
+	if a.tenantFunc == nil {
+		return "", nil
+	}
+	return a.tenantFunc(ctx)
+}
+
+// scope narrows the condition where to the tenant of ctx, putting the
+// tenant's parameter before params. An empty where matches every row. Under
+// WithRowLevelSecurity where is left to the table's policy to narrow.
+func (a *Adapter) scope(ctx context.Context, where string, params ...interface{}) (string, []interface{}, error) {
+	if a.tenantFunc == nil || a.rlsSetting != "" {
+		return where, params, nil
+	}
+	tenant, err := a.tenantFunc(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	where, params = a.scopeTenant(tenant, where, params...)
+	return where, params, nil
+}
+
+// scopeTenant is scope for the rules of tenant rather than of the tenant of
+// a context.
+func (a *Adapter) scopeTenant(tenant, where string, params ...interface{}) (string, []interface{}) {
+	if a.tenantFunc == nil || a.rlsSetting != "" {
+		return where, params
+	}
+	cond := "tenant = ?"
+	if where != "" {
+		cond += " AND " + where
+	}
+	return cond, append([]interface{}{tenant}, params...)
+}
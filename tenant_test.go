@@ -0,0 +1,136 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type tenantKey struct{}
+
+func tenantFromContext(ctx context.Context) (string, error) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	if !ok {
+		return "", errors.New("no tenant in context")
+	}
+	return tenant, nil
+}
+
+func TestTenantFromContext(t *testing.T) {
+	a := newTestAdapter(t, WithTenantFromContext(tenantFromContext))
+	acme := context.WithValue(context.Background(), tenantKey{}, "acme")
+	globex := context.WithValue(context.Background(), tenantKey{}, "globex")
+
+	if err := a.WithContext(acme).SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy for acme: %v", err)
+	}
+	m := newTestModel()
+	m.ClearPolicy()
+	m.AddPolicy("p", "p", []string{"hank", "data9", "read"})
+	if err := a.WithContext(globex).SavePolicy(m); err != nil {
+		t.Fatalf("SavePolicy for globex: %v", err)
+	}
+	if err := a.WithContext(globex).RemovePolicy("p", "p", []string{"alice", "data1", "read"}); err != nil {
+		t.Fatalf("RemovePolicy for globex: %v", err)
+	}
+
+	policies, err := a.GetAllPolicies(acme)
+	if err != nil {
+		t.Fatalf("GetAllPolicies for acme: %v", err)
+	}
+	if len(policies["p"]) != 3 || len(policies["g"]) != 1 {
+		t.Errorf("acme sees %q, want its 4 rules only", policies)
+	}
+
+	m.ClearPolicy()
+	if err := a.WithContext(globex).LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy for globex: %v", err)
+	}
+	if got := m.GetPolicy("p", "p"); len(got) != 1 || got[0][0] != "hank" {
+		t.Errorf("globex loaded %q, want only hank's rule", got)
+	}
+
+	if err := a.LoadPolicy(m); err == nil {
+		t.Errorf("LoadPolicy without a tenant in the context succeeded")
+	}
+}
+
+func TestContextTimeout(t *testing.T) {
+	a := newTestAdapter(t, WithContextTimeout(100*time.Millisecond))
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+
+	holder, err := a.db.Begin()
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	defer holder.Rollback()
+	if _, err := holder.Exec("LOCK TABLE x_policy IN ACCESS EXCLUSIVE MODE"); err != nil {
+		t.Fatalf("LOCK TABLE: %v", err)
+	}
+
+	start := time.Now()
+	m := newTestModel()
+	m.ClearPolicy()
+	if err := a.LoadPolicy(m); err == nil {
+		t.Fatal("LoadPolicy on a locked table: err = nil, want a timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("LoadPolicy took %v to abort, want about 100ms", elapsed)
+	}
+}
+
+func TestReplaceTenantPolicies(t *testing.T) {
+	a := newTestAdapter(t, WithTenantFromContext(tenantFromContext))
+	acme := context.WithValue(context.Background(), tenantKey{}, "acme")
+	globex := context.WithValue(context.Background(), tenantKey{}, "globex")
+
+	if err := a.WithContext(acme).SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy for acme: %v", err)
+	}
+	if err := a.WithContext(globex).SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy for globex: %v", err)
+	}
+
+	m := newTestModel()
+	m.ClearPolicy()
+	m.AddPolicy("p", "p", []string{"hank", "data9", "read"})
+	if err := a.ReplaceTenantPolicies(context.Background(), "globex", m); err != nil {
+		t.Fatalf("ReplaceTenantPolicies: %v", err)
+	}
+
+	policies, err := a.GetAllPolicies(globex)
+	if err != nil {
+		t.Fatalf("GetAllPolicies for globex: %v", err)
+	}
+	if len(policies["p"]) != 1 || policies["p"][0][0] != "hank" || len(policies["g"]) != 0 {
+		t.Errorf("globex has %q, want only hank's rule", policies)
+	}
+	policies, err = a.GetAllPolicies(acme)
+	if err != nil {
+		t.Fatalf("GetAllPolicies for acme: %v", err)
+	}
+	if len(policies["p"]) != 3 || len(policies["g"]) != 1 {
+		t.Errorf("acme has %q, want its 4 rules untouched", policies)
+	}
+
+	if err := newTestAdapter(t).ReplaceTenantPolicies(context.Background(), "acme", m); err == nil {
+		t.Errorf("ReplaceTenantPolicies without tenants succeeded")
+	}
+}
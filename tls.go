@@ -0,0 +1,133 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+)
+
+// pemSource is PEM data given inline or as the path of a file, read on open.
+type pemSource struct {
+	data []byte
+	path string
+}
+
+func (s pemSource) isSet() bool {
+	return s.data != nil || s.path != ""
+}
+
+func (s pemSource) load() ([]byte, error) {
+	if s.path == "" {
+		return s.data, nil
+	}
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("adapter: %v", err)
+	}
+	return data, nil
+}
+
+// WithSSLRootCert verifies the server's certificate against the CA
+// certificates in pem, for a server whose certificate a private CA issued,
+// and turns TLS on if nothing else did. The host name is verified too,
+// unless a DSN's sslmode of allow, prefer or require turned verification
+// off.
+func WithSSLRootCert(pem []byte) Option {
+	return func(a *Adapter) {
+		a.sslRootCert = pemSource{data: pem}
+	}
+}
+
+// WithSSLRootCertFile is WithSSLRootCert with the CA certificates read from
+// path on open, as libpq's sslrootcert.
+func WithSSLRootCertFile(path string) Option {
+	return func(a *Adapter) {
+		a.sslRootCert = pemSource{path: path}
+	}
+}
+
+// WithSSLClientCert presents the client certificate certPEM, with its
+// private key keyPEM, to a server requiring mutual TLS, and turns TLS on if
+// nothing else did. Open fails unless both are given and they match.
+func WithSSLClientCert(certPEM, keyPEM []byte) Option {
+	return func(a *Adapter) {
+		a.sslCert = pemSource{data: certPEM}
+		a.sslKey = pemSource{data: keyPEM}
+	}
+}
+
+// WithSSLClientCertFile is WithSSLClientCert with the certificate and key
+// read from certPath and keyPath on open, as libpq's sslcert and sslkey.
+func WithSSLClientCertFile(certPath, keyPath string) Option {
+	return func(a *Adapter) {
+		a.sslCert = pemSource{path: certPath}
+		a.sslKey = pemSource{path: keyPath}
+	}
+}
+
+// tlsConfig returns the TLS configuration of the pool: that of the options,
+// extended with the certificates of WithSSLRootCert and WithSSLClientCert.
+func (a *Adapter) tlsConfig() (*tls.Config, error) {
+	if !a.sslRootCert.isSet() && !a.sslCert.isSet() && !a.sslKey.isSet() {
+		return a.options.TLSConfig, nil
+	}
+
+	var config *tls.Config
+	if a.options.TLSConfig != nil {
+		config = a.options.TLSConfig.Clone()
+	} else {
+		host, _, err := net.SplitHostPort(a.options.Addr)
+		if err != nil {
+			host = a.options.Addr
+		}
+		config = &tls.Config{ServerName: host}
+	}
+
+	if a.sslRootCert.isSet() {
+		pem, err := a.sslRootCert.load()
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("adapter: no CA certificates in the SSL root certificate")
+		}
+		config.RootCAs = pool
+	}
+
+	if a.sslCert.isSet() || a.sslKey.isSet() {
+		certPEM, err := a.sslCert.load()
+		if err != nil {
+			return nil, err
+		}
+		keyPEM, err := a.sslKey.load()
+		if err != nil {
+			return nil, err
+		}
+		if len(certPEM) == 0 || len(keyPEM) == 0 {
+			return nil, fmt.Errorf("adapter: the SSL client certificate and key must be given together")
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("adapter: SSL client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// newTestCert returns a self-signed certificate, good as a CA and as a
+// client certificate, and its private key, both PEM encoded.
+func newTestCert(t *testing.T) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "casbin"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestSSLClientCert(t *testing.T) {
+	certPEM, keyPEM := newTestCert(t)
+	a := NewAdapter("casbin", "", "casbin", "127.0.0.1:1", WithSSLRootCert(certPEM), WithSSLClientCert(certPEM, keyPEM))
+
+	var got *tls.Config
+	defer func(orig func(*pg.Options) *pg.DB) { connect = orig }(connect)
+	connect = func(opt *pg.Options) *pg.DB {
+		got = opt.TLSConfig
+		return pg.Connect(opt)
+	}
+	a.Open(context.Background())
+	defer a.Close()
+
+	if got == nil {
+		t.Fatal("Open connected without TLS")
+	}
+	if got.ServerName != "127.0.0.1" || got.RootCAs == nil || len(got.Certificates) != 1 {
+		t.Errorf("TLS config = server %q, roots %v, %d certificates; want the host, the CA and the client certificate",
+			got.ServerName, got.RootCAs, len(got.Certificates))
+	}
+	if !a.Info().TLS {
+		t.Errorf("Info().TLS = false")
+	}
+}
+
+func TestSSLClientCertFile(t *testing.T) {
+	certPEM, keyPEM := newTestCert(t)
+	dir, err := ioutil.TempDir("", "casbin-tls")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	certPath, keyPath := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	if err := ioutil.WriteFile(certPath, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewAdapter("casbin", "", "casbin", "db.internal", WithSSLRootCertFile(certPath), WithSSLClientCertFile(certPath, keyPath))
+	config, err := a.tlsConfig()
+	if err != nil {
+		t.Fatalf("tlsConfig: %v", err)
+	}
+	if config.ServerName != "db.internal" || config.RootCAs == nil || len(config.Certificates) != 1 {
+		t.Errorf("TLS config from files lacks the host, the CA or the client certificate")
+	}
+
+	a = NewAdapter("casbin", "", "casbin", "db.internal", WithSSLRootCertFile(filepath.Join(dir, "missing.crt")))
+	if _, err := a.tlsConfig(); err == nil {
+		t.Errorf("tlsConfig with a missing root certificate file succeeded")
+	}
+}
+
+func TestSSLClientCertWithoutKey(t *testing.T) {
+	certPEM, _ := newTestCert(t)
+	a := NewAdapter("casbin", "", "casbin", "db.internal", WithSSLClientCert(certPEM, nil))
+	if err := a.Open(context.Background()); err == nil || !strings.Contains(err.Error(), "together") {
+		t.Errorf("Open with a certificate but no key: err = %v, want it to need both", err)
+	}
+}
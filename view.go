@@ -0,0 +1,92 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// WithReadFrom makes LoadPolicy, LoadFilteredPolicy, LoadPolicyWithContext
+// and LoadIncremental read from name, a view or materialized view with the
+// table's columns say, while every other method, writes included, uses the
+// table. The adapter neither creates nor refreshes name: loads see a
+// materialized view as of its last REFRESH MATERIALIZED VIEW. Open fails if
+// name is not a plain SQL identifier, and with WithSectionTables, which
+// would need a relation per table.
+func WithReadFrom(name string) Option {
+	return func(a *Adapter) {
+		a.readFrom = name
+	}
+}
+
+// WithTreatMissingTableAsEmpty makes LoadPolicy, LoadFilteredPolicy and
+// LoadPolicyWithContext find no rules in a policy table, or relation of
+// WithReadFrom, that does not exist, instead of failing, so that a service
+// whose table is created by DDL run elsewhere, under WithReadOnly say, can
+// start with an empty policy before the table is there. Without it a
+// missing table fails the load. Other methods fail either way.
+func WithTreatMissingTableAsEmpty() Option {
+	return func(a *Adapter) {
+		a.missingAsEmpty = true
+	}
+}
+
+// loadRules is selectRules for the loads, reading from the relation of
+// WithReadFrom if any, a page at a time under WithLoadPageSize, narrowed by
+// WithLoadFilter, finding no rules in a missing table under
+// WithTreatMissingTableAsEmpty and warning of narrow columns under
+// WithMinValueWidth.
+func (a *Adapter) loadRules(ctx context.Context, where string, params ...interface{}) ([]CasbinRule, error) {
+	if a.missingAsEmpty {
+		var lines []CasbinRule
+		for _, b := range a.sections() {
+			strict := *b
+			strict.missingAsEmpty = false
+			section, err := strict.loadRules(ctx, where, params...)
+			if pgErr, ok := err.(pg.Error); ok && pgErr.Field('C') == "42P01" {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, section...)
+		}
+		return lines, nil
+	}
+	if a.minWidth > 0 && a.logger != nil {
+		if err := a.warnNarrowColumns(ctx); err != nil {
+			return nil, err
+		}
+	}
+	where, params = a.filterLoad(where, params...)
+	a = a.reader()
+	if a.pageSize > 0 {
+		return a.pagedRules(ctx, where, params...)
+	}
+	return a.selectRules(ctx, where, params...)
+}
+
+// reader returns a with the relation of WithReadFrom, if any, in place of
+// the table, for the loads to read from.
+func (a *Adapter) reader() *Adapter {
+	if a.readFrom == "" {
+		return a
+	}
+	b := *a
+	b.table = a.readFrom
+	return &b
+}
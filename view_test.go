@@ -0,0 +1,135 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReadFrom(t *testing.T) {
+	a := newTestAdapter(t, WithReadFrom("x_policy_effective"))
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	defer a.close()
+	defer a.db.Exec("DROP MATERIALIZED VIEW IF EXISTS x_policy_effective")
+	if _, err := a.db.Exec("CREATE MATERIALIZED VIEW x_policy_effective AS SELECT * FROM x_policy"); err != nil {
+		t.Fatalf("CREATE MATERIALIZED VIEW: %v", err)
+	}
+
+	carol := []string{"carol", "data3", "read"}
+	if err := a.AddPolicy("p", "p", carol); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+	policies, err := a.GetAllPolicies(context.Background())
+	if err != nil {
+		t.Fatalf("GetAllPolicies: %v", err)
+	}
+	if len(policies["p"]) != 4 {
+		t.Errorf("table holds %q, want carol's rule written to it", policies["p"])
+	}
+
+	m := newTestModel()
+	m.ClearPolicy()
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if len(m.GetPolicy("p", "p")) != 3 || m.HasPolicy("p", "p", carol) {
+		t.Errorf("loaded %q before the refresh, want the view's 3 rules", m.GetPolicy("p", "p"))
+	}
+
+	if _, err := a.db.Exec("REFRESH MATERIALIZED VIEW x_policy_effective"); err != nil {
+		t.Fatalf("REFRESH MATERIALIZED VIEW: %v", err)
+	}
+	m.ClearPolicy()
+	if err := a.LoadPolicy(m); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if !m.HasPolicy("p", "p", carol) {
+		t.Errorf("loaded %q after the refresh, want carol's rule", m.GetPolicy("p", "p"))
+	}
+}
+
+func TestReadFromIncremental(t *testing.T) {
+	a := newTestAdapter(t, WithTimestamps(), WithIncrementalOverlap(0), WithReadFrom("x_policy_effective"))
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	defer a.close()
+	defer a.db.Exec("DROP MATERIALIZED VIEW IF EXISTS x_policy_effective")
+	if _, err := a.db.Exec("CREATE MATERIALIZED VIEW x_policy_effective AS SELECT * FROM x_policy"); err != nil {
+		t.Fatalf("CREATE MATERIALIZED VIEW: %v", err)
+	}
+
+	m := newTestModel()
+	m.ClearPolicy()
+	mark, err := a.LoadIncremental(context.Background(), m, time.Time{})
+	if err != nil {
+		t.Fatalf("LoadIncremental: %v", err)
+	}
+	carol := []string{"carol", "data3", "read"}
+	if err := a.AddPolicy("p", "p", carol); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+	if _, err := a.LoadIncremental(context.Background(), m, mark); err != nil {
+		t.Fatalf("LoadIncremental: %v", err)
+	}
+	if m.HasPolicy("p", "p", carol) {
+		t.Errorf("loaded carol's rule before the refresh, want the view's rules only")
+	}
+
+	if _, err := a.db.Exec("REFRESH MATERIALIZED VIEW x_policy_effective"); err != nil {
+		t.Fatalf("REFRESH MATERIALIZED VIEW: %v", err)
+	}
+	if _, err := a.LoadIncremental(context.Background(), m, mark); err != nil {
+		t.Fatalf("LoadIncremental: %v", err)
+	}
+	if !m.HasPolicy("p", "p", carol) {
+		t.Errorf("loaded %q after the refresh, want carol's rule", m.GetPolicy("p", "p"))
+	}
+}
+
+func TestTreatMissingTableAsEmpty(t *testing.T) {
+	a := newTestAdapter(t, WithTreatMissingTableAsEmpty())
+	if err := a.SavePolicy(newTestModel()); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	if _, err := a.db.Exec("DROP TABLE x_policy"); err != nil {
+		t.Fatalf("DROP TABLE: %v", err)
+	}
+
+	m := newTestModel()
+	if err := a.LoadPolicyWithContext(context.Background(), m); err != nil {
+		t.Fatalf("LoadPolicyWithContext of a missing table: %v", err)
+	}
+	if len(m.GetPolicy("p", "p")) != 0 || len(m.GetPolicy("g", "g")) != 0 {
+		t.Errorf("loaded %q and %q from a missing table, want no rules", m.GetPolicy("p", "p"), m.GetPolicy("g", "g"))
+	}
+	if err := a.LoadFilteredPolicy(m, Filter{PType: []string{"p"}}); err != nil {
+		t.Errorf("LoadFilteredPolicy of a missing table: %v", err)
+	}
+
+	strict := newTestAdapter(t)
+	strict.open()
+	defer strict.close()
+	if _, err := strict.db.Exec("DROP TABLE x_policy"); err != nil {
+		t.Fatalf("DROP TABLE: %v", err)
+	}
+	if err := strict.LoadPolicy(newTestModel()); err == nil {
+		t.Errorf("LoadPolicy of a missing table without the option: err = nil, want an error")
+	}
+}
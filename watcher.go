@@ -0,0 +1,263 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+// The operations a Change reports.
+const (
+	OpAdd            = "add"
+	OpUpdate         = "update"
+	OpRemove         = "remove"
+	OpRemoveFiltered = "remove_filtered"
+	OpSave           = "save"
+	// OpReload carries no rules: the whole policy should be reloaded.
+	OpReload = "reload"
+)
+
+// Change is the JSON payload of a policy change notification. Each rule is
+// the ptype followed by the values. UpdatePolicy reports the old rule, then
+// the new one; RemoveFilteredPolicy reports its filter values, starting at
+// FieldIndex, and RemoveFilteredPolicyIn each of its value sets as a rule.
+type Change struct {
+	Op         string     `json:"op"`
+	Rules      [][]string `json:"rules,omitempty"`
+	FieldIndex int        `json:"field_index,omitempty"`
+}
+
+// maxNotifyPayload is the largest payload Postgres accepts: it must be
+// shorter than 8000 bytes.
+const maxNotifyPayload = 7999
+
+// notifyPayload encodes c, or a bare OpReload if c is too large to send,
+// since a NOTIFY over the limit fails and aborts the change with it.
+func notifyPayload(c Change) string {
+	b, err := json.Marshal(c)
+	if err != nil || len(b) > maxNotifyPayload {
+		b, _ = json.Marshal(Change{Op: OpReload})
+	}
+	return string(b)
+}
+
+// WithNotify makes every change to the policy send a Change on channel with
+// pg_notify, for a Watcher in each replica to pick up. SavePolicy notifies in
+// its transaction, so the notification is delivered if and only if it
+// commits; the other methods notify once their change is made.
+func WithNotify(channel string) Option {
+	return func(a *Adapter) {
+		a.notifyChannel = channel
+	}
+}
+
+// notify sends c on the channel set with WithNotify, if any.
+func (a *Adapter) notify(db orm.DB, c Change) error {
+	if a.notifyChannel == "" {
+		return nil
+	}
+	_, err := db.Exec("SELECT pg_notify(?, ?)", a.notifyChannel, notifyPayload(c))
+	return err
+}
+
+// watcherRetryDelay is the wait before a Watcher first tries to listen
+// again after losing its connection, doubled for each failed attempt up to
+// maxWatcherRetryDelay. Tests shorten it.
+var watcherRetryDelay = 500 * time.Millisecond
+
+const maxWatcherRetryDelay = 30 * time.Second
+
+// Watcher is a casbin persist.Watcher over Postgres LISTEN/NOTIFY. It calls
+// the update callback with the payload of every notification on its channel:
+// a Change encoded as JSON, from an adapter configured WithNotify, or from
+// another Watcher's Update.
+type Watcher struct {
+	db      *pg.DB
+	channel string
+	ln      *pg.Listener
+	logger  Logger
+	reload  bool
+	done    chan struct{}
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	closeErr  error
+
+	mu       sync.Mutex
+	callback func(string)
+}
+
+// WatcherOption configures a Watcher.
+type WatcherOption func(*Watcher)
+
+// WithReloadOnReconnect makes the watcher call the update callback with an
+// OpReload payload each time it listens again after losing its connection,
+// since notifications sent while it was disconnected are never delivered.
+func WithReloadOnReconnect() WatcherOption {
+	return func(w *Watcher) {
+		w.reload = true
+	}
+}
+
+// NewWatcher listens on channel with a dedicated connection from a's pool.
+// If the connection drops, as when the server restarts or the backend is
+// terminated, the watcher listens again on a new one, retrying with a wait
+// that starts at half a second and doubles up to 30 seconds; the loss and
+// the recovery are logged with the logger of WithLogger, if any. The
+// watcher listens until ctx is done or it is closed, whichever comes first,
+// and then releases the connection.
+func NewWatcher(ctx context.Context, a *Adapter, channel string, opts ...WatcherOption) (*Watcher, error) {
+	if channel == "" {
+		return nil, fmt.Errorf("adapter: empty watcher channel")
+	}
+	if err := a.Open(ctx); err != nil {
+		return nil, err
+	}
+
+	w := &Watcher{
+		db:      a.db,
+		channel: channel,
+		ln:      a.db.Listen(ctx, channel),
+		logger:  a.logger,
+		done:    make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	go w.run(ctx)
+	return w, nil
+}
+
+func (w *Watcher) run(ctx context.Context) {
+	defer close(w.done)
+
+	// Receive does not return when ctx is done, so close the listener to
+	// unblock it.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			w.closeListener()
+		case <-stop:
+		}
+	}()
+
+	for {
+		_, payload, err := w.ln.Receive(ctx)
+		if err != nil {
+			if !w.relisten(ctx, err) {
+				return
+			}
+			if w.reload {
+				w.deliver(notifyPayload(Change{Op: OpReload}))
+			}
+			continue
+		}
+		w.deliver(payload)
+	}
+}
+
+// relisten listens on the channel again after Receive failed with err,
+// retrying until it succeeds or the watcher is closed, and reports whether
+// it succeeded. go-pg replaces a broken connection on the next use, and
+// Listen both takes that connection and issues the LISTEN on it.
+func (w *Watcher) relisten(ctx context.Context, err error) bool {
+	select {
+	case <-w.closed:
+		return false
+	default:
+	}
+	w.logf("adapter: watcher lost its connection on %s: %v", w.channel, err)
+
+	delay := watcherRetryDelay
+	for {
+		t := time.NewTimer(delay)
+		select {
+		case <-w.closed:
+			t.Stop()
+			return false
+		case <-t.C:
+		}
+
+		err := w.ln.Listen(ctx, w.channel)
+		if err == nil {
+			w.logf("adapter: watcher listening on %s again", w.channel)
+			return true
+		}
+		w.logf("adapter: watcher could not listen on %s again: %v", w.channel, err)
+		if delay *= 2; delay > maxWatcherRetryDelay {
+			delay = maxWatcherRetryDelay
+		}
+	}
+}
+
+// deliver calls the update callback, if any, with payload.
+func (w *Watcher) deliver(payload string) {
+	w.mu.Lock()
+	callback := w.callback
+	w.mu.Unlock()
+
+	if callback != nil {
+		callback(payload)
+	}
+}
+
+func (w *Watcher) logf(format string, v ...interface{}) {
+	if w.logger != nil {
+		w.logger.Printf(format, v...)
+	}
+}
+
+// closeListener closes the listener, once, releasing its connection.
+func (w *Watcher) closeListener() {
+	w.closeOnce.Do(func() {
+		close(w.closed)
+		w.closeErr = w.ln.Close()
+	})
+}
+
+// SetUpdateCallback sets the function called with each notification's
+// payload. A classic callback reloads the enforcer's policy.
+func (w *Watcher) SetUpdateCallback(callback func(string)) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.callback = callback
+	return nil
+}
+
+// Update tells every watcher on the channel, this one included, to reload
+// the whole policy.
+func (w *Watcher) Update() error {
+	_, err := w.db.Exec("SELECT pg_notify(?, ?)", w.channel, notifyPayload(Change{Op: OpReload}))
+	return err
+}
+
+// Close stops listening and waits for the callback in progress, if any, to
+// return. Closing a watcher whose context is done does nothing more.
+func (w *Watcher) Close() error {
+	w.closeListener()
+	<-w.done
+	return w.closeErr
+}
@@ -0,0 +1,196 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+func TestNotifyPayloadFallback(t *testing.T) {
+	small := Change{Op: OpAdd, Rules: [][]string{{"p", "alice", "data1", "read"}}}
+	if got, want := notifyPayload(small), `{"op":"add","rules":[["p","alice","data1","read"]]}`; got != want {
+		t.Errorf("notifyPayload = %s, want %s", got, want)
+	}
+
+	big := Change{Op: OpSave}
+	for i := 0; i < 1000; i++ {
+		big.Rules = append(big.Rules, []string{"p", fmt.Sprintf("user%d", i), "data", "read"})
+	}
+	if got, want := notifyPayload(big), `{"op":"reload"}`; got != want {
+		t.Errorf("notifyPayload of a large change = %.80s..., want %s", got, want)
+	}
+}
+
+// receiveChange waits for the next payload delivered to the watcher's
+// callback and decodes it.
+func receiveChange(t *testing.T, payloads <-chan string) Change {
+	t.Helper()
+	select {
+	case payload := <-payloads:
+		var c Change
+		if err := json.Unmarshal([]byte(payload), &c); err != nil {
+			t.Fatalf("payload %q is not a Change: %v", payload, err)
+		}
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no notification received")
+		return Change{}
+	}
+}
+
+func TestWatcherOversizedSave(t *testing.T) {
+	a := newTestAdapter(t, WithNotify("casbin_test"))
+	w, err := NewWatcher(context.Background(), a, "casbin_test")
+	if err != nil {
+		t.Fatalf("NewWatcher: %v", err)
+	}
+	defer w.Close()
+	payloads := make(chan string, 10)
+	w.SetUpdateCallback(func(payload string) { payloads <- payload })
+
+	if err := a.AddPolicy("p", "p", []string{"alice", "data1", "read"}); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+	if c := receiveChange(t, payloads); c.Op != OpAdd || len(c.Rules) != 1 {
+		t.Errorf("AddPolicy notified %+v", c)
+	}
+
+	m := newTestModel()
+	for i := 0; i < 1000; i++ {
+		m.AddPolicy("p", "p", []string{fmt.Sprintf("user%d", i), "data", "read"})
+	}
+	if err := a.SavePolicy(m); err != nil {
+		t.Fatalf("SavePolicy of a large model: %v", err)
+	}
+	if c := receiveChange(t, payloads); c.Op != OpReload {
+		t.Errorf("large SavePolicy notified %q, want %q", c.Op, OpReload)
+	}
+
+	lines, err := a.selectRules(context.Background(), "")
+	if err != nil {
+		t.Fatalf("selectRules: %v", err)
+	}
+	if len(lines) != 1004 {
+		t.Errorf("%d rows after the large save, want 1004", len(lines))
+	}
+
+	if err := w.Update(); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if c := receiveChange(t, payloads); c.Op != OpReload {
+		t.Errorf("Update notified %q, want %q", c.Op, OpReload)
+	}
+}
+
+func TestWatcherContextCanceled(t *testing.T) {
+	a := newTestAdapter(t, WithNotify("casbin_test"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := NewWatcher(ctx, a, "casbin_test")
+	if err != nil {
+		t.Fatalf("NewWatcher: %v", err)
+	}
+	defer a.close()
+	payloads := make(chan string, 10)
+	w.SetUpdateCallback(func(payload string) { payloads <- payload })
+	if err := w.Update(); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	receiveChange(t, payloads)
+
+	// listeners counts the sessions whose last statement was the watcher's
+	// LISTEN.
+	listeners := func() int {
+		var n int
+		if _, err := a.db.QueryOne(pg.Scan(&n), "SELECT count(*) FROM pg_stat_activity "+
+			"WHERE datname = current_database() AND pid <> pg_backend_pid() AND query ILIKE 'listen%casbin_test%'"); err != nil {
+			t.Fatalf("pg_stat_activity: %v", err)
+		}
+		return n
+	}
+	if n := listeners(); n != 1 {
+		t.Fatalf("%d sessions listening before the cancel, want 1", n)
+	}
+
+	cancel()
+	select {
+	case <-w.done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("watcher still listening 5s after its context was canceled")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for listeners() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("listening connection still open 5s after the cancel")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := a.db.PoolStats(); stats.IdleConns != stats.TotalConns {
+		t.Errorf("pool has %d connections, %d idle after the cancel, want none in use", stats.TotalConns, stats.IdleConns)
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("Close after the cancel: %v", err)
+	}
+}
+
+func TestWatcherReconnect(t *testing.T) {
+	defer func(d time.Duration) { watcherRetryDelay = d }(watcherRetryDelay)
+	watcherRetryDelay = 10 * time.Millisecond
+
+	var buf bytes.Buffer
+	a := newTestAdapter(t, WithNotify("casbin_test"), WithLogger(log.New(&buf, "", 0)))
+	w, err := NewWatcher(context.Background(), a, "casbin_test", WithReloadOnReconnect())
+	if err != nil {
+		t.Fatalf("NewWatcher: %v", err)
+	}
+	defer w.Close()
+	payloads := make(chan string, 10)
+	w.SetUpdateCallback(func(payload string) { payloads <- payload })
+	if err := w.Update(); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	receiveChange(t, payloads)
+
+	var killed int
+	if _, err := a.db.QueryOne(pg.Scan(&killed), "SELECT count(pg_terminate_backend(pid)) FROM pg_stat_activity "+
+		"WHERE datname = current_database() AND pid <> pg_backend_pid() AND query ILIKE 'listen%casbin_test%'"); err != nil {
+		t.Fatalf("pg_terminate_backend: %v", err)
+	}
+	if killed != 1 {
+		t.Fatalf("terminated %d listening sessions, want 1", killed)
+	}
+
+	if c := receiveChange(t, payloads); c.Op != OpReload {
+		t.Errorf("reconnect notified %q, want %q", c.Op, OpReload)
+	}
+	if err := a.AddPolicy("p", "p", []string{"alice", "data1", "read"}); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+	if c := receiveChange(t, payloads); c.Op != OpAdd || len(c.Rules) != 1 {
+		t.Errorf("AddPolicy after the reconnect notified %+v", c)
+	}
+	if !strings.Contains(buf.String(), "adapter: watcher listening on casbin_test again") {
+		t.Errorf("reconnect not logged: %q", buf.String())
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+// WithWriteBuffer makes AddPolicy queue its rules instead of inserting each
+// on its own, and insert the queue n rules at a time, in one transaction and
+// one INSERT per table, for bulk loads through casbin's AddPolicy that would
+// otherwise pay a round trip per rule. The queue is also written by Flush,
+// by Close and before any other write, so writes still reach the table in
+// the order they were made.
+//
+// This trades durability for throughput: an AddPolicy that returns nil has
+// only queued its rule, which is lost if the process dies before the queue
+// is written, and an error inserting it, a ptype the constraint of
+// WithPTypeConstraint rejects say, is returned by the call that writes the
+// queue. Reads do not see queued rules, so call Flush before reading back
+// what was just added. Adds through an adapter of WithTx are not queued.
+func WithWriteBuffer(n int) Option {
+	return func(a *Adapter) {
+		a.writes = &writeBuffer{size: n}
+	}
+}
+
+// writeBuffer is the queue of WithWriteBuffer, shared by an adapter and its
+// copies.
+type writeBuffer struct {
+	mu      sync.Mutex
+	size    int
+	pending []bufferedAdd
+}
+
+// bufferedAdd is a rule queued by AddPolicy, with the adapter of its table,
+// its row, its tenant and its insertParams.
+type bufferedAdd struct {
+	a      *Adapter
+	rule   []string
+	line   CasbinRule
+	tenant string
+	params []interface{}
+}
+
+// add queues a rule for a's table, writing the queue once it is full.
+func (w *writeBuffer) add(ctx context.Context, a *Adapter, rule []string, line CasbinRule, tenant string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = append(w.pending, bufferedAdd{a: a, rule: rule, line: line, tenant: tenant, params: a.insertParams(line, tenant)})
+	if len(w.pending) < w.size {
+		return nil
+	}
+	return w.flush(ctx)
+}
+
+// flush writes the queue. On failure the queue is kept, for a later flush
+// to try again. w.mu must be held.
+func (w *writeBuffer) flush(ctx context.Context) error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	a := w.pending[0].a
+
+	var tables []*Adapter
+	byTable := make(map[string][]bufferedAdd)
+	for _, add := range w.pending {
+		if _, ok := byTable[add.a.table]; !ok {
+			tables = append(tables, add.a)
+		}
+		byTable[add.a.table] = append(byTable[add.a.table], add)
+	}
+
+	err := a.runInTx(ctx, func(tx *pg.Tx) error {
+		var rules [][]string
+		for _, b := range tables {
+			adds := byTable[b.table]
+			for _, add := range adds {
+				rules = append(rules, add.rule)
+			}
+			if b.checkedInserts() {
+				if err := b.insertNewAdds(ctx, tx, adds); err != nil {
+					return err
+				}
+				continue
+			}
+			var params []interface{}
+			for _, add := range adds {
+				params = append(params, add.params...)
+			}
+			if _, err := tx.Exec(b.tag(ctx, b.addRowsQuery(len(adds))), params...); err != nil {
+				return err
+			}
+		}
+		return a.record(ctx, tx, Change{Op: OpAdd, Rules: rules})
+	})
+	if err != nil {
+		return err
+	}
+	a.observeRows(OpAdd, len(w.pending))
+	w.pending = nil
+	return nil
+}
+
+// insertNewAdds inserts those of adds, queued for the table of a, that
+// newLines reports new, for a server without ON CONFLICT.
+func (a *Adapter) insertNewAdds(ctx context.Context, db orm.DB, adds []bufferedAdd) error {
+	var tenants []string
+	byTenant := make(map[string][]bufferedAdd)
+	for _, add := range adds {
+		if _, ok := byTenant[add.tenant]; !ok {
+			tenants = append(tenants, add.tenant)
+		}
+		byTenant[add.tenant] = append(byTenant[add.tenant], add)
+	}
+
+	var params []interface{}
+	n := 0
+	for _, tenant := range tenants {
+		adds := byTenant[tenant]
+		lines := make([]CasbinRule, len(adds))
+		for i, add := range adds {
+			lines[i] = add.line
+		}
+		isNew, err := a.newLines(ctx, db, tenant, lines)
+		if err != nil {
+			return err
+		}
+		for i, add := range adds {
+			if isNew[i] {
+				params = append(params, add.params...)
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	_, err := db.Exec(a.tag(ctx, a.addRowsQuery(n)), params...)
+	return err
+}
+
+// Flush writes the rules WithWriteBuffer has queued, returning once they
+// are committed. Without WithWriteBuffer it does nothing.
+func (a *Adapter) Flush(ctx context.Context) error {
+	if a.writes == nil {
+		return nil
+	}
+	a.writes.mu.Lock()
+	defer a.writes.mu.Unlock()
+	return a.writes.flush(ctx)
+}
+
+// flushWrites is Flush ahead of a write, other than through WithTx, so that
+// the queued rules are written first.
+func (a *Adapter) flushWrites(ctx context.Context) error {
+	if a.tx != nil {
+		return nil
+	}
+	return a.Flush(ctx)
+}
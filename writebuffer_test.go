@@ -0,0 +1,93 @@
+// Copyright 2017 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"testing"
+)
+
+func TestWriteBuffer(t *testing.T) {
+	a := newTestAdapter(t, WithWriteBuffer(100))
+	m := newTestModel()
+	for ptype, ast := range m["p"] {
+		for _, rule := range ast.Policy {
+			if err := a.AddPolicy("p", ptype, rule); err != nil {
+				t.Fatalf("AddPolicy: %v", err)
+			}
+		}
+	}
+	if err := a.AddPolicy("g", "g", []string{"alice", "data2_admin"}); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+
+	ctx := context.Background()
+	policies, err := a.GetAllPolicies(ctx)
+	if err != nil {
+		t.Fatalf("GetAllPolicies: %v", err)
+	}
+	if len(policies) != 0 {
+		t.Errorf("table holds %q before Flush, want the rules still queued", policies)
+	}
+
+	if err := a.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	policies, err = a.GetAllPolicies(ctx)
+	if err != nil {
+		t.Fatalf("GetAllPolicies: %v", err)
+	}
+	if len(policies["p"]) != 3 || len(policies["g"]) != 1 {
+		t.Errorf("table holds %q after Flush, want the 4 rules added", policies)
+	}
+
+	carol := []string{"carol", "data3", "read"}
+	if err := a.AddPolicy("p", "p", carol); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+	if err := a.RemovePolicy("p", "p", carol); err != nil {
+		t.Errorf("RemovePolicy of a queued rule: %v", err)
+	}
+
+	if err := a.AddPolicy("p", "p", carol); err != nil {
+		t.Fatalf("AddPolicy: %v", err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	policies, err = a.GetAllPolicies(ctx)
+	if err != nil {
+		t.Fatalf("GetAllPolicies: %v", err)
+	}
+	if len(policies["p"]) != 4 {
+		t.Errorf("table holds %q after Close, want carol's queued rule written", policies["p"])
+	}
+}
+
+func TestWriteBufferFull(t *testing.T) {
+	a := newTestAdapter(t, WithWriteBuffer(2))
+	for _, rule := range [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"carol", "data3", "read"}} {
+		if err := a.AddPolicy("p", "p", rule); err != nil {
+			t.Fatalf("AddPolicy: %v", err)
+		}
+	}
+	policies, err := a.GetAllPolicies(context.Background())
+	if err != nil {
+		t.Fatalf("GetAllPolicies: %v", err)
+	}
+	if len(policies["p"]) != 2 {
+		t.Errorf("table holds %q, want the first 2 rules written once the queue filled", policies["p"])
+	}
+}